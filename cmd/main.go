@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
@@ -9,28 +10,42 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/Azure/go-autorest/autorest/azure"
-
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/audit"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/breaker"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cache"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cloud"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/policy"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/secretsync"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/server"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/tracing"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/utils"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/version"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 	logsapi "k8s.io/component-base/logs/api/v1"
 	json "k8s.io/component-base/logs/json"
 	"k8s.io/klog/v2"
+	secretsstoreclientset "sigs.k8s.io/secrets-store-csi-driver/pkg/client/clientset/versioned"
 	k8spb "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
 
 const (
 	readHeaderTimeout = 5 * time.Second
+	// telemetryShutdownTimeout is the time allowed to export the pending metrics and spans on termination
+	telemetryShutdownTimeout = 5 * time.Second
 )
 
 var (
@@ -44,17 +59,85 @@ var (
 	healthzPath    = flag.String("healthz-path", "/healthz", "path for health check")
 	healthzTimeout = flag.Duration("healthz-timeout", 5*time.Second, "RPC timeout for health check")
 
-	metricsBackend = flag.String("metrics-backend", "Prometheus", "Backend used for metrics")
-	prometheusPort = flag.Int("prometheus-port", 8898, "Prometheus port for metrics backend")
+	readyzPath                       = flag.String("readyz-path", "/readyz", "path for readiness check, served on the health check port")
+	readinessCheckManagedIdentity    = flag.Bool("readiness-check-managed-identity", false, "check that a Key Vault token can be requested with the node managed identity")
+	readinessManagedIdentityClientID = flag.String("readiness-managed-identity-client-id", "", "client ID of the user-assigned managed identity used for the readiness token check. The system-assigned managed identity is used if not set")
+	readinessCheckEndpoints          = flag.Bool("readiness-check-endpoints", false, "check that the Key Vault DNS and the Azure AD authority of the default cloud are reachable")
+	readinessMountErrorThreshold     = flag.Float64("readiness-mount-error-threshold", 0, "ratio of the recent mounts that failed because Key Vault or Azure AD were unavailable above which the provider isn't ready. Disabled if 0")
+	readinessMountErrorWindow        = flag.Duration("readiness-mount-error-window", 5*time.Minute, "window of the recent mounts used for the mount error ratio")
+	readinessMountErrorMinMounts     = flag.Int("readiness-mount-error-min-mounts", 5, "number of mounts in the window required to check the mount error ratio")
+	readinessCheckInterval           = flag.Duration("readiness-check-interval", time.Minute, "interval of the readiness checks")
+	readinessCheckTimeout            = flag.Duration("readiness-check-timeout", 10*time.Second, "timeout of the readiness checks")
+
+	metricsBackend            = flag.String("metrics-backend", "Prometheus", "comma separated list of the backends used for metrics. Allowed values: Prometheus, OTLP")
+	prometheusPort            = flag.Int("prometheus-port", 8898, "Prometheus port for metrics backend")
+	metricsOTLPEndpoint       = flag.String("metrics-otlp-endpoint", "", "host:port of the OTLP collector the metrics are exported to. The OTEL_EXPORTER_OTLP_* environment variables are used if not set")
+	metricsOTLPProtocol       = flag.String("metrics-otlp-protocol", "grpc", "protocol used to export the metrics to the OTLP collector. Allowed values: grpc, http")
+	metricsOTLPInsecure       = flag.Bool("metrics-otlp-insecure", false, "disable TLS for the connection to the OTLP metrics collector")
+	metricsOTLPExportInterval = flag.Duration("metrics-otlp-export-interval", time.Minute, "interval at which the metrics are exported to the OTLP collector")
+	metricsDropLabels         = flag.String("metrics-drop-labels", "", "comma separated list of the labels removed from the metrics to bound their cardinality, e.g. object_name,error")
+
+	tracingOTLPEndpoint = flag.String("tracing-otlp-endpoint", "", "host:port of the OTLP gRPC collector the trace spans are exported to. Tracing is disabled if not set")
+	tracingOTLPInsecure = flag.Bool("tracing-otlp-insecure", false, "disable TLS for the connection to the OTLP collector")
+	tracingSampleRatio  = flag.Float64("tracing-sample-ratio", 0.1, "ratio of the traces sampled when the caller didn't sample the parent span. Allowed values: 0 to 1")
 
 	constructPEMChain              = flag.Bool("construct-pem-chain", true, "explicitly reconstruct the pem chain in the order: SERVER, INTERMEDIATE, ROOT")
 	writeCertAndKeyInSeparateFiles = flag.Bool("write-cert-and-key-in-separate-files", false,
 		"Write cert and key in separate files. The individual files will be named as <secret-name>.crt and <secret-name>.key. These files will be created in addition to the single file.")
 
 	cloudName = flag.String("cloud-name", "AzurePublicCloud", "default cloud environment to use for Azure SDK if not provided in the SecretProviderClass. "+
-		"Allowed values: AzurePublicCloud, AzureUSGovernmentCloud, AzureChinaCloud, AzureGermanCloud or AzureStackCloud")
+		"Allowed values: AzurePublicCloud, AzureUSGovernmentCloud, AzureChinaCloud, AzureGermanCloud, AzureStackCloud or the name of a custom cloud environment")
+	customCloudEnvironmentFiles = flag.String("custom-cloud-environment-files", "", "comma separated list of custom cloud environment files to register at startup. "+
+		"The registered cloud environments can be referenced by name in the SecretProviderClass cloudName parameter.")
+
+	allowDisableChallengeResourceVerification = flag.Bool("allow-disable-challenge-resource-verification", false, "allow the SecretProviderClass to disable the verification "+
+		"that the key vault authentication challenge matches the domain of the keyvaultURL. The keyvaultURL then receives the key vault tokens of the identity")
+
+	allowKeyVaultProxyOverride = flag.Bool("allow-keyvault-proxy-override", false, "allow the SecretProviderClass to set keyvaultProxyURL and keyvaultCABundle. "+
+		"The proxy trusted with the CA bundle can read the key vault tokens of the identity")
+
+	enableLastKnownGoodCache = flag.Bool("enable-last-known-good-cache", false, "store the last successfully mounted content in an encrypted on-node cache "+
+		"and serve it when Key Vault or Azure AD can't be reached")
+	lastKnownGoodCacheDir     = flag.String("last-known-good-cache-dir", "/var/lib/csi-secrets-store-provider-azure/cache", "directory for the last known good cache entries")
+	lastKnownGoodCacheKeyFile = flag.String("last-known-good-cache-key-file", "", "file with the 32 byte key used to seal the last known good cache entries. "+
+		"A key is generated if the file doesn't exist. Defaults to <last-known-good-cache-dir>/key")
+	lastKnownGoodCacheMaxStaleness = flag.Duration("last-known-good-cache-max-staleness", 24*time.Hour, "maximum age of the last known good content that is served")
+
+	auditLogPath       = flag.String("audit-log-path", "", "file the audit log of the object versions returned for each pod is written to as JSON lines, '-' for stdout. The audit log is disabled if not set")
+	auditLogMaxSizeMB  = flag.Int("audit-log-max-size-mb", 100, "size in megabytes of the audit log file before it's rotated")
+	auditLogMaxBackups = flag.Int("audit-log-max-backups", 10, "number of rotated audit log files kept. All the files are kept if 0")
+	auditLogMaxAgeDays = flag.Int("audit-log-max-age-days", 30, "number of days the rotated audit log files are kept. The files aren't removed based on age if 0")
+
+	disablePodIdentity       = flag.Bool("disable-pod-identity", false, "reject the mounts that use the deprecated aad-pod-identity access mode")
+	disableVMManagedIdentity = flag.Bool("disable-vm-managed-identity", false, "reject the mounts that use the managed identity of the node access mode (useVMManagedIdentity)")
+	deprecatedAuthModesList  = flag.String("deprecated-auth-modes", auth.ModePodIdentity, "comma-separated list of the identity access modes reported as deprecated in the deprecated_auth_mode_total metric: "+
+		"podIdentity, managedIdentity, workloadIdentity or servicePrincipal. No mode is reported if empty")
+
+	policyFile = flag.String("policy-file", "", "file with the policy that restricts the identities and the vaults the namespaces and the service accounts can use. "+
+		"The mounts that aren't allowed are rejected before any token is requested. No policy is enforced if not set")
+
+	enableCircuitBreaker = flag.Bool("enable-circuit-breaker", false, "reject the mounts for a vault and an identity with the cached error after repeated failures "+
+		"caused by the vault or the identity, e.g. the vault was deleted or the identity lost access to the vault")
+	circuitBreakerFailureThreshold = flag.Int("circuit-breaker-failure-threshold", 5, "number of consecutive failed mounts for a vault and an identity that opens the circuit")
+	circuitBreakerOpenDuration     = flag.Duration("circuit-breaker-open-duration", 5*time.Minute, "duration the open circuit rejects the mounts before a probe mount is let through")
+
+	secretSyncController = flag.Bool("secret-sync-controller", false, "run the secret sync controller instead of the gRPC server. "+
+		"The controller syncs the secretObjects of the annotated SecretProviderClasses to Kubernetes secrets without a pod mount")
+	secretSyncResyncInterval         = flag.Duration("secret-sync-resync-interval", 2*time.Minute, "interval at which the secret sync controller fetches the objects and updates the Kubernetes secrets")
+	secretSyncWorkers                = flag.Int("secret-sync-workers", 5, "number of SecretProviderClasses synced concurrently by the secret sync controller")
+	secretSyncNamespaces             = flag.String("secret-sync-namespaces", "", "comma-separated list of the namespaces whose SecretProviderClasses are synced by the secret sync controller, \"*\" syncs all the namespaces")
+	secretSyncClientID               = flag.String("secret-sync-client-id", "", "client ID of the workload identity used by the secret sync controller. --secret-sync-token-file must be set")
+	secretSyncTokenFile              = flag.String("secret-sync-token-file", "", "projected service account token file used by the secret sync controller for workload identity")
+	secretSyncUseVMManagedIdentity   = flag.Bool("secret-sync-use-vm-managed-identity", false, "use the managed identity of the node in the secret sync controller")
+	secretSyncUserAssignedIdentityID = flag.String("secret-sync-user-assigned-identity-id", "", "client ID of the user-assigned managed identity of the node used by the secret sync controller. "+
+		"The system-assigned managed identity is used if not set")
+	kubeconfig = flag.String("kubeconfig", "", "path to the kubeconfig used by the secret sync controller. Uses the in-cluster config if not set")
 )
 
+// azureEnvironmentFilepathName is the environment variable used by go-autorest
+// to load the AzureStackCloud environment
+const azureEnvironmentFilepathName = "AZURE_ENVIRONMENT_FILEPATH"
+
 func main() {
 	klog.InitFlags(nil)
 	defer klog.Flush()
@@ -79,7 +162,13 @@ func main() {
 	}
 	klog.InfoS("Starting Azure Key Vault Provider", "version", version.BuildVersion)
 
-	cloudEnv, err := azure.EnvironmentFromName(*cloudName)
+	if *customCloudEnvironmentFiles != "" {
+		if err := cloud.RegisterFromFiles(strings.Split(*customCloudEnvironmentFiles, ",")); err != nil {
+			klog.ErrorS(err, "failed to register custom cloud environments")
+			os.Exit(1)
+		}
+	}
+	cloudEnv, err := getDefaultCloudEnvironment(*cloudName)
 	if err != nil {
 		klog.ErrorS(err, "failed validating default cloud environment", "cloudName", *cloudName)
 		os.Exit(1)
@@ -96,10 +185,42 @@ func main() {
 		}()
 	}
 	// initialize metrics exporter before creating measurements
-	if err = metrics.InitMetricsExporter(*metricsBackend, *prometheusPort); err != nil {
+	shutdownMetrics, err := metrics.InitMetricsExporter(context.Background(), metrics.Config{
+		Backends:           *metricsBackend,
+		PrometheusPort:     *prometheusPort,
+		OTLPEndpoint:       *metricsOTLPEndpoint,
+		OTLPProtocol:       *metricsOTLPProtocol,
+		OTLPInsecure:       *metricsOTLPInsecure,
+		OTLPExportInterval: *metricsOTLPExportInterval,
+		DropLabels:         strings.Split(*metricsDropLabels, ","),
+	})
+	if err != nil {
 		klog.ErrorS(err, "failed to initialize metrics exporter")
 		os.Exit(1)
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+		defer cancel()
+		if err := shutdownMetrics(ctx); err != nil {
+			klog.ErrorS(err, "failed to flush metrics")
+		}
+	}()
+	shutdownTracing, err := tracing.InitTracing(context.Background(), tracing.Config{
+		OTLPEndpoint: *tracingOTLPEndpoint,
+		Insecure:     *tracingOTLPInsecure,
+		SampleRatio:  *tracingSampleRatio,
+	})
+	if err != nil {
+		klog.ErrorS(err, "failed to initialize tracing")
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			klog.ErrorS(err, "failed to flush trace spans")
+		}
+	}()
 
 	if *constructPEMChain {
 		klog.Infof("construct pem chain feature enabled")
@@ -108,6 +229,22 @@ func main() {
 		klog.Infof("write cert and key in separate files feature enabled")
 	}
 
+	// the provider options apply to the gRPC server and the secret sync controller
+	providerOpts, circuitBreaker, closeProvider, err := providerOptions()
+	if err != nil {
+		klog.ErrorS(err, "failed to initialize provider")
+		os.Exit(1)
+	}
+	defer closeProvider()
+
+	if *secretSyncController {
+		if err = runSecretSyncController(signalChan, cloudEnv, providerOpts); err != nil {
+			klog.ErrorS(err, "failed to run secret sync controller")
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialize and run the gRPC server
 	proto, addr, err := utils.ParseEndpoint(*endpoint)
 	if err != nil {
@@ -135,7 +272,32 @@ func main() {
 		grpc.UnaryInterceptor(utils.LogInterceptor()),
 	}
 	s := grpc.NewServer(opts...)
-	csiDriverProviderServer := server.New(*constructPEMChain, *writeCertAndKeyInSeparateFiles, cloudEnv)
+	readinessConfig := server.ReadinessConfig{
+		CloudEnvironment:        cloudEnv,
+		CheckManagedIdentity:    *readinessCheckManagedIdentity,
+		ManagedIdentityClientID: *readinessManagedIdentityClientID,
+		CheckEndpoints:          *readinessCheckEndpoints,
+		MountErrorThreshold:     *readinessMountErrorThreshold,
+		MountErrorWindow:        *readinessMountErrorWindow,
+		MountErrorMinMounts:     *readinessMountErrorMinMounts,
+		Interval:                *readinessCheckInterval,
+		Timeout:                 *readinessCheckTimeout,
+	}
+	if err = readinessConfig.Validate(); err != nil {
+		klog.ErrorS(err, "invalid readiness check configuration")
+		os.Exit(1)
+	}
+	readiness := server.NewReadiness(readinessConfig)
+	if circuitBreaker != nil {
+		readiness.SetCircuitBreaker(circuitBreaker)
+	}
+	readinessCtx, stopReadiness := context.WithCancel(context.Background())
+	defer stopReadiness()
+	go readiness.Run(readinessCtx)
+	healthManager := server.NewHealthManager(readiness)
+
+	csiDriverProviderServer := server.New(*constructPEMChain, *writeCertAndKeyInSeparateFiles, cloudEnv, providerOpts...)
+	csiDriverProviderServer.SetHealthManager(healthManager)
 	k8spb.RegisterCSIDriverProviderServer(s, csiDriverProviderServer)
 	// Register the health service.
 	grpc_health_v1.RegisterHealthServer(s, csiDriverProviderServer)
@@ -150,11 +312,185 @@ func main() {
 		},
 		UnixSocketPath: listener.Addr().String(),
 		RPCTimeout:     *healthzTimeout,
+		ReadinessPath:  *readyzPath,
+		Readiness:      healthManager,
 	}
 	go healthz.Serve()
 
 	<-signalChan
 	// gracefully stop the grpc server
 	klog.Infof("terminating the server")
+	// report NOT_SERVING to the health watchers before the server stops accepting requests
+	healthManager.Shutdown()
 	s.GracefulStop()
 }
+
+// runSecretSyncController runs the secret sync controller until a signal is received
+func runSecretSyncController(signalChan <-chan os.Signal, cloudEnv cloud.Environment, providerOpts []provider.Option) error {
+	syncConfig := secretSyncConfig()
+	if err := syncConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid secret sync controller configuration, error: %w", err)
+	}
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to build kubeconfig, error: %w", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client, error: %w", err)
+	}
+	secretsStoreClient, err := secretsstoreclientset.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create secrets store client, error: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-signalChan
+		klog.Infof("terminating the secret sync controller")
+		cancel()
+	}()
+
+	p := provider.NewProvider(*constructPEMChain, *writeCertAndKeyInSeparateFiles, cloudEnv, providerOpts...)
+	c := secretsync.New(kubeClient, secretsStoreClient, p, syncConfig)
+	return c.Run(ctx)
+}
+
+// secretSyncConfig returns the secret sync controller configuration set by the flags
+func secretSyncConfig() secretsync.Config {
+	var namespaces []string
+	for _, ns := range strings.Split(*secretSyncNamespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return secretsync.Config{
+		ResyncInterval:         *secretSyncResyncInterval,
+		Workers:                *secretSyncWorkers,
+		Namespaces:             namespaces,
+		ClientID:               *secretSyncClientID,
+		TokenFile:              *secretSyncTokenFile,
+		UseVMManagedIdentity:   *secretSyncUseVMManagedIdentity,
+		UserAssignedIdentityID: *secretSyncUserAssignedIdentityID,
+	}
+}
+
+// providerOptions returns the provider options set by the flags, the circuit breaker of the
+// provider if enabled and the function that releases the resources of the options, e.g. the
+// audit log file. The options are the same for the gRPC
+// server and the secret sync controller, so the policy and the disabled identity access modes
+// can't be bypassed by the controller.
+func providerOptions() ([]provider.Option, *breaker.Breaker, func(), error) {
+	var closers []func()
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+
+	authModes := disabledAuthModes()
+	if len(authModes) > 0 {
+		klog.InfoS("identity access modes disabled", "authModes", authModes)
+	}
+	deprecatedModes, err := parseAuthModes(*deprecatedAuthModesList)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid deprecated identity access modes, error: %w", err)
+	}
+	providerOpts := []provider.Option{provider.WithDisabledAuthModes(authModes...), provider.WithDeprecatedAuthModes(deprecatedModes...)}
+	if *allowDisableChallengeResourceVerification {
+		providerOpts = append(providerOpts, provider.WithAllowDisableChallengeResourceVerification())
+	}
+	if *allowKeyVaultProxyOverride {
+		providerOpts = append(providerOpts, provider.WithAllowKeyVaultProxyOverride())
+	}
+	if *enableLastKnownGoodCache {
+		keyFile := *lastKnownGoodCacheKeyFile
+		if keyFile == "" {
+			keyFile = filepath.Join(*lastKnownGoodCacheDir, "key")
+		}
+		lastKnownGoodCache, err := cache.New(*lastKnownGoodCacheDir, keyFile, *lastKnownGoodCacheMaxStaleness)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to initialize last known good cache, error: %w", err)
+		}
+		providerOpts = append(providerOpts, provider.WithLastKnownGoodCache(lastKnownGoodCache))
+		klog.InfoS("last known good cache enabled", "dir", *lastKnownGoodCacheDir, "maxStaleness", *lastKnownGoodCacheMaxStaleness)
+	}
+	if *auditLogPath != "" {
+		auditLogger, err := audit.New(audit.Config{
+			Path:       *auditLogPath,
+			MaxSizeMB:  *auditLogMaxSizeMB,
+			MaxBackups: *auditLogMaxBackups,
+			MaxAgeDays: *auditLogMaxAgeDays,
+		})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to initialize audit log, error: %w", err)
+		}
+		closers = append(closers, func() { auditLogger.Close() })
+		providerOpts = append(providerOpts, provider.WithAuditLogger(auditLogger))
+		klog.InfoS("audit log enabled", "path", *auditLogPath)
+	}
+	if *policyFile != "" {
+		mountPolicy, err := policy.FromFile(*policyFile)
+		if err != nil {
+			closeAll()
+			return nil, nil, nil, fmt.Errorf("failed to load policy, error: %w", err)
+		}
+		providerOpts = append(providerOpts, provider.WithPolicy(mountPolicy))
+		klog.InfoS("policy enabled", "file", *policyFile, "rules", len(mountPolicy.Rules))
+	}
+	var circuitBreaker *breaker.Breaker
+	if *enableCircuitBreaker {
+		circuitBreaker, err = breaker.New(breaker.Config{
+			FailureThreshold: *circuitBreakerFailureThreshold,
+			OpenDuration:     *circuitBreakerOpenDuration,
+		})
+		if err != nil {
+			closeAll()
+			return nil, nil, nil, fmt.Errorf("invalid circuit breaker configuration, error: %w", err)
+		}
+		providerOpts = append(providerOpts, provider.WithCircuitBreaker(circuitBreaker))
+		klog.InfoS("circuit breaker enabled", "failureThreshold", *circuitBreakerFailureThreshold, "openDuration", *circuitBreakerOpenDuration)
+	}
+	return providerOpts, circuitBreaker, closeAll, nil
+}
+
+// disabledAuthModes returns the identity access modes disabled by the flags
+func disabledAuthModes() []string {
+	var modes []string
+	if *disablePodIdentity {
+		modes = append(modes, auth.ModePodIdentity)
+	}
+	if *disableVMManagedIdentity {
+		modes = append(modes, auth.ModeManagedIdentity)
+	}
+	return modes
+}
+
+// parseAuthModes parses the comma-separated list of identity access modes
+func parseAuthModes(list string) ([]string, error) {
+	var modes []string
+	for _, mode := range strings.Split(list, ",") {
+		mode = strings.TrimSpace(mode)
+		if mode == "" {
+			continue
+		}
+		if !auth.IsValidMode(mode) {
+			return nil, fmt.Errorf("identity access mode %q is not valid, allowed values: podIdentity, managedIdentity, workloadIdentity, servicePrincipal", mode)
+		}
+		modes = append(modes, mode)
+	}
+	return modes, nil
+}
+
+// getDefaultCloudEnvironment returns the default cloud environment by name. For backward
+// compatibility, the AzureStackCloud environment is loaded from the file referenced by
+// AZURE_ENVIRONMENT_FILEPATH when it is set on the provider.
+func getDefaultCloudEnvironment(cloudName string) (cloud.Environment, error) {
+	if strings.EqualFold(cloudName, cloud.AzureStackCloudName) {
+		if envFile := os.Getenv(azureEnvironmentFilepathName); envFile != "" {
+			return cloud.EnvironmentFromFile(envFile)
+		}
+	}
+	return cloud.EnvironmentFromName(cloudName)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cloud"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+// setFlag sets the flag value for the test and restores it at the end of the test
+func setFlag[T any](t *testing.T, flag *T, value T) {
+	t.Helper()
+	previous := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = previous })
+}
+
+func TestProviderOptionsPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte("rules:\n- namespaces: [kube-system]\n  authModes: [managedIdentity]\n"), 0600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	attrib := map[string]string{
+		"keyvaultName":         "testKV",
+		"tenantId":             "tid",
+		"useVMManagedIdentity": "true",
+		"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret`,
+		types.CSIAttributePodName:      "pod1",
+		types.CSIAttributePodNamespace: "default",
+	}
+
+	// the secret sync controller creates its provider with the same options as the gRPC server
+	setFlag(t, secretSyncController, true)
+	setFlag(t, policyFile, path)
+	providerOpts, _, closeProvider, err := providerOptions()
+	if err != nil {
+		t.Fatalf("providerOptions() = %v, want nil", err)
+	}
+	defer closeProvider()
+
+	p := provider.NewProvider(false, false, cloud.PublicCloud, providerOpts...)
+	_, err = p.GetSecretsStoreObjectContent(context.Background(), attrib, nil, 0644)
+	if code := provider.ErrorCodeOf(err); code != provider.ErrorCodePermissionDenied {
+		t.Fatalf("expected error code %s, got %s: %v", provider.ErrorCodePermissionDenied, code, err)
+	}
+}
+
+func TestProviderOptionsInvalid(t *testing.T) {
+	cases := []struct {
+		desc  string
+		setup func(t *testing.T)
+	}{
+		{
+			desc:  "policy file not found",
+			setup: func(t *testing.T) { setFlag(t, policyFile, filepath.Join(t.TempDir(), "policy.yaml")) },
+		},
+		{
+			desc:  "invalid deprecated identity access mode",
+			setup: func(t *testing.T) { setFlag(t, deprecatedAuthModesList, "podIdentity,vmManagedIdentity") },
+		},
+		{
+			desc: "invalid circuit breaker configuration",
+			setup: func(t *testing.T) {
+				setFlag(t, enableCircuitBreaker, true)
+				setFlag(t, circuitBreakerFailureThreshold, 0)
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			// the provider fails to start in both modes
+			setFlag(t, secretSyncController, true)
+			tc.setup(t)
+			if _, _, _, err := providerOptions(); err == nil {
+				t.Fatalf("providerOptions() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestSecretSyncConfig(t *testing.T) {
+	setFlag(t, secretSyncNamespaces, "team-a, team-b,")
+	setFlag(t, secretSyncUseVMManagedIdentity, true)
+	config := secretSyncConfig()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(config.Namespaces, []string{"team-a", "team-b"}) {
+		t.Fatalf("expected namespaces [team-a team-b], got %v", config.Namespaces)
+	}
+
+	// the controller doesn't start without the namespaces
+	setFlag(t, secretSyncNamespaces, "")
+	config = secretSyncConfig()
+	if err := config.Validate(); err == nil {
+		t.Fatalf("Validate() = nil, want error")
+	}
+}
@@ -0,0 +1,233 @@
+// spc-render fetches the objects of a SecretProviderClass with the same code path used
+// for the pod mounts and writes the files to a local directory. It's used to debug a
+// SecretProviderClass without deploying a pod.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cloud"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+
+	"k8s.io/klog/v2"
+	secretsstorev1 "sigs.k8s.io/secrets-store-csi-driver/apis/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const providerName = "azure"
+
+var (
+	spcFile         = flag.String("spc", "", "path to the SecretProviderClass YAML")
+	outputDir       = flag.String("output-dir", "", "directory the files are written to")
+	dryRun          = flag.Bool("dry-run", false, "validate the SecretProviderClass without fetching the objects")
+	mockBackendFile = flag.String("mock-backend", "", "path to a YAML file with the objects that are served instead of key vault")
+
+	clientID                = flag.String("client-id", "", "client id of the service principal. Used as the clientid of the nodePublishSecretRef")
+	clientSecretFile        = flag.String("client-secret-file", "", "file with the client secret of the service principal. Used as the clientsecret of the nodePublishSecretRef")
+	serviceAccountTokenFile = flag.String("service-account-token-file", "", "service account token for workload identity when the SecretProviderClass sets the clientID")
+	podName                 = flag.String("pod-name", "spc-render", "name of the pod the SecretProviderClass is mounted in")
+	podNamespace            = flag.String("pod-namespace", "", "namespace of the pod. Defaults to the namespace of the SecretProviderClass")
+
+	cloudName      = flag.String("cloud-name", "AzurePublicCloud", "default cloud environment to use if not provided in the SecretProviderClass")
+	filePermission = flag.String("file-permission", "0644", "default file permission of the files in octal")
+
+	constructPEMChain              = flag.Bool("construct-pem-chain", true, "explicitly reconstruct the pem chain in the order: SERVER, INTERMEDIATE, ROOT")
+	writeCertAndKeyInSeparateFiles = flag.Bool("write-cert-and-key-in-separate-files", false, "write cert and key in separate files in addition to the single file")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	defer klog.Flush()
+
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, os.Stdout); err != nil {
+		klog.ErrorS(err, "failed to render SecretProviderClass", "file", *spcFile)
+		klog.Flush()
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, out io.Writer) error {
+	if *spcFile == "" {
+		return fmt.Errorf("--spc is required")
+	}
+	if *outputDir == "" && !*dryRun {
+		return fmt.Errorf("--output-dir is required")
+	}
+	mode, err := strconv.ParseUint(*filePermission, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid file permission %s, error: %w", *filePermission, err)
+	}
+
+	spc, err := loadSecretProviderClass(*spcFile)
+	if err != nil {
+		return err
+	}
+	attrib, err := getAttributes(spc)
+	if err != nil {
+		return err
+	}
+	secrets, err := getSecrets()
+	if err != nil {
+		return err
+	}
+
+	cloudEnv, err := cloud.EnvironmentFromName(*cloudName)
+	if err != nil {
+		return fmt.Errorf("invalid cloud name %s, error: %w", *cloudName, err)
+	}
+	// the SecretProviderClass is rendered with the credentials of the user running the command
+	opts := []provider.Option{provider.WithAllowDisableChallengeResourceVerification(), provider.WithAllowKeyVaultProxyOverride()}
+	if *dryRun {
+		opts = append(opts, provider.WithDryRun())
+	}
+	if *mockBackendFile != "" {
+		newClient, err := newMockBackend(*mockBackendFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, provider.WithKeyVaultClient(newClient))
+	}
+	p := provider.NewProvider(*constructPEMChain, *writeCertAndKeyInSeparateFiles, cloudEnv, opts...)
+
+	files, err := p.GetSecretsStoreObjectContent(ctx, attrib, secrets, os.FileMode(mode))
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(out, "SecretProviderClass %s/%s is valid\n", spc.Namespace, spc.Name)
+		return nil
+	}
+	if err = writeFiles(*outputDir, files); err != nil {
+		return err
+	}
+	return printFiles(out, files)
+}
+
+// loadSecretProviderClass reads the SecretProviderClass from the YAML file
+func loadSecretProviderClass(file string) (*secretsstorev1.SecretProviderClass, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SecretProviderClass file %s, error: %w", file, err)
+	}
+	spc := &secretsstorev1.SecretProviderClass{}
+	if err = yaml.Unmarshal(data, spc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal SecretProviderClass, error: %w", err)
+	}
+	if spc.Kind != "SecretProviderClass" {
+		return nil, fmt.Errorf("invalid kind %q, should be SecretProviderClass", spc.Kind)
+	}
+	if spc.Spec.Provider != providerName {
+		return nil, fmt.Errorf("invalid provider %q, should be %s", spc.Spec.Provider, providerName)
+	}
+	if spc.Namespace == "" {
+		spc.Namespace = "default"
+	}
+	return spc, nil
+}
+
+// getAttributes returns the mount attributes sent by the driver for the SecretProviderClass
+func getAttributes(spc *secretsstorev1.SecretProviderClass) (map[string]string, error) {
+	attrib := make(map[string]string, len(spc.Spec.Parameters)+4)
+	for k, v := range spc.Spec.Parameters {
+		attrib[k] = v
+	}
+	namespace := *podNamespace
+	if namespace == "" {
+		namespace = spc.Namespace
+	}
+	attrib[types.CSIAttributePodName] = *podName
+	attrib[types.CSIAttributePodNamespace] = namespace
+	attrib[types.CSIAttributeSecretProviderClass] = spc.Name
+
+	if *serviceAccountTokenFile == "" {
+		return attrib, nil
+	}
+	token, err := os.ReadFile(*serviceAccountTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token file %s, error: %w", *serviceAccountTokenFile, err)
+	}
+	tokens := auth.SATokens{}
+	tokens.APIAzureADTokenExchange.Token = strings.TrimSpace(string(token))
+	saTokens, err := json.Marshal(tokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal service account tokens, error: %w", err)
+	}
+	attrib[types.CSIAttributeServiceAccountTokens] = string(saTokens)
+	return attrib, nil
+}
+
+// getSecrets returns the nodePublishSecretRef secrets for the service principal
+func getSecrets() (map[string]string, error) {
+	if *clientID == "" && *clientSecretFile == "" {
+		return nil, nil
+	}
+	clientSecret, err := os.ReadFile(*clientSecretFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client secret file %s, error: %w", *clientSecretFile, err)
+	}
+	return map[string]string{
+		"clientid":     *clientID,
+		"clientsecret": strings.TrimSpace(string(clientSecret)),
+	}, nil
+}
+
+// writeFiles writes the files to the directory with the file modes and owners returned by the provider
+func writeFiles(dir string, files []types.SecretFile) error {
+	for _, file := range files {
+		path := filepath.Join(dir, file.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return fmt.Errorf("failed to create directory for %s, error: %w", file.Path, err)
+		}
+		if err := os.WriteFile(path, file.Content, os.FileMode(file.FileMode)); err != nil {
+			return fmt.Errorf("failed to write %s, error: %w", file.Path, err)
+		}
+		// the file mode is set explicitly as the mode passed to WriteFile is masked by the umask
+		if err := os.Chmod(path, os.FileMode(file.FileMode)); err != nil {
+			return fmt.Errorf("failed to set file mode for %s, error: %w", file.Path, err)
+		}
+		if file.FileOwner == nil && file.FileGroup == nil {
+			continue
+		}
+		uid, gid := -1, -1
+		if file.FileOwner != nil {
+			uid = int(*file.FileOwner)
+		}
+		if file.FileGroup != nil {
+			gid = int(*file.FileGroup)
+		}
+		// the ownership is a hint, changing it requires privileges the user running
+		// the command may not have, so the file is kept with the current owner
+		if err := os.Chown(path, uid, gid); err != nil {
+			klog.InfoS("failed to set file owner, skipping", "file", file.Path, "uid", uid, "gid", gid, "error", err.Error())
+		}
+	}
+	return nil
+}
+
+// printFiles prints the object, version and file mode of the written files
+func printFiles(out io.Writer, files []types.SecretFile) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tOBJECT\tVERSION\tMODE")
+	for _, file := range files {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%#o\n", file.Path, file.UID, file.Version, file.FileMode)
+	}
+	return w.Flush()
+}
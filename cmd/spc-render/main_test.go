@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+const testSecretProviderClass = `
+apiVersion: secrets-store.csi.x-k8s.io/v1
+kind: SecretProviderClass
+metadata:
+  name: spc1
+  namespace: test
+spec:
+  provider: azure
+  parameters:
+    keyvaultName: testkv
+    tenantId: tid
+    useVMManagedIdentity: "true"
+    objects: |
+      array:
+        - |
+          objectName: secret1
+          objectType: secret
+          filePermission: "0600"
+        - |
+          objectName: key1
+          objectType: key
+        - |
+          objectName: cert1
+          objectType: cert
+`
+
+func newTestMockBackendFile(t *testing.T, dir string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	var b strings.Builder
+	b.WriteString("secrets:\n- name: secret1\n  version: v1\n  value: old\n- name: secret1\n  version: v2\n  value: new\n")
+	b.WriteString("keys:\n- name: key1\n  version: k1\n  publicKey: |\n")
+	for _, line := range strings.Split(strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}))), "\n") {
+		b.WriteString("    " + line + "\n")
+	}
+	b.WriteString("certificates:\n- name: cert1\n  version: c1\n  certificate: |\n")
+	for _, line := range strings.Split(strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}))), "\n") {
+		b.WriteString("    " + line + "\n")
+	}
+
+	file := filepath.Join(dir, "backend.yaml")
+	if err = os.WriteFile(file, []byte(b.String()), 0600); err != nil {
+		t.Fatalf("failed to write mock backend file: %v", err)
+	}
+	return file
+}
+
+func setFlag(t *testing.T, p *string, value string) {
+	old := *p
+	*p = value
+	t.Cleanup(func() { *p = old })
+}
+
+func TestRunMockBackend(t *testing.T) {
+	dir := t.TempDir()
+	spc := filepath.Join(dir, "spc.yaml")
+	if err := os.WriteFile(spc, []byte(testSecretProviderClass), 0600); err != nil {
+		t.Fatalf("failed to write SecretProviderClass: %v", err)
+	}
+	out := filepath.Join(dir, "out")
+	setFlag(t, spcFile, spc)
+	setFlag(t, outputDir, out)
+	setFlag(t, mockBackendFile, newTestMockBackendFile(t, dir))
+
+	var stdout bytes.Buffer
+	if err := run(context.TODO(), &stdout); err != nil {
+		t.Fatalf("run() = %v, want nil", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(out, "secret1"))
+	if err != nil {
+		t.Fatalf("failed to read secret1: %v", err)
+	}
+	if string(content) != "new" {
+		t.Fatalf("expected latest secret version content new, got %s", content)
+	}
+	info, err := os.Stat(filepath.Join(out, "secret1"))
+	if err != nil {
+		t.Fatalf("failed to stat secret1: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected file mode 0600, got %#o", info.Mode().Perm())
+	}
+	for _, file := range []string{"key1", "cert1"} {
+		content, err = os.ReadFile(filepath.Join(out, file))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", file, err)
+		}
+		if block, _ := pem.Decode(content); block == nil {
+			t.Fatalf("expected PEM content for %s, got %s", file, content)
+		}
+	}
+
+	for _, expected := range [][]string{
+		{"secret1", "secret/secret1", "v2", "0600"},
+		{"key1", "key/key1", "k1", "0644"},
+		{"cert1", "cert/cert1", "c1", "0644"},
+	} {
+		if !containsFields(stdout.String(), expected) {
+			t.Fatalf("expected output to contain %v, got:\n%s", expected, stdout.String())
+		}
+	}
+}
+
+// containsFields returns true if a line of the output has the fields
+func containsFields(output string, fields []string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Join(strings.Fields(line), " ") == strings.Join(fields, " ") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunDryRun(t *testing.T) {
+	dir := t.TempDir()
+	spc := filepath.Join(dir, "spc.yaml")
+	if err := os.WriteFile(spc, []byte(testSecretProviderClass), 0600); err != nil {
+		t.Fatalf("failed to write SecretProviderClass: %v", err)
+	}
+	setFlag(t, spcFile, spc)
+	*dryRun = true
+	t.Cleanup(func() { *dryRun = false })
+
+	var stdout bytes.Buffer
+	if err := run(context.TODO(), &stdout); err != nil {
+		t.Fatalf("run() = %v, want nil", err)
+	}
+	if !strings.Contains(stdout.String(), "SecretProviderClass test/spc1 is valid") {
+		t.Fatalf("unexpected output: %s", stdout.String())
+	}
+
+	invalid := strings.Replace(testSecretProviderClass, "tenantId: tid", "", 1)
+	if err := os.WriteFile(spc, []byte(invalid), 0600); err != nil {
+		t.Fatalf("failed to write SecretProviderClass: %v", err)
+	}
+	err := run(context.TODO(), &stdout)
+	if provider.ErrorCodeOf(err) != provider.ErrorCodeInvalidConfig {
+		t.Fatalf("expected error code: %v, got: %v", provider.ErrorCodeInvalidConfig, provider.ErrorCodeOf(err))
+	}
+}
+
+func TestMockBackendNotFound(t *testing.T) {
+	dir := t.TempDir()
+	newClient, err := newMockBackend(newTestMockBackendFile(t, dir))
+	if err != nil {
+		t.Fatalf("newMockBackend() = %v, want nil", err)
+	}
+	kvClient, err := newClient("https://kv.vault.azure.net/")
+	if err != nil {
+		t.Fatalf("newClient() = %v, want nil", err)
+	}
+	_, err = kvClient.GetSecret(context.TODO(), "secret2", "")
+	if provider.ErrorCodeOf(err) != provider.ErrorCodeNotFound {
+		t.Fatalf("expected error code: %v, got: %v", provider.ErrorCodeNotFound, provider.ErrorCodeOf(err))
+	}
+	if _, err = kvClient.GetSecret(context.TODO(), "secret1", "v3"); err == nil {
+		t.Fatalf("GetSecret() = nil, want error for unknown version")
+	}
+}
+
+func TestWriteFilesOwner(t *testing.T) {
+	dir := t.TempDir()
+	uid, gid := int64(os.Getuid()), int64(os.Getgid())
+	files := []types.SecretFile{
+		{Path: "secret1", Content: []byte("test"), FileMode: 0600, FileOwner: &uid, FileGroup: &gid},
+	}
+	if err := writeFiles(dir, files); err != nil {
+		t.Fatalf("writeFiles() = %v, want nil", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "secret1"))
+	if err != nil {
+		t.Fatalf("failed to stat secret1: %v", err)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && (int64(stat.Uid) != uid || int64(stat.Gid) != gid) {
+		t.Fatalf("expected owner %d:%d, got %d:%d", uid, gid, stat.Uid, stat.Gid)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"sigs.k8s.io/yaml"
+)
+
+// mockObjects are the objects served by the mock backend. The versions of an object are
+// listed from the oldest to the latest, and the last one is returned when no version is set.
+type mockObjects struct {
+	Secrets      []mockSecret      `json:"secrets"`
+	Keys         []mockKey         `json:"keys"`
+	Certificates []mockCertificate `json:"certificates"`
+}
+
+type mockSecret struct {
+	Name        string    `json:"name"`
+	Version     string    `json:"version"`
+	Value       string    `json:"value"`
+	ContentType string    `json:"contentType"`
+	Created     time.Time `json:"created"`
+}
+
+type mockKey struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// PublicKey is the PEM encoded RSA or EC public key
+	PublicKey string    `json:"publicKey"`
+	Created   time.Time `json:"created"`
+}
+
+type mockCertificate struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Certificate is the PEM encoded certificate
+	Certificate string `json:"certificate"`
+	// PrivateKey is the PEM encoded private key returned with the certificate
+	// when the certificate is fetched as objectType secret
+	PrivateKey string    `json:"privateKey"`
+	Created    time.Time `json:"created"`
+}
+
+// mockBackend implements the key vault client with the objects from a file,
+// so the SecretProviderClass can be rendered without access to key vault
+type mockBackend struct {
+	vaultURI string
+	objects  mockObjects
+}
+
+// newMockBackend returns the key vault client factory for the objects in the file
+func newMockBackend(file string) (func(vaultURI string) (provider.KeyVault, error), error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock backend file %s, error: %w", file, err)
+	}
+	var objects mockObjects
+	if err = yaml.UnmarshalStrict(data, &objects); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal mock backend file %s, error: %w", file, err)
+	}
+	return func(vaultURI string) (provider.KeyVault, error) {
+		return &mockBackend{vaultURI: strings.TrimSuffix(vaultURI, "/"), objects: objects}, nil
+	}, nil
+}
+
+func (m *mockBackend) GetSecret(_ context.Context, name, version string) (*azsecrets.SecretBundle, error) {
+	for i := len(m.objects.Secrets) - 1; i >= 0; i-- {
+		s := m.objects.Secrets[i]
+		if s.Name != name || (version != "" && s.Version != version) {
+			continue
+		}
+		id := azsecrets.ID(m.objectID("secrets", s.Name, s.Version))
+		return &azsecrets.SecretBundle{ID: &id, Value: &s.Value, ContentType: &s.ContentType}, nil
+	}
+	// certificates are fetched as secrets to get the certificate and the private key
+	for i := len(m.objects.Certificates) - 1; i >= 0; i-- {
+		c := m.objects.Certificates[i]
+		if c.Name != name || (version != "" && c.Version != version) {
+			continue
+		}
+		id := azsecrets.ID(m.objectID("secrets", c.Name, c.Version))
+		kid := m.objectID("keys", c.Name, c.Version)
+		value := c.Certificate + c.PrivateKey
+		contentType := types.CertTypePem
+		return &azsecrets.SecretBundle{ID: &id, Kid: &kid, Value: &value, ContentType: &contentType}, nil
+	}
+	return nil, m.notFoundError("secrets", "SecretNotFound", name, version)
+}
+
+func (m *mockBackend) GetSecretVersions(_ context.Context, name string) ([]types.KeyVaultObjectVersion, error) {
+	var versions []types.KeyVaultObjectVersion
+	for _, s := range m.objects.Secrets {
+		if s.Name == name {
+			versions = append(versions, types.KeyVaultObjectVersion{Version: s.Version, Created: s.Created, Enabled: true})
+		}
+	}
+	for _, c := range m.objects.Certificates {
+		if c.Name == name {
+			versions = append(versions, types.KeyVaultObjectVersion{Version: c.Version, Created: c.Created, Enabled: true})
+		}
+	}
+	return versions, nil
+}
+
+func (m *mockBackend) GetKey(_ context.Context, name, version string) (*azkeys.KeyBundle, error) {
+	for i := len(m.objects.Keys) - 1; i >= 0; i-- {
+		k := m.objects.Keys[i]
+		if k.Name != name || (version != "" && k.Version != version) {
+			continue
+		}
+		jwk, err := toJSONWebKey(k.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key for mock key %s, error: %w", k.Name, err)
+		}
+		kid := azkeys.ID(m.objectID("keys", k.Name, k.Version))
+		jwk.KID = &kid
+		return &azkeys.KeyBundle{Key: jwk}, nil
+	}
+	return nil, m.notFoundError("keys", "KeyNotFound", name, version)
+}
+
+func (m *mockBackend) GetKeyVersions(_ context.Context, name string) ([]types.KeyVaultObjectVersion, error) {
+	var versions []types.KeyVaultObjectVersion
+	for _, k := range m.objects.Keys {
+		if k.Name == name {
+			versions = append(versions, types.KeyVaultObjectVersion{Version: k.Version, Created: k.Created, Enabled: true})
+		}
+	}
+	return versions, nil
+}
+
+func (m *mockBackend) GetCertificate(_ context.Context, name, version string) (*azcertificates.CertificateBundle, error) {
+	for i := len(m.objects.Certificates) - 1; i >= 0; i-- {
+		c := m.objects.Certificates[i]
+		if c.Name != name || (version != "" && c.Version != version) {
+			continue
+		}
+		block, _ := pem.Decode([]byte(c.Certificate))
+		if block == nil {
+			return nil, fmt.Errorf("invalid certificate for mock certificate %s, no PEM data found", c.Name)
+		}
+		id := azcertificates.ID(m.objectID("certificates", c.Name, c.Version))
+		return &azcertificates.CertificateBundle{ID: &id, CER: block.Bytes}, nil
+	}
+	return nil, m.notFoundError("certificates", "CertificateNotFound", name, version)
+}
+
+func (m *mockBackend) GetCertificateVersions(_ context.Context, name string) ([]types.KeyVaultObjectVersion, error) {
+	var versions []types.KeyVaultObjectVersion
+	for _, c := range m.objects.Certificates {
+		if c.Name == name {
+			versions = append(versions, types.KeyVaultObjectVersion{Version: c.Version, Created: c.Created, Enabled: true})
+		}
+	}
+	return versions, nil
+}
+
+// objectID returns the key vault identifier of the object version
+func (m *mockBackend) objectID(collection, name, version string) string {
+	return strings.Join([]string{m.vaultURI, collection, name, version}, "/")
+}
+
+// notFoundError returns the error returned by key vault for an object that doesn't exist
+func (m *mockBackend) notFoundError(collection, errorCode, name, version string) error {
+	u, _ := url.Parse(m.objectID(collection, name, version))
+	return &azcore.ResponseError{
+		ErrorCode:  errorCode,
+		StatusCode: http.StatusNotFound,
+		RawResponse: &http.Response{
+			StatusCode: http.StatusNotFound,
+			Status:     http.StatusText(http.StatusNotFound),
+			Request:    &http.Request{Method: http.MethodGet, URL: u},
+			Body:       io.NopCloser(strings.NewReader("")),
+		},
+	}
+}
+
+// toJSONWebKey converts the PEM encoded public key to the JSON web key returned by key vault
+func toJSONWebKey(publicKey string) (*azkeys.JSONWebKey, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		kty := azkeys.JSONWebKeyTypeRSA
+		return &azkeys.JSONWebKey{Kty: &kty, N: k.N.Bytes(), E: big.NewInt(int64(k.E)).Bytes()}, nil
+	case *ecdsa.PublicKey:
+		kty := azkeys.JSONWebKeyTypeEC
+		crv := azkeys.JSONWebKeyCurveName(k.Curve.Params().Name)
+		return &azkeys.JSONWebKey{Kty: &kty, Crv: &crv, X: k.X.Bytes(), Y: k.Y.Bytes()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
@@ -0,0 +1,177 @@
+// webhook serves the validating admission webhook that checks the parameters of the
+// SecretProviderClass objects for the azure provider on create and update.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cloud"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/version"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/webhook"
+
+	logsapi "k8s.io/component-base/logs/api/v1"
+	json "k8s.io/component-base/logs/json"
+	"k8s.io/klog/v2"
+)
+
+const (
+	readHeaderTimeout = 5 * time.Second
+	shutdownTimeout   = 10 * time.Second
+)
+
+var (
+	versionInfo   = flag.Bool("version", false, "prints the version information")
+	logFormatJSON = flag.Bool("log-format-json", false, "set log formatter to json")
+
+	port        = flag.Int("port", 9443, "port the webhook is served on")
+	tlsCertFile = flag.String("tls-cert-file", "/etc/webhook/certs/tls.crt", "file with the TLS certificate of the webhook. The file is reloaded when it changes")
+	tlsKeyFile  = flag.String("tls-private-key-file", "/etc/webhook/certs/tls.key", "file with the TLS private key of the webhook. The file is reloaded when it changes")
+	healthzPath = flag.String("healthz-path", "/healthz", "path for health check")
+
+	customCloudEnvironmentFiles    = flag.String("custom-cloud-environment-files", "", "comma separated list of the custom cloud environment files registered by the provider, so their cloudName is valid")
+	writeCertAndKeyInSeparateFiles = flag.Bool("write-cert-and-key-in-separate-files", false, "set if the provider writes the cert and key of the certificates in separate .crt and .key files, so the conflicts with these files are rejected")
+	caseInsensitivePaths           = flag.Bool("case-insensitive-paths", false, "compare the file paths of the objects case-insensitively. Set if the SecretProviderClasses are mounted on Windows nodes")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	defer klog.Flush()
+
+	flag.Parse()
+
+	if *logFormatJSON {
+		jsonFactory := json.Factory{}
+		logger, _ := jsonFactory.Create(logsapi.LoggingConfiguration{Format: "json"})
+		klog.SetLogger(logger)
+	}
+
+	if *versionInfo {
+		if err := version.PrintVersion(); err != nil {
+			klog.ErrorS(err, "failed to print version")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	klog.InfoS("Starting Azure Key Vault Provider SecretProviderClass webhook", "version", version.BuildVersion)
+
+	if *customCloudEnvironmentFiles != "" {
+		if err := cloud.RegisterFromFiles(strings.Split(*customCloudEnvironmentFiles, ",")); err != nil {
+			klog.ErrorS(err, "failed to register custom cloud environments")
+			os.Exit(1)
+		}
+	}
+
+	certs := &certificateReloader{certFile: *tlsCertFile, keyFile: *tlsKeyFile}
+	if _, err := certs.GetCertificate(nil); err != nil {
+		klog.ErrorS(err, "failed to load webhook certificate")
+		os.Exit(1)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(webhook.ValidatePath, &webhook.Handler{
+		Options: provider.ValidationOptions{
+			WriteCertAndKeyInSeparateFiles: *writeCertAndKeyInSeparateFiles,
+			CaseInsensitivePaths:           *caseInsensitivePaths,
+		},
+	})
+	mux.HandleFunc(*healthzPath, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", *port),
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		},
+	}
+
+	go func() {
+		klog.InfoS("Listening for admission requests", "address", server.Addr, "path", webhook.ValidatePath)
+		if err := server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.ErrorS(err, "failed to serve webhook")
+			os.Exit(1)
+		}
+	}()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT, os.Interrupt)
+	<-signalChan
+
+	klog.Infof("terminating the webhook")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		klog.ErrorS(err, "failed to shutdown webhook")
+	}
+}
+
+// certificateReloader loads the serving certificate from the files and reloads it
+// when the files are modified, so the rotated certificate is served without a restart
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// GetCertificate returns the serving certificate for the TLS handshake
+func (c *certificateReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTime, err := c.latestModTime()
+	if err != nil {
+		if c.cert != nil {
+			klog.ErrorS(err, "failed to check webhook certificate, serving the loaded certificate")
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			klog.ErrorS(err, "failed to reload webhook certificate, serving the loaded certificate")
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load certificate, error: %w", err)
+	}
+	klog.InfoS("loaded webhook certificate", "certFile", c.certFile)
+	c.cert = &cert
+	c.modTime = modTime
+	return c.cert, nil
+}
+
+// latestModTime returns the latest modification time of the certificate and key files
+func (c *certificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// StdoutPath is the path of the audit log written to stdout
+const StdoutPath = "-"
+
+// Event is the record of an object version received by a pod. The content of the object is never recorded.
+type Event struct {
+	// Time is the time the object was returned for the mount
+	Time                time.Time `json:"time"`
+	PodNamespace        string    `json:"podNamespace"`
+	PodName             string    `json:"podName"`
+	SecretProviderClass string    `json:"secretProviderClass,omitempty"`
+	// Vault is the URL of the vault the object was fetched from
+	Vault string `json:"vault,omitempty"`
+	// AuthMode is the identity access mode used to fetch the object
+	AuthMode      string `json:"authMode"`
+	ObjectType    string `json:"objectType"`
+	ObjectName    string `json:"objectName"`
+	ObjectVersion string `json:"objectVersion"`
+	// Stale is true if the object was served from the last known good cache
+	Stale bool `json:"stale,omitempty"`
+}
+
+// Config is the configuration of the audit log
+type Config struct {
+	// Path is the file the audit events are written to or StdoutPath
+	Path string
+	// MaxSizeMB is the size in megabytes of the file before it's rotated
+	MaxSizeMB int
+	// MaxBackups is the number of rotated files kept. All the files are kept if 0.
+	MaxBackups int
+	// MaxAgeDays is the number of days the rotated files are kept. The files aren't removed based on age if 0.
+	MaxAgeDays int
+}
+
+// Logger writes the audit events as JSON lines, independently of the klog verbosity
+type Logger struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// New returns the audit logger for the config
+func New(config Config) (*Logger, error) {
+	if config.Path == "" {
+		return nil, errors.New("audit log path is not set")
+	}
+	if config.Path == StdoutPath {
+		return &Logger{w: nopCloser{os.Stdout}}, nil
+	}
+	if config.MaxSizeMB <= 0 {
+		return nil, fmt.Errorf("audit log max size must be greater than 0")
+	}
+	if config.MaxBackups < 0 || config.MaxAgeDays < 0 {
+		return nil, fmt.Errorf("audit log max backups and max age must not be negative")
+	}
+	return &Logger{
+		w: &lumberjack.Logger{
+			Filename:   config.Path,
+			MaxSize:    config.MaxSizeMB,
+			MaxBackups: config.MaxBackups,
+			MaxAge:     config.MaxAgeDays,
+		},
+	}, nil
+}
+
+// Log writes the events of a mount. The events of the mount are written together.
+func (l *Logger) Log(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	var buf []byte
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit event, error: %w", err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(buf); err != nil {
+		return fmt.Errorf("failed to write audit events, error: %w", err)
+	}
+	return nil
+}
+
+// Close closes the audit log file
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Close()
+}
+
+// nopCloser doesn't close stdout when the logger is closed
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
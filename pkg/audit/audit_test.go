@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		desc        string
+		config      Config
+		expectedErr bool
+	}{
+		{
+			desc:        "path not set",
+			config:      Config{MaxSizeMB: 100},
+			expectedErr: true,
+		},
+		{
+			desc:   "stdout",
+			config: Config{Path: StdoutPath},
+		},
+		{
+			desc:        "invalid max size",
+			config:      Config{Path: filepath.Join(dir, "audit.log")},
+			expectedErr: true,
+		},
+		{
+			desc:        "negative max backups",
+			config:      Config{Path: filepath.Join(dir, "audit.log"), MaxSizeMB: 100, MaxBackups: -1},
+			expectedErr: true,
+		},
+		{
+			desc:   "file",
+			config: Config{Path: filepath.Join(dir, "audit.log"), MaxSizeMB: 100, MaxBackups: 5, MaxAgeDays: 30},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			l, err := New(tc.config)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+			if l != nil {
+				l.Close()
+			}
+		})
+	}
+}
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(Config{Path: path, MaxSizeMB: 1})
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	events := []Event{
+		{
+			Time:                now,
+			PodNamespace:        "default",
+			PodName:             "pod1",
+			SecretProviderClass: "spc1",
+			Vault:               "https://test.vault.azure.net/",
+			AuthMode:            "workloadIdentity",
+			ObjectType:          "secret",
+			ObjectName:          "secret1",
+			ObjectVersion:       "v1",
+		},
+		{
+			Time:          now,
+			PodNamespace:  "default",
+			PodName:       "pod1",
+			AuthMode:      "workloadIdentity",
+			ObjectType:    "cert",
+			ObjectName:    "cert1",
+			ObjectVersion: "v2",
+			Stale:         true,
+		},
+	}
+	if err := l.Log(events); err != nil {
+		t.Fatalf("Log() = %v, want nil", err)
+	}
+	if err := l.Log(nil); err != nil {
+		t.Fatalf("Log() = %v, want nil", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() = %v, want nil", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+	var got []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("failed to unmarshal audit event %q: %v", scanner.Text(), err)
+		}
+		got = append(got, event)
+	}
+	if !reflect.DeepEqual(got, events) {
+		t.Fatalf("expected events %+v, got %+v", events, got)
+	}
+}
@@ -2,6 +2,8 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/Azure/go-autorest/autorest/date"
 
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/tracing"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/utils"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
@@ -30,6 +33,15 @@ const (
 	// For Azure AD Workload Identity, the audience recommended for use is
 	// "api://AzureADTokenExchange"
 	DefaultTokenAudience = "api://AzureADTokenExchange" // nolint
+
+	// ModePodIdentity is the aad-pod-identity access mode
+	ModePodIdentity = "podIdentity"
+	// ModeManagedIdentity is the access mode with the managed identity of the node
+	ModeManagedIdentity = "managedIdentity"
+	// ModeWorkloadIdentity is the Azure AD Workload Identity access mode
+	ModeWorkloadIdentity = "workloadIdentity"
+	// ModeServicePrincipal is the access mode with the service principal credentials in the node publish secret
+	ModeServicePrincipal = "servicePrincipal"
 )
 
 var (
@@ -149,7 +161,10 @@ func NewConfig(
 }
 
 // GetCredential returns the azure credential to use based on the auth config
-func (c Config) GetCredential(podName, podNamespace, resource, aadEndpoint, tenantID, nmiPort string) (azcore.TokenCredential, error) {
+func (c Config) GetCredential(ctx context.Context, podName, podNamespace, resource string, cloudConfig cloud.Configuration, tenantID, nmiPort string) (_ azcore.TokenCredential, err error) {
+	_, span := tracing.StartSpan(ctx, "GetCredential", tracing.AuthModeKey.String(c.Mode()))
+	defer func() { tracing.EndSpan(span, err) }()
+
 	// use switch case to ensure only one of the identity modes is enabled
 	switch {
 	case c.UsePodIdentity:
@@ -157,14 +172,96 @@ func (c Config) GetCredential(podName, podNamespace, resource, aadEndpoint, tena
 	case c.UseVMManagedIdentity:
 		return getManagedIdentityTokenCredential(c.UserAssignedIdentityID)
 	case len(c.AADClientSecret) > 0 && len(c.AADClientID) > 0:
-		return getServicePrincipalTokenCredential(c.AADClientID, c.AADClientSecret, aadEndpoint, tenantID)
+		return getServicePrincipalTokenCredential(c.AADClientID, c.AADClientSecret, cloudConfig, tenantID)
 	case len(c.WorkloadIdentityClientID) > 0 && len(c.WorkloadIdentityToken) > 0:
-		return getWorkloadIdentityTokenCredential(c.WorkloadIdentityClientID, c.WorkloadIdentityToken, aadEndpoint, tenantID)
+		return getWorkloadIdentityTokenCredential(c.WorkloadIdentityClientID, c.WorkloadIdentityToken, cloudConfig, tenantID)
 	default:
 		return nil, fmt.Errorf("no identity mode is enabled")
 	}
 }
 
+// Mode returns the name of the identity access mode used by GetCredential
+func (c Config) Mode() string {
+	switch {
+	case c.UsePodIdentity:
+		return ModePodIdentity
+	case c.UseVMManagedIdentity:
+		return ModeManagedIdentity
+	case len(c.AADClientSecret) > 0 && len(c.AADClientID) > 0:
+		return ModeServicePrincipal
+	case len(c.WorkloadIdentityClientID) > 0 && len(c.WorkloadIdentityToken) > 0:
+		return ModeWorkloadIdentity
+	default:
+		return "none"
+	}
+}
+
+// IsValidMode returns true if the mode is one of the identity access modes
+func IsValidMode(mode string) bool {
+	switch mode {
+	case ModePodIdentity, ModeManagedIdentity, ModeWorkloadIdentity, ModeServicePrincipal:
+		return true
+	default:
+		return false
+	}
+}
+
+// ClientID returns the client ID of the identity used by GetCredential. It's empty for
+// pod identity and the system-assigned managed identity.
+func (c Config) ClientID() string {
+	switch c.Mode() {
+	case ModeManagedIdentity:
+		return c.UserAssignedIdentityID
+	case ModeServicePrincipal:
+		return c.AADClientID
+	case ModeWorkloadIdentity:
+		return c.WorkloadIdentityClientID
+	default:
+		return ""
+	}
+}
+
+// IdentityKey returns the key of the identity used by GetCredential. The key doesn't contain
+// the client secret or the service account token. The pod identity is bound to the labels of
+// the pod that aren't known to the provider, so the pod identity key is the namespace of the pod.
+func (c Config) IdentityKey(tenantID, podNamespace string) string {
+	mode := c.Mode()
+	switch mode {
+	case ModePodIdentity:
+		return mode + "/" + podNamespace
+	case ModeManagedIdentity:
+		return mode + "/" + c.UserAssignedIdentityID
+	case ModeServicePrincipal, ModeWorkloadIdentity:
+		return mode + "/" + tenantID + "/" + c.ClientID()
+	default:
+		return mode
+	}
+}
+
+// CredentialKey returns the key of the credential used by GetCredential. The requests with the
+// same key are authorized the same way by Azure AD, so the workload identity key contains the
+// service account federated with the identity and the service principal key contains the hash
+// of the client secret. The key is empty if the requests can't be shared with the other pods: the
+// pod identity is bound by NMI to the labels of the pod that aren't known to the provider, and the
+// service account of the workload identity may not be known.
+func (c Config) CredentialKey(tenantID, podNamespace, serviceAccountName string) string {
+	key := c.IdentityKey(tenantID, podNamespace)
+	switch c.Mode() {
+	case ModePodIdentity:
+		return ""
+	case ModeServicePrincipal:
+		hash := sha256.Sum256([]byte(c.AADClientSecret))
+		return key + "/" + hex.EncodeToString(hash[:])
+	case ModeWorkloadIdentity:
+		if serviceAccountName == "" {
+			return ""
+		}
+		return key + "/" + podNamespace + "/" + serviceAccountName
+	default:
+		return key
+	}
+}
+
 func newWorkloadIdentityCredential(tenantID, clientID, assertion string, options *workloadIdentityCredentialOptions) (azcore.TokenCredential, error) {
 	w := &workloadIdentityCredential{assertion: assertion}
 	cred, err := azidentity.NewClientAssertionCredential(tenantID, clientID, w.getAssertion, &azidentity.ClientAssertionCredentialOptions{ClientOptions: options.ClientOptions})
@@ -183,23 +280,19 @@ func (w *workloadIdentityCredential) getAssertion(context.Context) (string, erro
 	return w.assertion, nil
 }
 
-func getWorkloadIdentityTokenCredential(clientID, signedAssertion, aadEndpoint, tenantID string) (azcore.TokenCredential, error) {
+func getWorkloadIdentityTokenCredential(clientID, signedAssertion string, cloudConfig cloud.Configuration, tenantID string) (azcore.TokenCredential, error) {
 	opts := &workloadIdentityCredentialOptions{
 		ClientOptions: azcore.ClientOptions{
-			Cloud: cloud.Configuration{
-				ActiveDirectoryAuthorityHost: aadEndpoint,
-			},
+			Cloud: cloudConfig,
 		},
 	}
 	return newWorkloadIdentityCredential(tenantID, clientID, signedAssertion, opts)
 }
 
-func getServicePrincipalTokenCredential(clientID, secret, aadEndpoint, tenantID string) (azcore.TokenCredential, error) {
+func getServicePrincipalTokenCredential(clientID, secret string, cloudConfig cloud.Configuration, tenantID string) (azcore.TokenCredential, error) {
 	opts := &azidentity.ClientSecretCredentialOptions{
 		ClientOptions: azcore.ClientOptions{
-			Cloud: cloud.Configuration{
-				ActiveDirectoryAuthorityHost: aadEndpoint,
-			},
+			Cloud: cloudConfig,
 		},
 	}
 	return azidentity.NewClientSecretCredential(tenantID, clientID, secret, opts)
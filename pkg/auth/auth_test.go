@@ -78,6 +78,140 @@ func TestNewConfig(t *testing.T) {
 	}
 }
 
+func TestIdentityKey(t *testing.T) {
+	cases := []struct {
+		desc        string
+		config      Config
+		expectedKey string
+	}{
+		{
+			desc:        "pod identity",
+			config:      Config{UsePodIdentity: true},
+			expectedKey: "podIdentity/default",
+		},
+		{
+			desc:        "user-assigned managed identity",
+			config:      Config{UseVMManagedIdentity: true, UserAssignedIdentityID: "clientid"},
+			expectedKey: "managedIdentity/clientid",
+		},
+		{
+			desc:        "service principal",
+			config:      Config{AADClientID: "clientid", AADClientSecret: "clientsecret"},
+			expectedKey: "servicePrincipal/tid/clientid",
+		},
+		{
+			desc:        "workload identity",
+			config:      Config{WorkloadIdentityClientID: "clientid", WorkloadIdentityToken: "token"},
+			expectedKey: "workloadIdentity/tid/clientid",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if key := tc.config.IdentityKey("tid", "default"); key != tc.expectedKey {
+				t.Fatalf("expected key: %s, got: %s", tc.expectedKey, key)
+			}
+		})
+	}
+}
+
+func TestIsValidMode(t *testing.T) {
+	for _, mode := range []string{ModePodIdentity, ModeManagedIdentity, ModeWorkloadIdentity, ModeServicePrincipal} {
+		if !IsValidMode(mode) {
+			t.Fatalf("expected %s to be valid", mode)
+		}
+	}
+	for _, mode := range []string{"", "none", "vmManagedIdentity"} {
+		if IsValidMode(mode) {
+			t.Fatalf("expected %q to be invalid", mode)
+		}
+	}
+}
+
+func TestClientID(t *testing.T) {
+	cases := []struct {
+		desc             string
+		config           Config
+		expectedClientID string
+	}{
+		{
+			desc:   "pod identity",
+			config: Config{UsePodIdentity: true},
+		},
+		{
+			desc:   "system-assigned managed identity",
+			config: Config{UseVMManagedIdentity: true},
+		},
+		{
+			desc:             "user-assigned managed identity",
+			config:           Config{UseVMManagedIdentity: true, UserAssignedIdentityID: "clientid"},
+			expectedClientID: "clientid",
+		},
+		{
+			desc:             "service principal",
+			config:           Config{AADClientID: "clientid", AADClientSecret: "clientsecret"},
+			expectedClientID: "clientid",
+		},
+		{
+			desc:             "workload identity",
+			config:           Config{WorkloadIdentityClientID: "clientid", WorkloadIdentityToken: "token"},
+			expectedClientID: "clientid",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if clientID := tc.config.ClientID(); clientID != tc.expectedClientID {
+				t.Fatalf("expected client ID: %s, got: %s", tc.expectedClientID, clientID)
+			}
+		})
+	}
+}
+
+func TestCredentialKey(t *testing.T) {
+	cases := []struct {
+		desc               string
+		config             Config
+		serviceAccountName string
+		expectedKey        string
+	}{
+		{
+			desc:        "pod identity",
+			config:      Config{UsePodIdentity: true},
+			expectedKey: "",
+		},
+		{
+			desc:        "user-assigned managed identity",
+			config:      Config{UseVMManagedIdentity: true, UserAssignedIdentityID: "clientid"},
+			expectedKey: "managedIdentity/clientid",
+		},
+		{
+			desc:        "service principal",
+			config:      Config{AADClientID: "clientid", AADClientSecret: "clientsecret"},
+			expectedKey: "servicePrincipal/tid/clientid/c3b268862bb6af823702144a52b39a1c31dd5441b968d6b5efdb925d6ef5f66d",
+		},
+		{
+			desc:               "workload identity",
+			config:             Config{WorkloadIdentityClientID: "clientid", WorkloadIdentityToken: "token"},
+			serviceAccountName: "sa1",
+			expectedKey:        "workloadIdentity/tid/clientid/default/sa1",
+		},
+		{
+			desc:        "workload identity without service account",
+			config:      Config{WorkloadIdentityClientID: "clientid", WorkloadIdentityToken: "token"},
+			expectedKey: "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if key := tc.config.CredentialKey("tid", "default", tc.serviceAccountName); key != tc.expectedKey {
+				t.Fatalf("expected key: %s, got: %s", tc.expectedKey, key)
+			}
+		})
+	}
+}
+
 func TestGetCredential(t *testing.T) {
 	cases := []struct {
 		desc                 string
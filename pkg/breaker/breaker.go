@@ -0,0 +1,200 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit
+type State string
+
+const (
+	// StateClosed lets the requests through
+	StateClosed State = "closed"
+	// StateOpen rejects the requests with the error that opened the circuit
+	StateOpen State = "open"
+	// StateHalfOpen lets a single probe request through to check if the failure is resolved
+	StateHalfOpen State = "halfOpen"
+)
+
+// Key identifies a circuit by the vault and the identity used to access the vault
+type Key struct {
+	// Vault is the URL of the vault
+	Vault string
+	// Identity identifies the identity used to access the vault
+	Identity string
+}
+
+// String returns the key in the <vault> (<identity>) format
+func (k Key) String() string {
+	return fmt.Sprintf("%s (%s)", k.Vault, k.Identity)
+}
+
+// OpenError is returned for the requests rejected by an open circuit. It wraps the error
+// that opened the circuit, so the classification of the error is kept.
+type OpenError struct {
+	Key Key
+	// RetryAfter is the time the circuit lets a probe request through
+	RetryAfter time.Time
+	// Err is the error of the last failed request
+	Err error
+}
+
+// Error implements the error interface
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open for %s until %s, last error: %v", e.Key, e.RetryAfter.UTC().Format(time.RFC3339), e.Err)
+}
+
+// Unwrap returns the error of the last failed request
+func (e *OpenError) Unwrap() error {
+	return e.Err
+}
+
+// Config is the configuration of the circuit breaker
+type Config struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit
+	FailureThreshold int
+	// OpenDuration is the duration the circuit rejects the requests before a probe request is let through
+	OpenDuration time.Duration
+}
+
+// circuit is the state of the requests for a key
+type circuit struct {
+	state    State
+	failures int
+	lastErr  error
+	openedAt time.Time
+	// probing is true while the probe request of the half-open circuit is in flight
+	probing bool
+}
+
+// Breaker stops sending requests to a vault with an identity after repeated failures that
+// are not expected to go away on retry, e.g. the vault was deleted or the identity lost access
+// to the vault. The circuits are kept in memory for each vault and identity.
+type Breaker struct {
+	config Config
+
+	mu       sync.Mutex
+	circuits map[Key]*circuit
+	// listeners are called on every state change of a circuit
+	listeners []func(key Key, from, to State)
+	now       func() time.Time
+}
+
+// New returns the circuit breaker for the config
+func New(config Config) (*Breaker, error) {
+	if config.FailureThreshold <= 0 {
+		return nil, errors.New("failure threshold must be greater than 0")
+	}
+	if config.OpenDuration <= 0 {
+		return nil, errors.New("open duration must be greater than 0")
+	}
+	return &Breaker{
+		config:   config,
+		circuits: make(map[Key]*circuit),
+		now:      time.Now,
+	}, nil
+}
+
+// OnStateChange registers the function called with the previous and the new state every time
+// the state of a circuit changes. The function is called without holding the lock of the breaker.
+func (b *Breaker) OnStateChange(f func(key Key, from, to State)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, f)
+}
+
+// Allow returns nil if the request for the key can be sent or the OpenError if the circuit is
+// open. The open circuit lets a single probe request through once the open duration has passed.
+func (b *Breaker) Allow(key Key) error {
+	b.mu.Lock()
+	c, ok := b.circuits[key]
+	if !ok || c.state == StateClosed {
+		b.mu.Unlock()
+		return nil
+	}
+	retryAfter := c.openedAt.Add(b.config.OpenDuration)
+	if c.state == StateOpen && !b.now().Before(retryAfter) {
+		c.state = StateHalfOpen
+		c.probing = true
+		b.mu.Unlock()
+		b.notify(key, StateOpen, StateHalfOpen)
+		return nil
+	}
+	if c.state == StateHalfOpen && !c.probing {
+		c.probing = true
+		b.mu.Unlock()
+		return nil
+	}
+	err := &OpenError{Key: key, RetryAfter: retryAfter, Err: c.lastErr}
+	b.mu.Unlock()
+	return err
+}
+
+// Record records the result of the request allowed for the key. failure is true if the error
+// is expected to happen again on retry, other errors don't change the state of the circuit.
+func (b *Breaker) Record(key Key, err error, failure bool) {
+	b.mu.Lock()
+	c, ok := b.circuits[key]
+	switch {
+	case err == nil:
+		delete(b.circuits, key)
+		b.mu.Unlock()
+		if ok && c.state != StateClosed {
+			b.notify(key, c.state, StateClosed)
+		}
+		return
+	case !failure:
+		if ok {
+			// the probe didn't tell if the failure is resolved, the next request probes again
+			c.probing = false
+		}
+		b.mu.Unlock()
+		return
+	}
+
+	if !ok {
+		c = &circuit{state: StateClosed}
+		b.circuits[key] = c
+	}
+	from := c.state
+	c.failures++
+	c.lastErr = err
+	c.probing = false
+	if c.state == StateHalfOpen || c.failures >= b.config.FailureThreshold {
+		c.state = StateOpen
+		c.openedAt = b.now()
+	}
+	to := c.state
+	b.mu.Unlock()
+	if from != to {
+		b.notify(key, from, to)
+	}
+}
+
+// OpenCircuits returns the sorted keys of the circuits that are open or half-open
+func (b *Breaker) OpenCircuits() []Key {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []Key
+	for key, c := range b.circuits {
+		if c.state != StateClosed {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
+
+// notify calls the listeners with the state change of the circuit
+func (b *Breaker) notify(key Key, from, to State) {
+	b.mu.Lock()
+	listeners := b.listeners
+	b.mu.Unlock()
+	for _, f := range listeners {
+		f(key, from, to)
+	}
+}
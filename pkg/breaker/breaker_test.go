@@ -0,0 +1,123 @@
+package breaker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		desc        string
+		config      Config
+		expectedErr bool
+	}{
+		{
+			desc:        "failure threshold not set",
+			config:      Config{OpenDuration: time.Minute},
+			expectedErr: true,
+		},
+		{
+			desc:        "open duration not set",
+			config:      Config{FailureThreshold: 3},
+			expectedErr: true,
+		},
+		{
+			desc:   "valid config",
+			config: Config{FailureThreshold: 3, OpenDuration: time.Minute},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := New(tc.config); tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b, err := New(Config{FailureThreshold: 2, OpenDuration: time.Minute})
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+	b.now = func() time.Time { return now }
+	var changes []State
+	b.OnStateChange(func(_ Key, _, to State) { changes = append(changes, to) })
+
+	key := Key{Vault: "test.vault.azure.net", Identity: "workloadIdentity/tid/clientid"}
+	other := Key{Vault: "test.vault.azure.net", Identity: "workloadIdentity/tid/otherclientid"}
+	forbidden := errors.New("forbidden")
+
+	// the errors that are not failures don't open the circuit
+	for i := 0; i < 3; i++ {
+		b.Record(key, errors.New("unavailable"), false)
+	}
+	if err := b.Allow(key); err != nil {
+		t.Fatalf("Allow() = %v, want nil", err)
+	}
+	// a success resets the consecutive failures
+	b.Record(key, forbidden, true)
+	b.Record(key, nil, false)
+	b.Record(key, forbidden, true)
+	if err := b.Allow(key); err != nil {
+		t.Fatalf("Allow() = %v, want nil", err)
+	}
+
+	// the circuit opens after the consecutive failures
+	b.Record(key, forbidden, true)
+	err = b.Allow(key)
+	var openErr *OpenError
+	if !errors.As(err, &openErr) || !errors.Is(err, forbidden) {
+		t.Fatalf("Allow() = %v, want open error wrapping %v", err, forbidden)
+	}
+	if !openErr.RetryAfter.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected retry after %v, got %v", now.Add(time.Minute), openErr.RetryAfter)
+	}
+	if err := b.Allow(other); err != nil {
+		t.Fatalf("Allow() = %v, want nil for the other identity", err)
+	}
+	if open := b.OpenCircuits(); !reflect.DeepEqual(open, []Key{key}) {
+		t.Fatalf("expected open circuits %v, got %v", []Key{key}, open)
+	}
+
+	// a single probe is let through once the open duration has passed
+	now = now.Add(time.Minute)
+	if err := b.Allow(key); err != nil {
+		t.Fatalf("Allow() = %v, want nil for the probe", err)
+	}
+	if err := b.Allow(key); err == nil {
+		t.Fatalf("Allow() = nil, want error while the probe is in flight")
+	}
+	// the probe failed, the circuit opens again
+	b.Record(key, forbidden, true)
+	if err := b.Allow(key); err == nil {
+		t.Fatalf("Allow() = nil, want error after the failed probe")
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Allow(key); err != nil {
+		t.Fatalf("Allow() = %v, want nil for the probe", err)
+	}
+	// the probe didn't tell if the failure is resolved, the next request probes again
+	b.Record(key, errors.New("unavailable"), false)
+	if err := b.Allow(key); err != nil {
+		t.Fatalf("Allow() = %v, want nil for the next probe", err)
+	}
+	// the probe succeeded, the circuit closes
+	b.Record(key, nil, false)
+	if err := b.Allow(key); err != nil {
+		t.Fatalf("Allow() = %v, want nil", err)
+	}
+	if open := b.OpenCircuits(); len(open) != 0 {
+		t.Fatalf("expected no open circuits, got %v", open)
+	}
+
+	expectedChanges := []State{StateOpen, StateHalfOpen, StateOpen, StateHalfOpen, StateClosed}
+	if !reflect.DeepEqual(changes, expectedChanges) {
+		t.Fatalf("expected state changes %v, got %v", expectedChanges, changes)
+	}
+}
@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// keySize is the size of the AES-256 key used to seal the cache entries
+	keySize = 32
+	// entryFileExtension is the extension of the sealed cache entry files
+	entryFileExtension = ".cache"
+	// pruneInterval is the minimum interval between the scans of the cache directory for stale entries
+	pruneInterval = 10 * time.Minute
+)
+
+var (
+	// ErrNotFound is returned when there is no cache entry for the key
+	ErrNotFound = errors.New("cache entry not found")
+	// ErrStale is returned when the cache entry is older than the max staleness
+	ErrStale = errors.New("cache entry is stale")
+)
+
+// Cache is an on-node store of the last successfully mounted content. The entries are
+// sealed with AES-GCM using a node-local key, so the content is never stored in plain text.
+type Cache struct {
+	dir          string
+	aead         cipher.AEAD
+	maxStaleness time.Duration
+	now          func() time.Time
+
+	// mu protects lastPrune
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// entry is the content stored in the cache
+type entry struct {
+	StoredAt time.Time          `json:"storedAt"`
+	Files    []types.SecretFile `json:"files"`
+}
+
+// New creates a cache that stores the entries in dir. The entries are sealed with the 32 byte
+// key in keyFile. If keyFile doesn't exist, a random key is generated and written to the file.
+// Entries older than maxStaleness are never returned and are removed from the cache.
+func New(dir, keyFile string, maxStaleness time.Duration) (*Cache, error) {
+	if maxStaleness <= 0 {
+		return nil, fmt.Errorf("max staleness must be greater than 0")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory %s, error: %w", dir, err)
+	}
+	key, err := loadOrCreateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher, error: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcm, error: %w", err)
+	}
+	c := &Cache{
+		dir:          dir,
+		aead:         aead,
+		maxStaleness: maxStaleness,
+		now:          time.Now,
+	}
+	c.maybePrune()
+	return c, nil
+}
+
+// Key returns the cache key for the SecretProviderClass mounted in the namespace. The fingerprint
+// identifies the parameters and the identity of the mount, so the content isn't served once they
+// change. The pods that mount the SecretProviderClass with the same fingerprint, e.g. the pods of
+// a Deployment, share the entry.
+func Key(podNamespace, secretProviderClass, fingerprint string) string {
+	return strings.Join([]string{podNamespace, secretProviderClass, fingerprint}, "/")
+}
+
+// Store seals the files and stores them as the last known good content for the key
+func (c *Cache) Store(key string, files []types.SecretFile) error {
+	plaintext, err := json.Marshal(entry{StoredAt: c.now(), Files: files})
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry, error: %w", err)
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce, error: %w", err)
+	}
+	// the key is used as additional data so an entry can't be served for another key
+	sealed := c.aead.Seal(nonce, nonce, plaintext, []byte(key))
+
+	if err = writeFileAtomic(c.entryPath(key), sealed); err != nil {
+		return fmt.Errorf("failed to write cache entry, error: %w", err)
+	}
+	c.maybePrune()
+	return nil
+}
+
+// Load returns the last known good content for the key and the time it was stored.
+// ErrNotFound is returned if there is no entry and ErrStale if the entry is older than
+// the max staleness.
+func (c *Cache) Load(key string) ([]types.SecretFile, time.Time, error) {
+	sealed, err := os.ReadFile(c.entryPath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, time.Time{}, ErrNotFound
+		}
+		return nil, time.Time{}, fmt.Errorf("failed to read cache entry, error: %w", err)
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, time.Time{}, fmt.Errorf("cache entry is corrupted")
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(key))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to open cache entry, error: %w", err)
+	}
+	var e entry
+	if err = json.Unmarshal(plaintext, &e); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to unmarshal cache entry, error: %w", err)
+	}
+	if c.now().Sub(e.StoredAt) > c.maxStaleness {
+		return nil, e.StoredAt, ErrStale
+	}
+	return e.Files, e.StoredAt, nil
+}
+
+// entryPath returns the path of the entry file for the key. The key is hashed so the
+// pod and SecretProviderClass names aren't visible on the node.
+func (c *Cache) entryPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+entryFileExtension)
+}
+
+// maybePrune prunes the cache if it wasn't pruned in the last prune interval, so the cache
+// directory isn't scanned on every store
+func (c *Cache) maybePrune() {
+	c.mu.Lock()
+	now := c.now()
+	if now.Sub(c.lastPrune) < pruneInterval {
+		c.mu.Unlock()
+		return
+	}
+	c.lastPrune = now
+	c.mu.Unlock()
+	c.prune()
+}
+
+// prune removes the entries that are older than the max staleness as they can't be served
+func (c *Cache) prune() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		klog.ErrorS(err, "failed to list cache entries", "dir", c.dir)
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != entryFileExtension {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if c.now().Sub(info.ModTime()) <= c.maxStaleness {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, e.Name())); err != nil && !os.IsNotExist(err) {
+			klog.ErrorS(err, "failed to remove stale cache entry", "file", e.Name())
+		}
+	}
+}
+
+// loadOrCreateKey reads the sealing key from the file or generates a new one if
+// the file doesn't exist
+func loadOrCreateKey(keyFile string) ([]byte, error) {
+	key, err := os.ReadFile(keyFile)
+	if err == nil {
+		if len(key) != keySize {
+			return nil, fmt.Errorf("cache key file %s must contain %d bytes, got %d", keyFile, keySize, len(key))
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read cache key file %s, error: %w", keyFile, err)
+	}
+
+	klog.InfoS("generating cache key", "keyFile", keyFile)
+	key = make([]byte, keySize)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate cache key, error: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache key directory, error: %w", err)
+	}
+	if err = writeFileAtomic(keyFile, key); err != nil {
+		return nil, fmt.Errorf("failed to write cache key file %s, error: %w", keyFile, err)
+	}
+	return key, nil
+}
+
+// writeFileAtomic writes the data to a temporary file that's renamed to the path,
+// so a partially written file is never read
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err = f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
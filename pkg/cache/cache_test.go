@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+var testFiles = []types.SecretFile{
+	{
+		Content:  []byte("secret-content"),
+		Path:     "secret1",
+		FileMode: 0644,
+		UID:      "secret/secret1",
+		Version:  "v1",
+	},
+}
+
+func newTestCache(t *testing.T, maxStaleness time.Duration) *Cache {
+	dir := t.TempDir()
+	c, err := New(filepath.Join(dir, "entries"), filepath.Join(dir, "key"), maxStaleness)
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+	return c
+}
+
+func TestStoreAndLoad(t *testing.T) {
+	c := newTestCache(t, time.Hour)
+	key := Key("default", "spc1", "fingerprint1")
+
+	if err := c.Store(key, testFiles); err != nil {
+		t.Fatalf("Store() = %v, want nil", err)
+	}
+	files, storedAt, err := c.Load(key)
+	if err != nil {
+		t.Fatalf("Load() = %v, want nil", err)
+	}
+	if storedAt.IsZero() {
+		t.Fatalf("expected stored time to be set")
+	}
+	if !reflect.DeepEqual(files, testFiles) {
+		t.Fatalf("Load() = %v, want %v", files, testFiles)
+	}
+}
+
+func TestStoreIsSealed(t *testing.T) {
+	c := newTestCache(t, time.Hour)
+	key := Key("default", "spc1", "fingerprint1")
+
+	if err := c.Store(key, testFiles); err != nil {
+		t.Fatalf("Store() = %v, want nil", err)
+	}
+	data, err := os.ReadFile(c.entryPath(key))
+	if err != nil {
+		t.Fatalf("failed to read entry: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret-content")) || bytes.Contains(data, []byte("pod1")) {
+		t.Fatalf("expected cache entry to be sealed")
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	cases := []struct {
+		desc        string
+		setup       func(t *testing.T, c *Cache, key string)
+		expectedErr error
+	}{
+		{
+			desc:        "entry not found",
+			setup:       func(t *testing.T, c *Cache, key string) {},
+			expectedErr: ErrNotFound,
+		},
+		{
+			desc: "entry is stale",
+			setup: func(t *testing.T, c *Cache, key string) {
+				if err := c.Store(key, testFiles); err != nil {
+					t.Fatalf("Store() = %v, want nil", err)
+				}
+				c.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+			},
+			expectedErr: ErrStale,
+		},
+		{
+			desc: "entry sealed for another key",
+			setup: func(t *testing.T, c *Cache, key string) {
+				if err := c.Store(Key("default", "spc1", "fingerprint2"), testFiles); err != nil {
+					t.Fatalf("Store() = %v, want nil", err)
+				}
+				if err := os.Rename(c.entryPath(Key("default", "spc1", "fingerprint2")), c.entryPath(key)); err != nil {
+					t.Fatalf("failed to rename entry: %v", err)
+				}
+			},
+		},
+		{
+			desc: "entry is corrupted",
+			setup: func(t *testing.T, c *Cache, key string) {
+				if err := os.WriteFile(c.entryPath(key), []byte("corrupted"), 0600); err != nil {
+					t.Fatalf("failed to write entry: %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := newTestCache(t, time.Hour)
+			key := Key("default", "spc1", "fingerprint1")
+			tc.setup(t, c, key)
+
+			_, _, err := c.Load(key)
+			if err == nil {
+				t.Fatalf("Load() = nil, want error")
+			}
+			if tc.expectedErr != nil && !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Load() = %v, want %v", err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestKeyIsReused(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	key := Key("default", "spc1", "fingerprint1")
+
+	c, err := New(dir, keyFile, time.Hour)
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+	if err = c.Store(key, testFiles); err != nil {
+		t.Fatalf("Store() = %v, want nil", err)
+	}
+
+	// a new cache with the same key file can open the entries stored before a restart
+	c, err = New(dir, keyFile, time.Hour)
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+	if _, _, err = c.Load(key); err != nil {
+		t.Fatalf("Load() = %v, want nil", err)
+	}
+}
+
+func TestNewError(t *testing.T) {
+	dir := t.TempDir()
+	invalidKeyFile := filepath.Join(dir, "invalid-key")
+	if err := os.WriteFile(invalidKeyFile, []byte("short"), 0600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+
+	cases := []struct {
+		desc         string
+		keyFile      string
+		maxStaleness time.Duration
+	}{
+		{
+			desc:         "invalid max staleness",
+			keyFile:      filepath.Join(dir, "key"),
+			maxStaleness: 0,
+		},
+		{
+			desc:         "invalid key size",
+			keyFile:      invalidKeyFile,
+			maxStaleness: time.Hour,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := New(filepath.Join(dir, "entries"), tc.keyFile, tc.maxStaleness); err == nil {
+				t.Fatalf("New() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	c := newTestCache(t, time.Hour)
+	key := Key("default", "spc1", "fingerprint1")
+
+	if err := c.Store(key, testFiles); err != nil {
+		t.Fatalf("Store() = %v, want nil", err)
+	}
+	c.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	c.prune()
+
+	if _, err := os.Stat(c.entryPath(key)); !os.IsNotExist(err) {
+		t.Fatalf("expected stale entry to be removed, got: %v", err)
+	}
+}
+
+func TestMaybePrune(t *testing.T) {
+	c := newTestCache(t, time.Hour)
+	key := Key("default", "spc1", "fingerprint1")
+	if err := c.Store(key, testFiles); err != nil {
+		t.Fatalf("Store() = %v, want nil", err)
+	}
+
+	// the cache was pruned when it was created, so the stale entry is kept until the prune interval passed
+	now := time.Now().Add(2 * time.Hour)
+	c.mu.Lock()
+	c.lastPrune = now.Add(-pruneInterval / 2)
+	c.mu.Unlock()
+	c.now = func() time.Time { return now }
+	c.maybePrune()
+	if _, err := os.Stat(c.entryPath(key)); err != nil {
+		t.Fatalf("expected the entry to be kept within the prune interval, got: %v", err)
+	}
+
+	now = now.Add(pruneInterval)
+	c.maybePrune()
+	if _, err := os.Stat(c.entryPath(key)); !os.IsNotExist(err) {
+		t.Fatalf("expected stale entry to be removed, got: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package cloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	azcloud "github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// AzureStackCloudName is the name of the cloud that requires the environment
+	// to be loaded from a file
+	AzureStackCloudName = "AzureStackCloud"
+
+	// KeyVault is the service name for Azure Key Vault in the azcore cloud configuration
+	KeyVault azcloud.ServiceName = "keyVault"
+)
+
+// Environment represents the set of endpoints for an Azure cloud that are used
+// by the provider. The JSON representation is compatible with the go-autorest
+// custom cloud environment files.
+type Environment struct {
+	// Name is the name of the cloud environment
+	Name string `json:"name" yaml:"name"`
+	// ActiveDirectoryEndpoint is the Azure AD authority host
+	ActiveDirectoryEndpoint string `json:"activeDirectoryEndpoint" yaml:"activeDirectoryEndpoint"`
+	// KeyVaultEndpoint is the resource used to request tokens for Key Vault
+	KeyVaultEndpoint string `json:"keyVaultEndpoint" yaml:"keyVaultEndpoint"`
+	// KeyVaultDNSSuffix is the DNS suffix used to build the vault URL
+	KeyVaultDNSSuffix string `json:"keyVaultDNSSuffix" yaml:"keyVaultDNSSuffix"`
+}
+
+var (
+	// PublicCloud is the default public Azure cloud environment
+	PublicCloud = Environment{
+		Name:                    "AzurePublicCloud",
+		ActiveDirectoryEndpoint: "https://login.microsoftonline.com/",
+		KeyVaultEndpoint:        "https://vault.azure.net/",
+		KeyVaultDNSSuffix:       "vault.azure.net",
+	}
+	// USGovernmentCloud is the cloud environment for the US Government
+	USGovernmentCloud = Environment{
+		Name:                    "AzureUSGovernmentCloud",
+		ActiveDirectoryEndpoint: "https://login.microsoftonline.us/",
+		KeyVaultEndpoint:        "https://vault.usgovcloudapi.net/",
+		KeyVaultDNSSuffix:       "vault.usgovcloudapi.net",
+	}
+	// ChinaCloud is the cloud environment operated in China
+	ChinaCloud = Environment{
+		Name:                    "AzureChinaCloud",
+		ActiveDirectoryEndpoint: "https://login.chinacloudapi.cn/",
+		KeyVaultEndpoint:        "https://vault.azure.cn/",
+		KeyVaultDNSSuffix:       "vault.azure.cn",
+	}
+	// GermanCloud is the cloud environment operated in Germany
+	GermanCloud = Environment{
+		Name:                    "AzureGermanCloud",
+		ActiveDirectoryEndpoint: "https://login.microsoftonline.de/",
+		KeyVaultEndpoint:        "https://vault.microsoftazure.de/",
+		KeyVaultDNSSuffix:       "vault.microsoftazure.de",
+	}
+)
+
+// registry holds the cloud environments known to the process. It is populated with
+// the well known Azure clouds and the custom clouds registered at startup.
+var registry = struct {
+	sync.RWMutex
+	environments map[string]Environment
+}{
+	environments: map[string]Environment{
+		strings.ToUpper(PublicCloud.Name):       PublicCloud,
+		strings.ToUpper(USGovernmentCloud.Name): USGovernmentCloud,
+		strings.ToUpper(ChinaCloud.Name):        ChinaCloud,
+		strings.ToUpper(GermanCloud.Name):       GermanCloud,
+	},
+}
+
+// Configuration returns the azcore cloud configuration for the environment
+func (e Environment) Configuration() azcloud.Configuration {
+	return azcloud.Configuration{
+		ActiveDirectoryAuthorityHost: e.ActiveDirectoryEndpoint,
+		Services: map[azcloud.ServiceName]azcloud.ServiceConfiguration{
+			KeyVault: {
+				Audience: strings.TrimSuffix(e.KeyVaultEndpoint, "/"),
+			},
+		},
+	}
+}
+
+// Validate checks the environment has all the endpoints required by the provider
+func (e Environment) Validate() error {
+	var missing []string
+	if e.ActiveDirectoryEndpoint == "" {
+		missing = append(missing, "activeDirectoryEndpoint")
+	}
+	if e.KeyVaultEndpoint == "" {
+		missing = append(missing, "keyVaultEndpoint")
+	}
+	if e.KeyVaultDNSSuffix == "" {
+		missing = append(missing, "keyVaultDNSSuffix")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("cloud environment %q is missing required fields: %s", e.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Register adds the custom cloud environment to the process registry. The environment
+// can then be referenced by name in the SecretProviderClass. Registering an environment
+// with the name of an existing environment replaces it.
+func Register(env Environment) error {
+	if env.Name == "" {
+		return fmt.Errorf("cloud environment name is not set")
+	}
+	if err := env.Validate(); err != nil {
+		return err
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	registry.environments[strings.ToUpper(env.Name)] = env
+	klog.InfoS("registered cloud environment", "name", env.Name)
+	return nil
+}
+
+// RegisterFromFiles loads the custom cloud environment files and adds them to the process registry
+func RegisterFromFiles(paths []string) error {
+	for _, path := range paths {
+		env, err := EnvironmentFromFile(path)
+		if err != nil {
+			return err
+		}
+		if err := Register(env); err != nil {
+			return fmt.Errorf("failed to register cloud environment from file %s, error: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// EnvironmentFromName returns the registered cloud environment with the given name.
+// The name lookup is case-insensitive.
+func EnvironmentFromName(name string) (Environment, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	env, ok := registry.environments[strings.ToUpper(name)]
+	if !ok {
+		return Environment{}, fmt.Errorf("there is no cloud environment matching the name %q", name)
+	}
+	return env, nil
+}
+
+// EnvironmentFromFile loads the cloud environment from a file on disk
+func EnvironmentFromFile(path string) (Environment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Environment{}, fmt.Errorf("failed to read cloud environment file %s, error: %w", path, err)
+	}
+	return ParseEnvironment(data)
+}
+
+// ParseEnvironment parses the JSON or YAML representation of a cloud environment
+func ParseEnvironment(data []byte) (Environment, error) {
+	var env Environment
+	var err error
+	// environment files generated for go-autorest are JSON documents which are
+	// not always valid YAML (e.g. tab indentation), so decode them as JSON
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		err = json.Unmarshal(data, &env)
+	} else {
+		err = yaml.Unmarshal(data, &env)
+	}
+	if err != nil {
+		return Environment{}, fmt.Errorf("failed to unmarshal cloud environment, error: %w", err)
+	}
+	if err := env.Validate(); err != nil {
+		return Environment{}, err
+	}
+	return env, nil
+}
@@ -0,0 +1,133 @@
+package cloud
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEnvironmentFromName(t *testing.T) {
+	cases := []struct {
+		desc        string
+		name        string
+		expectedEnv Environment
+		expectedErr bool
+	}{
+		{
+			desc:        "public cloud",
+			name:        "AzurePublicCloud",
+			expectedEnv: PublicCloud,
+		},
+		{
+			desc:        "case insensitive lookup",
+			name:        "AZUREUSGOVERNMENTCLOUD",
+			expectedEnv: USGovernmentCloud,
+		},
+		{
+			desc:        "unknown cloud",
+			name:        "AzureCloud",
+			expectedErr: true,
+		},
+		{
+			desc:        "azure stack cloud is not registered by default",
+			name:        AzureStackCloudName,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			env, err := EnvironmentFromName(tc.name)
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(env, tc.expectedEnv) {
+				t.Fatalf("expected env: %+v, got: %+v", tc.expectedEnv, env)
+			}
+		})
+	}
+}
+
+func TestParseEnvironment(t *testing.T) {
+	expectedEnv := Environment{
+		Name:                    "AzureStackCloud",
+		ActiveDirectoryEndpoint: "https://login.microsoftonline.com/",
+		KeyVaultEndpoint:        "https://vault.azure.net/",
+		KeyVaultDNSSuffix:       "vault.azure.net",
+	}
+
+	cases := []struct {
+		desc        string
+		data        string
+		expectedErr bool
+	}{
+		{
+			desc: "json with tab indentation",
+			data: "{\n\t\"name\": \"AzureStackCloud\",\n\t\"activeDirectoryEndpoint\": \"https://login.microsoftonline.com/\",\n\t\"keyVaultEndpoint\": \"https://vault.azure.net/\",\n\t\"keyVaultDNSSuffix\": \"vault.azure.net\",\n\t\"resourceManagerEndpoint\": \"https://management.azure.com/\"\n}",
+		},
+		{
+			desc: "yaml",
+			data: `
+name: AzureStackCloud
+activeDirectoryEndpoint: https://login.microsoftonline.com/
+keyVaultEndpoint: https://vault.azure.net/
+keyVaultDNSSuffix: vault.azure.net`,
+		},
+		{
+			desc:        "missing key vault dns suffix",
+			data:        `{"name": "AzureStackCloud", "activeDirectoryEndpoint": "https://login.microsoftonline.com/", "keyVaultEndpoint": "https://vault.azure.net/"}`,
+			expectedErr: true,
+		},
+		{
+			desc:        "invalid json",
+			data:        `{"name": `,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			env, err := ParseEnvironment([]byte(tc.data))
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if !tc.expectedErr && !reflect.DeepEqual(env, expectedEnv) {
+				t.Fatalf("expected env: %+v, got: %+v", expectedEnv, env)
+			}
+		})
+	}
+}
+
+func TestRegisterFromFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custom.json")
+	data := `{"name": "MyCustomCloud", "activeDirectoryEndpoint": "https://login.contoso/", "keyVaultEndpoint": "https://vault.contoso/", "keyVaultDNSSuffix": "vault.contoso"}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("expected error to be nil, got: %+v", err)
+	}
+
+	if err := RegisterFromFiles([]string{filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Fatalf("expected error for missing file, got nil")
+	}
+	if err := RegisterFromFiles([]string{path}); err != nil {
+		t.Fatalf("expected error to be nil, got: %+v", err)
+	}
+
+	env, err := EnvironmentFromName("mycustomcloud")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got: %+v", err)
+	}
+	if env.KeyVaultDNSSuffix != "vault.contoso" {
+		t.Fatalf("expected key vault dns suffix: vault.contoso, got: %s", env.KeyVaultDNSSuffix)
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	config := PublicCloud.Configuration()
+	if config.ActiveDirectoryAuthorityHost != "https://login.microsoftonline.com/" {
+		t.Fatalf("expected authority host: https://login.microsoftonline.com/, got: %s", config.ActiveDirectoryAuthorityHost)
+	}
+	if got := config.Services[KeyVault].Audience; got != "https://vault.azure.net" {
+		t.Fatalf("expected key vault audience: https://vault.azure.net, got: %s", got)
+	}
+}
@@ -1,22 +1,135 @@
 package metrics
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/version"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
 	"k8s.io/klog/v2"
 )
 
-const prometheusExporter = "prometheus"
-
-func InitMetricsExporter(metricsBackend string, prometheusPort int) error {
-	mb := strings.ToLower(metricsBackend)
-	klog.InfoS("intializing metrics backend", "backend", mb)
-	switch mb {
-	// Prometheus is the only exporter for now
-	case prometheusExporter:
-		return initPrometheusExporter(prometheusPort)
-	default:
-		return fmt.Errorf("unsupported metrics backend %v", metricsBackend)
+const (
+	prometheusExporter = "prometheus"
+	otlpExporter       = "otlp"
+
+	// OTLPProtocolGRPC exports the metrics to the collector over gRPC
+	OTLPProtocolGRPC = "grpc"
+	// OTLPProtocolHTTP exports the metrics to the collector over HTTP with protobuf payloads
+	OTLPProtocolHTTP = "http"
+
+	// MountOutcomeSuccess is the outcome of the mount when the objects were fetched from key vault
+	MountOutcomeSuccess = "success"
+	// MountOutcomeFailure is the outcome of the mount when the objects couldn't be fetched
+	MountOutcomeFailure = "failure"
+	// MountOutcomeStale is the outcome of the mount when the last known good content was served
+	MountOutcomeStale = "stale"
+)
+
+// Config is the configuration of the metrics exporters
+type Config struct {
+	// Backends is the comma separated list of the metrics backends. Allowed values: Prometheus, OTLP
+	Backends string
+	// PrometheusPort is the port the Prometheus metrics are served on
+	PrometheusPort int
+	// OTLPEndpoint is the host:port of the OTLP collector. The OTEL_EXPORTER_OTLP_* environment
+	// variables are used if not set.
+	OTLPEndpoint string
+	// OTLPProtocol is the protocol used to export the metrics to the OTLP collector. Allowed values: grpc, http
+	OTLPProtocol string
+	// OTLPInsecure disables TLS for the connection to the OTLP collector
+	OTLPInsecure bool
+	// OTLPExportInterval is the interval at which the metrics are exported to the OTLP collector
+	OTLPExportInterval time.Duration
+	// DropLabels are the keys of the labels removed from all the metrics, e.g. object_name to
+	// bound the cardinality of the metrics with a large number of objects
+	DropLabels []string
+}
+
+// InitMetricsExporter installs the global meter provider that exports the metrics to the
+// configured backends. The returned function flushes the pending metrics and stops the exporters.
+func InitMetricsExporter(ctx context.Context, config Config) (func(context.Context) error, error) {
+	backends, err := parseBackends(config.Backends)
+	if err != nil {
+		return nil, err
+	}
+	view, err := newDropLabelsView(config.DropLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdkmetric.Option{
+		sdkmetric.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.version", version.BuildVersion),
+		)),
+		sdkmetric.WithView(view),
+	}
+	for _, backend := range backends {
+		klog.InfoS("intializing metrics backend", "backend", backend)
+		var reader sdkmetric.Reader
+		switch backend {
+		case prometheusExporter:
+			reader, err = initPrometheusExporter(config.PrometheusPort)
+		case otlpExporter:
+			reader, err = initOTLPExporter(ctx, config)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize %s metrics backend, error: %w", backend, err)
+		}
+		opts = append(opts, sdkmetric.WithReader(reader))
+	}
+
+	mp := sdkmetric.NewMeterProvider(opts...)
+	otel.SetMeterProvider(mp)
+	return mp.Shutdown, nil
+}
+
+// parseBackends returns the lower case metrics backends in the comma separated list
+func parseBackends(metricsBackends string) ([]string, error) {
+	var backends []string
+	seen := make(map[string]bool)
+	for _, backend := range strings.Split(metricsBackends, ",") {
+		mb := strings.ToLower(strings.TrimSpace(backend))
+		switch mb {
+		case prometheusExporter, otlpExporter:
+		default:
+			return nil, fmt.Errorf("unsupported metrics backend %v", backend)
+		}
+		if !seen[mb] {
+			seen[mb] = true
+			backends = append(backends, mb)
+		}
+	}
+	if len(backends) == 0 {
+		return nil, errors.New("no metrics backend configured")
+	}
+	return backends, nil
+}
+
+// newDropLabelsView returns the view that removes the labels from all the metrics
+func newDropLabelsView(dropLabels []string) (sdkmetric.View, error) {
+	keys := make([]attribute.Key, 0, len(dropLabels))
+	for _, label := range dropLabels {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			continue
+		}
+		if !slices.Contains(labelKeys, label) {
+			return nil, fmt.Errorf("unsupported metrics label %v, should be one of %s", label, strings.Join(labelKeys, ", "))
+		}
+		keys = append(keys, attribute.Key(label))
 	}
+	return sdkmetric.NewView(
+		sdkmetric.Instrument{Name: "*"},
+		sdkmetric.Stream{AttributeFilter: attribute.NewDenyKeysFilter(keys...)},
+	), nil
 }
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBackends(t *testing.T) {
+	cases := []struct {
+		desc             string
+		metricsBackends  string
+		expectedBackends []string
+		expectedErr      bool
+	}{
+		{
+			desc:             "prometheus",
+			metricsBackends:  "Prometheus",
+			expectedBackends: []string{"prometheus"},
+		},
+		{
+			desc:             "prometheus and otlp",
+			metricsBackends:  "Prometheus, OTLP",
+			expectedBackends: []string{"prometheus", "otlp"},
+		},
+		{
+			desc:             "duplicate backends",
+			metricsBackends:  "otlp,OTLP",
+			expectedBackends: []string{"otlp"},
+		},
+		{
+			desc:            "unsupported backend",
+			metricsBackends: "prometheus,stackdriver",
+			expectedErr:     true,
+		},
+		{
+			desc:            "no backend",
+			metricsBackends: "",
+			expectedErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			backends, err := parseBackends(tc.metricsBackends)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(backends, tc.expectedBackends) {
+				t.Fatalf("expected backends %v, got %v", tc.expectedBackends, backends)
+			}
+		})
+	}
+}
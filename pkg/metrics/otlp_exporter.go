@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+func initOTLPExporter(ctx context.Context, config Config) (sdkmetric.Reader, error) {
+	var exporter sdkmetric.Exporter
+	var err error
+	switch strings.ToLower(config.OTLPProtocol) {
+	case "", OTLPProtocolGRPC:
+		var opts []otlpmetricgrpc.Option
+		if config.OTLPEndpoint != "" {
+			opts = append(opts, otlpmetricgrpc.WithEndpoint(config.OTLPEndpoint))
+		}
+		if config.OTLPInsecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		exporter, err = otlpmetricgrpc.New(ctx, opts...)
+	case OTLPProtocolHTTP:
+		var opts []otlpmetrichttp.Option
+		if config.OTLPEndpoint != "" {
+			opts = append(opts, otlpmetrichttp.WithEndpoint(config.OTLPEndpoint))
+		}
+		if config.OTLPInsecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		exporter, err = otlpmetrichttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %v, should be grpc or http", config.OTLPProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []sdkmetric.PeriodicReaderOption
+	if config.OTLPExportInterval > 0 {
+		opts = append(opts, sdkmetric.WithInterval(config.OTLPExportInterval))
+	}
+	return sdkmetric.NewPeriodicReader(exporter, opts...), nil
+}
@@ -5,7 +5,10 @@ import (
 	"net/http"
 	"time"
 
-	"go.opentelemetry.io/otel/exporters/metric/prometheus"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"k8s.io/klog/v2"
 )
 
@@ -13,22 +16,23 @@ const (
 	readHeaderTimeout = 5 * time.Second
 )
 
-func initPrometheusExporter(port int) error {
-	pusher, err := prometheus.InstallNewPipeline(prometheus.Config{
-		DefaultHistogramBoundaries: []float64{
-			0.1, 0.2, 0.3, 0.4, 0.5, 1, 1.5, 2, 2.5, 3.0, 5.0, 10.0, 15.0, 30.0,
-		}})
+func initPrometheusExporter(port int) (sdkmetric.Reader, error) {
+	registry := prom.NewRegistry()
+	// the scope labels are not added to keep the labels of the series unchanged
+	exporter, err := prometheus.New(prometheus.WithRegisterer(registry), prometheus.WithoutScopeInfo())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	http.HandleFunc("/metrics", pusher.ServeHTTP)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
 	go func() {
 		server := &http.Server{
 			Addr:              fmt.Sprintf(":%v", port),
+			Handler:           mux,
 			ReadHeaderTimeout: readHeaderTimeout,
 		}
 		klog.ErrorS(server.ListenAndServe(), "listen and server error")
 	}()
 
-	return err
+	return exporter, nil
 }
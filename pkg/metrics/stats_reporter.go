@@ -4,43 +4,120 @@ import (
 	"context"
 	"runtime"
 
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
-	"go.opentelemetry.io/otel/metric/global"
+)
+
+const (
+	// serviceName is the name of the meter and the service name of the metrics
+	serviceName = "csi-secrets-store-provider-azure"
 )
 
 var (
 	providerAttr = attribute.String("provider", "azure")
 	osTypeAttr   = attribute.String("os_type", runtime.GOOS)
 	// set service.name attribute explicitly to the provider name as the default service name is "unknown_service:<binary name>"
-	serviceNameAttr = attribute.String("service.name", "csi-secrets-store-provider-azure")
+	serviceNameAttr = attribute.String("service.name", serviceName)
 	objectTypeKey   = "object_type"
 	objectNameKey   = "object_name"
 	errorKey        = "error"
 	grpcMethodKey   = "grpc_method"
 	grpcCodeKey     = "grpc_code"
 	grpcMessageKey  = "grpc_message"
-	keyvaultRequest metric.Float64ValueRecorder
-	grpcRequest     metric.Float64ValueRecorder
+	errorCodeKey    = "error_code"
+	outcomeKey      = "outcome"
+	authModeKey     = "auth_mode"
+	vaultKey        = "vault"
+	statusCodeKey   = "status_code"
+	stateKey        = "state"
+	namespaceKey    = "namespace"
+	keyvaultRequest metric.Float64Histogram
+	grpcRequest     metric.Float64Histogram
+	skippedObject   metric.Int64Counter
+	staleContent    metric.Float64Histogram
+	mountTotal      metric.Int64Counter
+	mountedObject   metric.Int64Counter
+	mountedBytes    metric.Int64Counter
+	tokenRequest    metric.Float64Histogram
+	versionChange   metric.Int64Counter
+	vaultRequest    metric.Int64Counter
+	circuitChange   metric.Int64Counter
+	circuitOpen     metric.Int64UpDownCounter
+	circuitRejected metric.Int64Counter
+	deprecatedAuth  metric.Int64Counter
+
+	// labelKeys are the keys of the labels that can be dropped from the metrics
+	labelKeys = []string{
+		objectTypeKey, objectNameKey, errorKey, grpcMethodKey, grpcCodeKey, grpcMessageKey,
+		errorCodeKey, outcomeKey, authModeKey, vaultKey, statusCodeKey, stateKey, namespaceKey,
+	}
+
+	// durationBuckets are the bucket boundaries in seconds of the request duration histograms
+	durationBuckets = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 1, 1.5, 2, 2.5, 3.0, 5.0, 10.0, 15.0, 30.0}
+	// ageBuckets are the bucket boundaries in seconds of the age of the stale content
+	ageBuckets = []float64{60, 300, 900, 1800, 3600, 7200, 14400, 43200, 86400, 172800, 604800}
 )
 
-type reporter struct {
-	meter metric.Meter
-}
+// circuitStateClosed is the state of the circuit breaker that lets the requests through
+const circuitStateClosed = "closed"
+
+type reporter struct{}
 
 // StatsReporter is the interface for reporting metrics
 type StatsReporter interface {
 	ReportKeyvaultRequest(ctx context.Context, duration float64, objectType, objectName, err string)
 	ReportGRPCRequest(ctx context.Context, duration float64, method, code, message string)
+	ReportSkippedObject(ctx context.Context, objectType, objectName, errorCode string)
+	ReportStaleContentServed(ctx context.Context, errorCode string, age float64)
+	ReportMount(ctx context.Context, outcome, authMode, errorCode string)
+	ReportMountedObject(ctx context.Context, objectType, objectName string, bytes int)
+	ReportTokenRequest(ctx context.Context, duration float64, authMode, errorCode string)
+	ReportObjectVersionChange(ctx context.Context, objectType, objectName string)
+	ReportVaultRequest(ctx context.Context, vault, statusCode string)
+	ReportCircuitBreakerStateChange(ctx context.Context, vault, from, to string)
+	ReportCircuitBreakerRejected(ctx context.Context, vault string)
+	ReportDeprecatedAuthMode(ctx context.Context, authMode, namespace string)
 }
 
 // NewStatsReporter creates a new StatsReporter
 func NewStatsReporter() StatsReporter {
-	meter := global.Meter("csi-secrets-store-provider-azure")
+	meter := otel.Meter(serviceName)
 
-	keyvaultRequest = metric.Must(meter).NewFloat64ValueRecorder("keyvault_request", metric.WithDescription("Distribution of how long it took to get from keyvault"))
-	grpcRequest = metric.Must(meter).NewFloat64ValueRecorder("grpc_request", metric.WithDescription("Distribution of how long it took for the gRPC requests"))
-	return &reporter{meter: meter}
+	// the errors are only returned for invalid instrument names and options
+	keyvaultRequest, _ = meter.Float64Histogram("keyvault_request",
+		metric.WithDescription("Distribution of how long it took to get from keyvault"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	grpcRequest, _ = meter.Float64Histogram("grpc_request",
+		metric.WithDescription("Distribution of how long it took for the gRPC requests"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	skippedObject, _ = meter.Int64Counter("skipped_object_total",
+		metric.WithDescription("Total number of optional objects skipped from the mount because they couldn't be fetched"))
+	staleContent, _ = meter.Float64Histogram("stale_content_served",
+		metric.WithDescription("Distribution of the age in seconds of the last known good content served when key vault is unavailable"),
+		metric.WithExplicitBucketBoundaries(ageBuckets...))
+	mountTotal, _ = meter.Int64Counter("mount_total",
+		metric.WithDescription("Total number of mount requests by outcome and identity access mode"))
+	mountedObject, _ = meter.Int64Counter("mounted_object_total",
+		metric.WithDescription("Total number of objects fetched from key vault and mounted"))
+	mountedBytes, _ = meter.Int64Counter("mounted_bytes_total",
+		metric.WithDescription("Total number of bytes of the files of the objects mounted"))
+	tokenRequest, _ = meter.Float64Histogram("token_request",
+		metric.WithDescription("Distribution of how long it took to get the access token by identity access mode"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	versionChange, _ = meter.Int64Counter("object_version_change_total",
+		metric.WithDescription("Total number of object version changes detected on rotation"))
+	vaultRequest, _ = meter.Int64Counter("vault_request_total",
+		metric.WithDescription("Total number of HTTP requests sent to the vault by status code"))
+	circuitChange, _ = meter.Int64Counter("circuit_breaker_state_change_total",
+		metric.WithDescription("Total number of state changes of the circuit breaker by vault and new state"))
+	circuitOpen, _ = meter.Int64UpDownCounter("circuit_breaker_open",
+		metric.WithDescription("Number of open or half-open circuits of the circuit breaker by vault"))
+	circuitRejected, _ = meter.Int64Counter("circuit_breaker_rejected_total",
+		metric.WithDescription("Total number of mounts rejected by an open circuit of the circuit breaker"))
+	deprecatedAuth, _ = meter.Int64Counter("deprecated_auth_mode_total",
+		metric.WithDescription("Total number of mount requests that use a deprecated identity access mode"))
+	return &reporter{}
 }
 
 // ReportKeyvaultRequest reports the duration of the keyvault request
@@ -55,10 +132,7 @@ func (r *reporter) ReportKeyvaultRequest(ctx context.Context, duration float64,
 		attribute.String(objectNameKey, objectName),
 		attribute.String(errorKey, err),
 	}
-	r.meter.RecordBatch(ctx,
-		attributes,
-		keyvaultRequest.Measurement(duration),
-	)
+	keyvaultRequest.Record(ctx, duration, metric.WithAttributes(attributes...))
 }
 
 // ReportGRPCRequest reports the duration of the gRPC request
@@ -72,8 +146,150 @@ func (r *reporter) ReportGRPCRequest(ctx context.Context, duration float64, meth
 		attribute.String(grpcCodeKey, code),
 		attribute.String(grpcMessageKey, message),
 	}
-	r.meter.RecordBatch(ctx,
-		attributes,
-		grpcRequest.Measurement(duration),
-	)
+	grpcRequest.Record(ctx, duration, metric.WithAttributes(attributes...))
+}
+
+// ReportSkippedObject reports an optional object that was skipped from the mount
+// objectType and objectName are used to identify the object that was skipped
+// errorCode is the classification of the error that caused the object to be skipped
+func (r *reporter) ReportSkippedObject(ctx context.Context, objectType, objectName, errorCode string) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(objectTypeKey, objectType),
+		attribute.String(objectNameKey, objectName),
+		attribute.String(errorCodeKey, errorCode),
+	}
+	skippedObject.Add(ctx, 1, metric.WithAttributes(attributes...))
+}
+
+// ReportStaleContentServed reports the last known good content served from the cache
+// errorCode is the classification of the error that caused the cached content to be served
+// age is the time in seconds since the content was fetched from key vault
+func (r *reporter) ReportStaleContentServed(ctx context.Context, errorCode string, age float64) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(errorCodeKey, errorCode),
+	}
+	staleContent.Record(ctx, age, metric.WithAttributes(attributes...))
+}
+
+// ReportMount reports the outcome of the mount request
+// outcome is success, failure or stale if the last known good content was served
+// authMode is the identity access mode used to get the credential
+// errorCode is the classification of the error if the mount failed
+func (r *reporter) ReportMount(ctx context.Context, outcome, authMode, errorCode string) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(outcomeKey, outcome),
+		attribute.String(authModeKey, authMode),
+		attribute.String(errorCodeKey, errorCode),
+	}
+	mountTotal.Add(ctx, 1, metric.WithAttributes(attributes...))
+}
+
+// ReportMountedObject reports an object that was fetched from key vault and mounted
+// objectType and objectName are used to identify the object
+// bytes is the total size of the files written for the object
+func (r *reporter) ReportMountedObject(ctx context.Context, objectType, objectName string, bytes int) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(objectTypeKey, objectType),
+		attribute.String(objectNameKey, objectName),
+	}
+	mountedObject.Add(ctx, 1, metric.WithAttributes(attributes...))
+	mountedBytes.Add(ctx, int64(bytes), metric.WithAttributes(attributes...))
+}
+
+// ReportTokenRequest reports the duration of the access token request
+// authMode is the identity access mode used to get the token
+// errorCode is the classification of the error if the token request failed
+func (r *reporter) ReportTokenRequest(ctx context.Context, duration float64, authMode, errorCode string) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(authModeKey, authMode),
+		attribute.String(errorCodeKey, errorCode),
+	}
+	tokenRequest.Record(ctx, duration, metric.WithAttributes(attributes...))
+}
+
+// ReportObjectVersionChange reports an object with a new version detected on rotation
+// objectType and objectName are used to identify the object
+func (r *reporter) ReportObjectVersionChange(ctx context.Context, objectType, objectName string) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(objectTypeKey, objectType),
+		attribute.String(objectNameKey, objectName),
+	}
+	versionChange.Add(ctx, 1, metric.WithAttributes(attributes...))
+}
+
+// ReportVaultRequest reports an HTTP request sent to the vault
+// vault is the host name of the vault
+// statusCode is the HTTP status code of the response or empty if no response was received
+func (r *reporter) ReportVaultRequest(ctx context.Context, vault, statusCode string) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(vaultKey, vault),
+		attribute.String(statusCodeKey, statusCode),
+	}
+	vaultRequest.Add(ctx, 1, metric.WithAttributes(attributes...))
+}
+
+// ReportCircuitBreakerStateChange reports the state change of the circuit of a vault and an identity
+// vault is the host name of the vault
+// from and to are the previous and the new state of the circuit: closed, open or halfOpen
+func (r *reporter) ReportCircuitBreakerStateChange(ctx context.Context, vault, from, to string) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(vaultKey, vault),
+	}
+	circuitChange.Add(ctx, 1, metric.WithAttributes(append(attributes, attribute.String(stateKey, to))...))
+	switch {
+	case from == circuitStateClosed && to != circuitStateClosed:
+		circuitOpen.Add(ctx, 1, metric.WithAttributes(attributes...))
+	case from != circuitStateClosed && to == circuitStateClosed:
+		circuitOpen.Add(ctx, -1, metric.WithAttributes(attributes...))
+	}
+}
+
+// ReportCircuitBreakerRejected reports a mount rejected by an open circuit
+// vault is the host name of the vault
+func (r *reporter) ReportCircuitBreakerRejected(ctx context.Context, vault string) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(vaultKey, vault),
+	}
+	circuitRejected.Add(ctx, 1, metric.WithAttributes(attributes...))
+}
+
+// ReportDeprecatedAuthMode reports a mount request that uses a deprecated identity access mode
+// authMode is the deprecated identity access mode: podIdentity or managedIdentity
+// namespace is the namespace of the pod
+func (r *reporter) ReportDeprecatedAuthMode(ctx context.Context, authMode, namespace string) {
+	attributes := []attribute.KeyValue{
+		serviceNameAttr,
+		providerAttr,
+		osTypeAttr,
+		attribute.String(authModeKey, authMode),
+		attribute.String(namespaceKey, namespace),
+	}
+	deprecatedAuth.Add(ctx, 1, metric.WithAttributes(attributes...))
 }
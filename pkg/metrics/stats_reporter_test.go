@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestStatsReporter(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	previous := otel.GetMeterProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	defer otel.SetMeterProvider(previous)
+
+	ctx := context.Background()
+	r := NewStatsReporter()
+	r.ReportKeyvaultRequest(ctx, 0.25, "secret", "secret1", "")
+	r.ReportGRPCRequest(ctx, 1.2, "/v1alpha1.CSIDriverProvider/Mount", "OK", "")
+	r.ReportSkippedObject(ctx, "secret", "secret2", "NotFound")
+	r.ReportStaleContentServed(ctx, "Unavailable", 120)
+	r.ReportMount(ctx, MountOutcomeSuccess, "workloadIdentity", "")
+	r.ReportMountedObject(ctx, "secret", "secret1", 10)
+	r.ReportTokenRequest(ctx, 0.5, "workloadIdentity", "")
+	r.ReportObjectVersionChange(ctx, "secret", "secret1")
+	r.ReportVaultRequest(ctx, "test.vault.azure.net", "200")
+	r.ReportCircuitBreakerStateChange(ctx, "test.vault.azure.net", "closed", "open")
+	r.ReportCircuitBreakerRejected(ctx, "test.vault.azure.net")
+	r.ReportDeprecatedAuthMode(ctx, "podIdentity", "default")
+
+	rm := metricdata.ResourceMetrics{}
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatalf("Collect() = %v, want nil", err)
+	}
+	if len(rm.ScopeMetrics) != 1 {
+		t.Fatalf("expected 1 scope, got %d", len(rm.ScopeMetrics))
+	}
+
+	histograms := map[string][]float64{}
+	counters := map[string]int64{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		switch data := m.Data.(type) {
+		case metricdata.Histogram[float64]:
+			histograms[m.Name] = data.DataPoints[0].Bounds
+		case metricdata.Sum[int64]:
+			counters[m.Name] = data.DataPoints[0].Value
+		default:
+			t.Fatalf("unexpected metric %s of type %T", m.Name, m.Data)
+		}
+	}
+	expected := map[string][]float64{
+		"keyvault_request":     durationBuckets,
+		"grpc_request":         durationBuckets,
+		"stale_content_served": ageBuckets,
+		"token_request":        durationBuckets,
+	}
+	if !reflect.DeepEqual(histograms, expected) {
+		t.Fatalf("expected histograms %v, got %v", expected, histograms)
+	}
+	expectedCounters := map[string]int64{
+		"skipped_object_total":               1,
+		"mount_total":                        1,
+		"mounted_object_total":               1,
+		"mounted_bytes_total":                10,
+		"object_version_change_total":        1,
+		"vault_request_total":                1,
+		"circuit_breaker_state_change_total": 1,
+		"circuit_breaker_open":               1,
+		"circuit_breaker_rejected_total":     1,
+		"deprecated_auth_mode_total":         1,
+	}
+	if !reflect.DeepEqual(counters, expectedCounters) {
+		t.Fatalf("expected counters %v, got %v", expectedCounters, counters)
+	}
+}
+
+func TestDropLabels(t *testing.T) {
+	view, err := newDropLabelsView([]string{"object_name", " error", ""})
+	if err != nil {
+		t.Fatalf("newDropLabelsView() = %v, want nil", err)
+	}
+	reader := sdkmetric.NewManualReader()
+	previous := otel.GetMeterProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithView(view)))
+	defer otel.SetMeterProvider(previous)
+
+	ctx := context.Background()
+	r := NewStatsReporter()
+	r.ReportKeyvaultRequest(ctx, 0.25, "secret", "secret1", "failed")
+	r.ReportKeyvaultRequest(ctx, 0.25, "secret", "secret2", "")
+
+	rm := metricdata.ResourceMetrics{}
+	if err = reader.Collect(ctx, &rm); err != nil {
+		t.Fatalf("Collect() = %v, want nil", err)
+	}
+	data := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[float64])
+	// the requests are aggregated in the same series without the dropped labels
+	if len(data.DataPoints) != 1 || data.DataPoints[0].Count != 2 {
+		t.Fatalf("expected 1 series with 2 requests, got %+v", data.DataPoints)
+	}
+	dp := data.DataPoints[0]
+	for _, key := range []string{objectNameKey, errorKey} {
+		if dp.Attributes.HasValue(attribute.Key(key)) {
+			t.Fatalf("expected label %s to be dropped, got %v", key, dp.Attributes)
+		}
+	}
+	if v, _ := dp.Attributes.Value(attribute.Key(objectTypeKey)); v.AsString() != "secret" {
+		t.Fatalf("expected object type secret, got %s", v.AsString())
+	}
+	if !reflect.DeepEqual(dp.Bounds, durationBuckets) {
+		t.Fatalf("expected buckets %v, got %v", durationBuckets, dp.Bounds)
+	}
+
+	if _, err = newDropLabelsView([]string{"pod"}); err == nil {
+		t.Fatalf("newDropLabelsView() = nil, want error for unsupported label")
+	}
+}
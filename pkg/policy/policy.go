@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SystemAssignedIdentity is the client ID used in the rules for the system-assigned managed identity
+const SystemAssignedIdentity = "systemAssigned"
+
+// Rule allows the mounts that match all the fields of the rule. A field that isn't set matches any mount.
+type Rule struct {
+	// Namespaces are the namespaces of the pods
+	Namespaces []string `json:"namespaces" yaml:"namespaces"`
+	// ServiceAccounts are the service accounts of the pods
+	ServiceAccounts []string `json:"serviceAccounts" yaml:"serviceAccounts"`
+	// AuthModes are the identity access modes: podIdentity, managedIdentity, workloadIdentity or servicePrincipal
+	AuthModes []string `json:"authModes" yaml:"authModes"`
+	// ClientIDs are the client IDs of the managed identities, workload identities or service principals.
+	// The system-assigned managed identity is matched by SystemAssignedIdentity.
+	ClientIDs []string `json:"clientIDs" yaml:"clientIDs"`
+	// Vaults are the host names of the vaults. A leading "*." matches the subdomains of the name.
+	Vaults []string `json:"vaults" yaml:"vaults"`
+}
+
+// Policy allows the mounts that match at least one of the rules. The other mounts are denied.
+type Policy struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Mount is the identity and the vault requested by a mount
+type Mount struct {
+	PodNamespace string
+	// ServiceAccount is the service account of the pod, empty if the driver didn't send it
+	ServiceAccount string
+	AuthMode       string
+	// ClientID is the client ID of the identity, empty for pod identity and the system-assigned managed identity
+	ClientID string
+	// Vault is the host name of the vault
+	Vault string
+}
+
+// FromFile loads the policy from a file on disk
+func FromFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s, error: %w", path, err)
+	}
+	return Parse(data)
+}
+
+// Parse parses the JSON or YAML representation of a policy. Unknown fields are rejected,
+// so a misspelled field doesn't silently allow more mounts.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to unmarshal policy, error: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate checks the rules of the policy
+func (p *Policy) Validate() error {
+	for i, rule := range p.Rules {
+		for _, mode := range rule.AuthModes {
+			if !auth.IsValidMode(mode) {
+				return fmt.Errorf("rule %d: auth mode %q is not valid, allowed values: %s, %s, %s, %s", i, mode, auth.ModePodIdentity, auth.ModeManagedIdentity, auth.ModeWorkloadIdentity, auth.ModeServicePrincipal)
+			}
+		}
+		for _, vault := range rule.Vaults {
+			if name := strings.TrimPrefix(vault, "*."); name == "" || strings.Contains(name, "*") {
+				return fmt.Errorf("rule %d: vault %q is not valid", i, vault)
+			}
+		}
+	}
+	return nil
+}
+
+// Allow returns an error if the mount isn't allowed by any of the rules
+func (p *Policy) Allow(m Mount) error {
+	for _, rule := range p.Rules {
+		if rule.matches(m) {
+			return nil
+		}
+	}
+	return fmt.Errorf("mount of vault %q with %s identity %q by service account %q in namespace %q is not allowed by the provider policy",
+		m.Vault, m.AuthMode, m.clientID(), m.ServiceAccount, m.PodNamespace)
+}
+
+// clientID returns the client ID of the identity matched by the rules
+func (m Mount) clientID() string {
+	if m.ClientID == "" && m.AuthMode == auth.ModeManagedIdentity {
+		return SystemAssignedIdentity
+	}
+	return m.ClientID
+}
+
+func (r Rule) matches(m Mount) bool {
+	return matchAny(r.Namespaces, m.PodNamespace, equal) &&
+		matchAny(r.ServiceAccounts, m.ServiceAccount, equal) &&
+		matchAny(r.AuthModes, m.AuthMode, equal) &&
+		matchAny(r.ClientIDs, m.clientID(), strings.EqualFold) &&
+		matchAny(r.Vaults, m.Vault, matchVault)
+}
+
+func equal(a, b string) bool {
+	return a == b
+}
+
+// matchAny returns true if the values aren't set or the value matches one of the values.
+// An empty value, e.g. the service account that isn't known, only matches the values that aren't set.
+func matchAny(values []string, value string, match func(pattern, value string) bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+	if value == "" {
+		return false
+	}
+	for _, v := range values {
+		if match(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchVault matches the host name of the vault with the exact name or the "*." wildcard
+func matchVault(pattern, vault string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return len(vault) > len(suffix) && strings.HasSuffix(strings.ToLower(vault), strings.ToLower(suffix))
+	}
+	return strings.EqualFold(pattern, vault)
+}
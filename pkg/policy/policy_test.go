@@ -0,0 +1,209 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		desc          string
+		data          string
+		expectedRules int
+		expectedErr   bool
+	}{
+		{
+			desc: "empty policy",
+			data: "",
+		},
+		{
+			desc: "yaml",
+			data: `
+rules:
+- namespaces: [team-a]
+  authModes: [workloadIdentity]
+  vaults: ["*.vault.azure.net"]
+- namespaces: [kube-system]
+  authModes: [managedIdentity]
+  clientIDs: [systemAssigned]`,
+			expectedRules: 2,
+		},
+		{
+			desc:          "json",
+			data:          `{"rules": [{"namespaces": ["team-a"], "clientIDs": ["clientid"]}]}`,
+			expectedRules: 1,
+		},
+		{
+			desc:        "unknown field",
+			data:        `{"rules": [{"namespace": ["team-a"]}]}`,
+			expectedErr: true,
+		},
+		{
+			desc:        "invalid auth mode",
+			data:        `{"rules": [{"authModes": ["vmManagedIdentity"]}]}`,
+			expectedErr: true,
+		},
+		{
+			desc:        "invalid vault wildcard",
+			data:        `{"rules": [{"vaults": ["kv*.vault.azure.net"]}]}`,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			p, err := Parse([]byte(tc.data))
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+			if err == nil && len(p.Rules) != tc.expectedRules {
+				t.Fatalf("expected %d rules, got %d", tc.expectedRules, len(p.Rules))
+			}
+		})
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if _, err := FromFile(path); err == nil {
+		t.Fatalf("FromFile() = nil, want error for missing file")
+	}
+	if err := os.WriteFile(path, []byte("rules:\n- namespaces: [team-a]\n"), 0600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	p, err := FromFile(path)
+	if err != nil {
+		t.Fatalf("FromFile() = %v, want nil", err)
+	}
+	if len(p.Rules) != 1 {
+		t.Fatalf("expected 1 rule, got %d", len(p.Rules))
+	}
+}
+
+func TestAllow(t *testing.T) {
+	p := &Policy{
+		Rules: []Rule{
+			{
+				Namespaces:      []string{"team-a"},
+				ServiceAccounts: []string{"app"},
+				AuthModes:       []string{"workloadIdentity"},
+				ClientIDs:       []string{"aaaaaaaa-1111-1111-1111-111111111111"},
+				Vaults:          []string{"*.team-a.vault.azure.net", "kv-a.vault.azure.net"},
+			},
+			{
+				Namespaces: []string{"kube-system"},
+				AuthModes:  []string{"managedIdentity"},
+				ClientIDs:  []string{SystemAssignedIdentity},
+			},
+		},
+	}
+	allowed := Mount{
+		PodNamespace:   "team-a",
+		ServiceAccount: "app",
+		AuthMode:       "workloadIdentity",
+		ClientID:       "aaaaaaaa-1111-1111-1111-111111111111",
+		Vault:          "kv-a.vault.azure.net",
+	}
+
+	cases := []struct {
+		desc        string
+		mount       func(m Mount) Mount
+		expectedErr bool
+	}{
+		{
+			desc:  "allowed",
+			mount: func(m Mount) Mount { return m },
+		},
+		{
+			desc: "client ID and vault are case-insensitive",
+			mount: func(m Mount) Mount {
+				m.ClientID = "AAAAAAAA-1111-1111-1111-111111111111"
+				m.Vault = "KV-A.vault.azure.net"
+				return m
+			},
+		},
+		{
+			desc: "vault wildcard",
+			mount: func(m Mount) Mount {
+				m.Vault = "kv1.team-a.vault.azure.net"
+				return m
+			},
+		},
+		{
+			desc: "vault wildcard doesn't match the parent domain",
+			mount: func(m Mount) Mount {
+				m.Vault = "team-a.vault.azure.net"
+				return m
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "other namespace",
+			mount: func(m Mount) Mount {
+				m.PodNamespace = "team-b"
+				return m
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "service account not known",
+			mount: func(m Mount) Mount {
+				m.ServiceAccount = ""
+				return m
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "other client ID",
+			mount: func(m Mount) Mount {
+				m.ClientID = "22222222-2222-2222-2222-222222222222"
+				return m
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "other vault",
+			mount: func(m Mount) Mount {
+				m.Vault = "kv-b.vault.azure.net"
+				return m
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "kubelet identity in the namespace",
+			mount: func(m Mount) Mount {
+				m.AuthMode = "managedIdentity"
+				m.ClientID = ""
+				return m
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "system-assigned identity",
+			mount: func(Mount) Mount {
+				return Mount{PodNamespace: "kube-system", AuthMode: "managedIdentity", Vault: "kv-b.vault.azure.net"}
+			},
+		},
+		{
+			desc: "user-assigned identity not allowed",
+			mount: func(Mount) Mount {
+				return Mount{PodNamespace: "kube-system", AuthMode: "managedIdentity", ClientID: "clientid", Vault: "kv-b.vault.azure.net"}
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := p.Allow(tc.mount(allowed))
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+
+	if err := (&Policy{}).Allow(allowed); err == nil {
+		t.Fatalf("expected the policy without rules to deny all the mounts")
+	}
+}
@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"golang.org/x/sync/singleflight"
+	"k8s.io/klog/v2"
+)
+
+// coalescingClient shares the key vault calls of the concurrent mounts that fetch the same
+// object version from the same vault with the same credential and transport, e.g. the pods of a Deployment
+// that scales up on a node. The content is converted for each mount from the shared bundles, so
+// the bundles must not be modified. The version lists are copied as they're sorted by the callers.
+type coalescingClient struct {
+	KeyVault
+	group *singleflight.Group
+	// prefix is the vault, the credential and the transport of the requests
+	prefix string
+}
+
+// newCoalescingClient returns the client that coalesces the calls with the same vault, credential
+// and transport. The transport key keeps the mounts that reach the vault through another proxy or
+// trust other certificates from receiving the content fetched for the other mounts.
+func newCoalescingClient(kvClient KeyVault, group *singleflight.Group, vaultURL, credentialKey, transportKey string) KeyVault {
+	return &coalescingClient{
+		KeyVault: kvClient,
+		group:    group,
+		prefix:   strings.Join([]string{vaultURL, credentialKey, transportKey}, "|"),
+	}
+}
+
+// GetSecret returns the secret bundle shared with the concurrent identical calls
+func (c *coalescingClient) GetSecret(ctx context.Context, name, version string) (*azsecrets.SecretBundle, error) {
+	v, err := c.do(ctx, types.VaultObjectTypeSecret, name, version, func(ctx context.Context) (interface{}, error) {
+		return c.KeyVault.GetSecret(ctx, name, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*azsecrets.SecretBundle), nil
+}
+
+// GetKey returns the key bundle shared with the concurrent identical calls
+func (c *coalescingClient) GetKey(ctx context.Context, name, version string) (*azkeys.KeyBundle, error) {
+	v, err := c.do(ctx, types.VaultObjectTypeKey, name, version, func(ctx context.Context) (interface{}, error) {
+		return c.KeyVault.GetKey(ctx, name, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*azkeys.KeyBundle), nil
+}
+
+// GetCertificate returns the certificate bundle shared with the concurrent identical calls
+func (c *coalescingClient) GetCertificate(ctx context.Context, name, version string) (*azcertificates.CertificateBundle, error) {
+	v, err := c.do(ctx, types.VaultObjectTypeCertificate, name, version, func(ctx context.Context) (interface{}, error) {
+		return c.KeyVault.GetCertificate(ctx, name, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*azcertificates.CertificateBundle), nil
+}
+
+// GetSecretVersions returns a copy of the secret versions shared with the concurrent identical calls
+func (c *coalescingClient) GetSecretVersions(ctx context.Context, name string) ([]types.KeyVaultObjectVersion, error) {
+	return c.versions(ctx, types.VaultObjectTypeSecret, name, c.KeyVault.GetSecretVersions)
+}
+
+// GetKeyVersions returns a copy of the key versions shared with the concurrent identical calls
+func (c *coalescingClient) GetKeyVersions(ctx context.Context, name string) ([]types.KeyVaultObjectVersion, error) {
+	return c.versions(ctx, types.VaultObjectTypeKey, name, c.KeyVault.GetKeyVersions)
+}
+
+// GetCertificateVersions returns a copy of the certificate versions shared with the concurrent identical calls
+func (c *coalescingClient) GetCertificateVersions(ctx context.Context, name string) ([]types.KeyVaultObjectVersion, error) {
+	return c.versions(ctx, types.VaultObjectTypeCertificate, name, c.KeyVault.GetCertificateVersions)
+}
+
+// versions lists the versions of the object with the shared call and returns a copy of the list
+func (c *coalescingClient) versions(ctx context.Context, objectType, name string, list func(ctx context.Context, name string) ([]types.KeyVaultObjectVersion, error)) ([]types.KeyVaultObjectVersion, error) {
+	v, err := c.do(ctx, objectType+"Versions", name, "", func(ctx context.Context) (interface{}, error) {
+		return list(ctx, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	versions := v.([]types.KeyVaultObjectVersion)
+	return append([]types.KeyVaultObjectVersion(nil), versions...), nil
+}
+
+// do runs the call once for the concurrent calls with the same key. The shared call isn't canceled
+// with the mount that started it but keeps its deadline, the other mounts stop waiting for the
+// call when they are canceled.
+func (c *coalescingClient) do(ctx context.Context, objectType, name, version string, call func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	key := fmt.Sprintf("%s|%s/%s/%s", c.prefix, objectType, name, version)
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		callCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithDeadline(callCtx, deadline)
+			defer cancel()
+		}
+		return call(callCtx)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Shared {
+			klog.V(5).InfoS("shared key vault call with concurrent mounts", "objectType", objectType, "objectName", name, "objectVersion", version)
+		}
+		return res.Val, res.Err
+	}
+}
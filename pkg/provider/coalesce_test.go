@@ -0,0 +1,183 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/Azure/go-autorest/autorest/to"
+	"golang.org/x/sync/singleflight"
+)
+
+// blockingKeyVault blocks the calls until they are released and counts the calls
+type blockingKeyVault struct {
+	KeyVault
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingKeyVault() *blockingKeyVault {
+	return &blockingKeyVault{
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+}
+
+func (k *blockingKeyVault) GetSecret(_ context.Context, name, version string) (*azsecrets.SecretBundle, error) {
+	k.calls.Add(1)
+	k.started <- struct{}{}
+	<-k.release
+	return &azsecrets.SecretBundle{Value: to.StringPtr(name + "/" + version)}, nil
+}
+
+func (k *blockingKeyVault) GetSecretVersions(_ context.Context, _ string) ([]types.KeyVaultObjectVersion, error) {
+	k.calls.Add(1)
+	k.started <- struct{}{}
+	<-k.release
+	return []types.KeyVaultObjectVersion{{Version: "v1"}, {Version: "v2"}}, nil
+}
+
+// waitStarted waits for the number of calls to be started
+func (k *blockingKeyVault) waitStarted(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-k.started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %d calls to start", n)
+		}
+	}
+}
+
+func TestCoalescingClientSharesCalls(t *testing.T) {
+	kvClient := newBlockingKeyVault()
+	group := &singleflight.Group{}
+	ctx := context.Background()
+
+	const mounts = 5
+	var wg sync.WaitGroup
+	secrets := make([]*azsecrets.SecretBundle, mounts)
+	versions := make([][]types.KeyVaultObjectVersion, mounts)
+	errs := make([]error, 2*mounts)
+	for i := 0; i < mounts; i++ {
+		// each mount creates its own client with the same vault and credential
+		c := newCoalescingClient(kvClient, group, "https://test.vault.azure.net/", "managedIdentity/clientid", "")
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			secrets[i], errs[i] = c.GetSecret(ctx, "secret1", "")
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			versions[i], errs[mounts+i] = c.GetSecretVersions(ctx, "secret1")
+		}(i)
+	}
+	// the first secret and versions calls are in flight, the other mounts join the calls
+	kvClient.waitStarted(t, 2)
+	time.Sleep(100 * time.Millisecond)
+	close(kvClient.release)
+	wg.Wait()
+
+	if calls := kvClient.calls.Load(); calls != 2 {
+		t.Fatalf("expected 2 key vault calls, got %d", calls)
+	}
+	for i := 0; i < mounts; i++ {
+		if errs[i] != nil || errs[mounts+i] != nil {
+			t.Fatalf("unexpected errors: %v, %v", errs[i], errs[mounts+i])
+		}
+		if *secrets[i].Value != "secret1/" {
+			t.Fatalf("expected secret value secret1/, got %s", *secrets[i].Value)
+		}
+		if len(versions[i]) != 2 {
+			t.Fatalf("expected 2 versions, got %v", versions[i])
+		}
+	}
+	// the version lists are sorted by the callers, so each mount gets its own copy
+	versions[0][0] = types.KeyVaultObjectVersion{Version: "modified"}
+	if versions[1][0].Version != "v1" {
+		t.Fatalf("expected the versions of the mounts to be copied, got %v", versions[1])
+	}
+}
+
+func TestCoalescingClientDifferentKeys(t *testing.T) {
+	kvClient := newBlockingKeyVault()
+	group := &singleflight.Group{}
+	ctx := context.Background()
+
+	calls := []struct {
+		credentialKey string
+		transport     ClientOptions
+		version       string
+	}{
+		{credentialKey: "workloadIdentity/tid/clientid/default/sa1"},
+		// the same identity federated with another service account isn't shared
+		{credentialKey: "workloadIdentity/tid/clientid/default/sa2"},
+		// another version of the object isn't shared
+		{credentialKey: "workloadIdentity/tid/clientid/default/sa1", version: "v1"},
+		// the requests through another proxy or trusting other certificates aren't shared
+		{credentialKey: "workloadIdentity/tid/clientid/default/sa1", transport: ClientOptions{ProxyURL: "http://proxy.tenant-a:3128"}},
+		{credentialKey: "workloadIdentity/tid/clientid/default/sa1", transport: ClientOptions{CABundle: "ca-tenant-a"}},
+		{credentialKey: "workloadIdentity/tid/clientid/default/sa1", transport: ClientOptions{CABundle: "ca-tenant-b"}},
+		{credentialKey: "workloadIdentity/tid/clientid/default/sa1", transport: ClientOptions{TLSServerName: "kv.tenant-a.internal"}},
+		{credentialKey: "workloadIdentity/tid/clientid/default/sa1", transport: ClientOptions{DisableChallengeResourceVerification: true}},
+	}
+	var wg sync.WaitGroup
+	for _, call := range calls {
+		c := newCoalescingClient(kvClient, group, "https://test.vault.azure.net/", call.credentialKey, call.transport.transportKey())
+		wg.Add(1)
+		go func(version string) {
+			defer wg.Done()
+			if _, err := c.GetSecret(ctx, "secret1", version); err != nil {
+				t.Errorf("GetSecret() = %v, want nil", err)
+			}
+		}(call.version)
+	}
+	kvClient.waitStarted(t, len(calls))
+	close(kvClient.release)
+	wg.Wait()
+
+	if got := kvClient.calls.Load(); got != int32(len(calls)) {
+		t.Fatalf("expected %d key vault calls, got %d", len(calls), got)
+	}
+}
+
+func TestCoalescingClientCanceledMount(t *testing.T) {
+	kvClient := newBlockingKeyVault()
+	group := &singleflight.Group{}
+	c := newCoalescingClient(kvClient, group, "https://test.vault.azure.net/", "managedIdentity/clientid", "")
+
+	// the mount that started the call is canceled, the call is still shared with the other mount
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, err := c.GetSecret(ctx, "secret1", "")
+		canceled <- err
+	}()
+	kvClient.waitStarted(t, 1)
+
+	done := make(chan error)
+	go func() {
+		_, err := c.GetSecret(context.Background(), "secret1", "")
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetSecret() = %v, want %v", err, context.Canceled)
+	}
+	close(kvClient.release)
+	if err := <-done; err != nil {
+		t.Fatalf("GetSecret() = %v, want nil", err)
+	}
+	if calls := kvClient.calls.Load(); calls != 1 {
+		t.Fatalf("expected 1 key vault call, got %d", calls)
+	}
+}
@@ -0,0 +1,260 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/tracing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// ErrorCode classifies the errors returned by the provider so callers can
+// tell a misconfiguration apart from a Key Vault or Azure AD outage.
+type ErrorCode string
+
+const (
+	// ErrorCodeUnknown is used for errors that couldn't be classified
+	ErrorCodeUnknown ErrorCode = "Unknown"
+	// ErrorCodeInvalidConfig is used when the SecretProviderClass parameters or
+	// the mount attributes are invalid
+	ErrorCodeInvalidConfig ErrorCode = "InvalidConfig"
+	// ErrorCodeAuthFailed is used when the identity couldn't authenticate with Azure AD
+	ErrorCodeAuthFailed ErrorCode = "AuthFailed"
+	// ErrorCodePermissionDenied is used when the identity isn't authorized to access the object
+	ErrorCodePermissionDenied ErrorCode = "PermissionDenied"
+	// ErrorCodeNotFound is used when the vault or the object doesn't exist
+	ErrorCodeNotFound ErrorCode = "NotFound"
+	// ErrorCodeThrottled is used when the request was throttled by Key Vault or Azure AD
+	ErrorCodeThrottled ErrorCode = "Throttled"
+	// ErrorCodeUnavailable is used when Key Vault or Azure AD can't be reached or
+	// returned a server error
+	ErrorCodeUnavailable ErrorCode = "Unavailable"
+)
+
+// keyVaultErrorCodeForbiddenByFirewall is the inner error code returned by key vault when the
+// network rules of the vault deny the request
+const keyVaultErrorCodeForbiddenByFirewall = "ForbiddenByFirewall"
+
+// Error is the typed error returned by the provider
+type Error struct {
+	// Code is the classification of the error
+	Code ErrorCode
+	// Err is the underlying error
+	Err error
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return string(e.Code)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError returns a typed error with the given code. If err already carries a code, the
+// existing classification is kept as it was determined closer to the source of the error.
+func newError(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	var typedErr *Error
+	if errors.As(err, &typedErr) {
+		return err
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// invalidConfigError returns a typed error for an invalid mount configuration
+func invalidConfigError(err error) error {
+	return newError(ErrorCodeInvalidConfig, err)
+}
+
+// ErrorCodeOf returns the classification of the error. Errors that are not typed
+// are classified using the Azure response error or the context error in the chain.
+func ErrorCodeOf(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+	var typedErr *Error
+	if errors.As(err, &typedErr) {
+		return typedErr.Code
+	}
+	return classifyError(err)
+}
+
+// IsTransient returns true if the error is expected to go away on retry
+func IsTransient(err error) bool {
+	switch ErrorCodeOf(err) {
+	case ErrorCodeThrottled, ErrorCodeUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// classifyError determines the error code from the Azure SDK errors in the chain
+func classifyError(err error) ErrorCode {
+	var authErr *azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) {
+		// the token request didn't get a response, e.g. Azure AD or IMDS can't be reached. The
+		// transport error isn't wrapped by azidentity, so it can't be classified further.
+		if authErr.RawResponse == nil {
+			return ErrorCodeUnavailable
+		}
+		if code := codeFromStatus(authErr.RawResponse.StatusCode); code == ErrorCodeThrottled || code == ErrorCodeUnavailable {
+			return code
+		}
+		return ErrorCodeAuthFailed
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return codeFromStatus(respErr.StatusCode)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCodeUnavailable
+	}
+	// the connection to key vault or azure AD failed before a response was received, e.g. the
+	// host didn't resolve, the connection was refused or timed out or the TLS handshake failed.
+	// A host that doesn't resolve isn't reported as NotFound as the DNS server may be degraded.
+	// *url.Error returned by the http client, *net.DNSError and the syscall errors like
+	// ECONNREFUSED implement net.Error.
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &netErr) || errors.As(err, &urlErr) || errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorCodeUnavailable
+	}
+	return ErrorCodeUnknown
+}
+
+// isVaultFailure returns true if the error fails the requests for all the objects of the vault with
+// the identity and is expected to happen again on retry, e.g. Azure AD rejected the token request
+// or the network rules of the vault deny the requests. The errors for a single object, e.g. an
+// object that doesn't exist or an access denied to an object, and the transient errors aren't
+// vault failures, so the mounts of one workload can't open the circuit for the other workloads
+// that share the identity.
+func isVaultFailure(err error) bool {
+	var authErr *azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) {
+		if authErr.RawResponse == nil {
+			return false
+		}
+		switch authErr.RawResponse.StatusCode {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+			return true
+		default:
+			return false
+		}
+	}
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	switch respErr.StatusCode {
+	case http.StatusUnauthorized:
+		// key vault rejected the token, e.g. it was issued by another tenant
+		return true
+	case http.StatusForbidden:
+		// the access policies and role assignments may allow some objects of the vault and not
+		// others, only the requests denied by the network rules are denied for the whole vault
+		return innerErrorCode(respErr) == keyVaultErrorCodeForbiddenByFirewall
+	default:
+		return false
+	}
+}
+
+// innerErrorCode returns the inner error code of the key vault error response, e.g.
+// ForbiddenByPolicy or ForbiddenByFirewall for the Forbidden error code
+func innerErrorCode(respErr *azcore.ResponseError) string {
+	if respErr.RawResponse == nil {
+		return ""
+	}
+	// the body is buffered by the sdk when the response error is created, so it can be read again
+	body, err := runtime.Payload(respErr.RawResponse)
+	if err != nil {
+		return ""
+	}
+	var resp struct {
+		Error struct {
+			InnerError struct {
+				Code string `json:"code"`
+			} `json:"innererror"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return ""
+	}
+	return resp.Error.InnerError.Code
+}
+
+// codeFromStatus maps the HTTP status code returned by Key Vault or Azure AD to an error code
+func codeFromStatus(statusCode int) ErrorCode {
+	switch {
+	case statusCode == http.StatusBadRequest:
+		return ErrorCodeInvalidConfig
+	case statusCode == http.StatusUnauthorized:
+		return ErrorCodeAuthFailed
+	case statusCode == http.StatusForbidden:
+		return ErrorCodePermissionDenied
+	case statusCode == http.StatusNotFound:
+		return ErrorCodeNotFound
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorCodeThrottled
+	case statusCode == http.StatusRequestTimeout || statusCode >= http.StatusInternalServerError:
+		return ErrorCodeUnavailable
+	default:
+		return ErrorCodeUnknown
+	}
+}
+
+// credential wraps the token credential used by the key vault client so token
+// acquisition failures are reported as typed errors instead of key vault errors
+type credential struct {
+	azcore.TokenCredential
+	// mode is the identity access mode of the credential reported with the token requests
+	mode     string
+	reporter metrics.StatsReporter
+}
+
+// GetToken requests an access token from the wrapped credential
+func (c *credential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (_ azcore.AccessToken, err error) {
+	ctx, span := tracing.StartSpan(ctx, "GetToken")
+	defer func() { tracing.EndSpan(span, err) }()
+	start := time.Now()
+	defer func() {
+		if c.reporter == nil {
+			return
+		}
+		var errorCode string
+		if err != nil {
+			errorCode = string(ErrorCodeOf(err))
+		}
+		c.reporter.ReportTokenRequest(ctx, time.Since(start).Seconds(), c.mode, errorCode)
+	}()
+
+	token, err := c.TokenCredential.GetToken(ctx, opts)
+	if err != nil {
+		code := classifyError(err)
+		if code != ErrorCodeThrottled && code != ErrorCodeUnavailable {
+			code = ErrorCodeAuthFailed
+		}
+		span.SetAttributes(tracing.ErrorCodeKey.String(string(code)))
+		return token, newError(code, err)
+	}
+	return token, nil
+}
@@ -0,0 +1,406 @@
+package provider
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	pkgerrors "github.com/pkg/errors"
+)
+
+type fakeCredential struct {
+	err error
+}
+
+func (c *fakeCredential) GetToken(_ context.Context, _ policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{}, c.err
+}
+
+// errTimeout is a net.Error that timed out
+type errTimeout struct{}
+
+func (errTimeout) Error() string   { return "i/o timeout" }
+func (errTimeout) Timeout() bool   { return true }
+func (errTimeout) Temporary() bool { return true }
+
+func TestErrorCodeOf(t *testing.T) {
+	cases := []struct {
+		desc         string
+		err          error
+		expectedCode ErrorCode
+	}{
+		{
+			desc:         "nil error",
+			err:          nil,
+			expectedCode: "",
+		},
+		{
+			desc:         "typed error",
+			err:          &Error{Code: ErrorCodeInvalidConfig, Err: errors.New("objects is not set")},
+			expectedCode: ErrorCodeInvalidConfig,
+		},
+		{
+			desc:         "typed error wrapped with pkg/errors",
+			err:          pkgerrors.Wrap(&Error{Code: ErrorCodeAuthFailed, Err: errors.New("failed")}, "failed to get objectType:secret"),
+			expectedCode: ErrorCodeAuthFailed,
+		},
+		{
+			desc:         "bad request",
+			err:          &azcore.ResponseError{StatusCode: http.StatusBadRequest},
+			expectedCode: ErrorCodeInvalidConfig,
+		},
+		{
+			desc:         "unauthorized",
+			err:          &azcore.ResponseError{StatusCode: http.StatusUnauthorized},
+			expectedCode: ErrorCodeAuthFailed,
+		},
+		{
+			desc:         "forbidden",
+			err:          wrapObjectTypeError(&azcore.ResponseError{StatusCode: http.StatusForbidden}, "secret", "secret1", ""),
+			expectedCode: ErrorCodePermissionDenied,
+		},
+		{
+			desc:         "not found",
+			err:          wrapObjectTypeError(&azcore.ResponseError{StatusCode: http.StatusNotFound}, "secret", "secret1", ""),
+			expectedCode: ErrorCodeNotFound,
+		},
+		{
+			desc:         "too many requests",
+			err:          &azcore.ResponseError{StatusCode: http.StatusTooManyRequests},
+			expectedCode: ErrorCodeThrottled,
+		},
+		{
+			desc:         "internal server error",
+			err:          &azcore.ResponseError{StatusCode: http.StatusInternalServerError},
+			expectedCode: ErrorCodeUnavailable,
+		},
+		{
+			desc:         "context deadline exceeded",
+			err:          fmt.Errorf("request failed: %w", context.DeadlineExceeded),
+			expectedCode: ErrorCodeUnavailable,
+		},
+		{
+			desc:         "vault host not found",
+			err:          fmt.Errorf("request failed: %w", &net.DNSError{Err: "no such host", Name: "test.vault.azure.net", IsNotFound: true}),
+			expectedCode: ErrorCodeUnavailable,
+		},
+		{
+			desc:         "connection refused",
+			err:          &url.Error{Op: "Get", URL: "https://test.vault.azure.net/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}},
+			expectedCode: ErrorCodeUnavailable,
+		},
+		{
+			desc:         "connection refused without url error",
+			err:          fmt.Errorf("request failed: %w", syscall.ECONNREFUSED),
+			expectedCode: ErrorCodeUnavailable,
+		},
+		{
+			desc:         "i/o timeout",
+			err:          fmt.Errorf("request failed: %w", &net.OpError{Op: "read", Net: "tcp", Err: errTimeout{}}),
+			expectedCode: ErrorCodeUnavailable,
+		},
+		{
+			desc:         "tls handshake failure",
+			err:          &url.Error{Op: "Get", URL: "https://test.vault.azure.net/", Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}},
+			expectedCode: ErrorCodeUnavailable,
+		},
+		{
+			desc:         "token request without response",
+			err:          &azidentity.AuthenticationFailedError{},
+			expectedCode: ErrorCodeUnavailable,
+		},
+		{
+			desc:         "token request rejected",
+			err:          &azidentity.AuthenticationFailedError{RawResponse: &http.Response{StatusCode: http.StatusUnauthorized}},
+			expectedCode: ErrorCodeAuthFailed,
+		},
+		{
+			desc:         "unclassified error",
+			err:          errors.New("secret value is nil"),
+			expectedCode: ErrorCodeUnknown,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if code := ErrorCodeOf(tc.err); code != tc.expectedCode {
+				t.Fatalf("expected code: %v, got: %v", tc.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestNewErrorKeepsExistingCode(t *testing.T) {
+	err := newError(ErrorCodeAuthFailed, errors.New("failed to get token"))
+	err = invalidConfigError(pkgerrors.Wrap(err, "failed to get keyvault client"))
+	if code := ErrorCodeOf(err); code != ErrorCodeAuthFailed {
+		t.Fatalf("expected code: %v, got: %v", ErrorCodeAuthFailed, code)
+	}
+	if newError(ErrorCodeUnknown, nil) != nil {
+		t.Fatalf("expected nil error")
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		desc     string
+		err      error
+		expected bool
+	}{
+		{
+			desc:     "throttled",
+			err:      &azcore.ResponseError{StatusCode: http.StatusTooManyRequests},
+			expected: true,
+		},
+		{
+			desc:     "unavailable",
+			err:      &Error{Code: ErrorCodeUnavailable, Err: errors.New("connection refused")},
+			expected: true,
+		},
+		{
+			desc:     "not found",
+			err:      &azcore.ResponseError{StatusCode: http.StatusNotFound},
+			expected: false,
+		},
+		{
+			desc:     "invalid config",
+			err:      &Error{Code: ErrorCodeInvalidConfig, Err: errors.New("objects is not set")},
+			expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := IsTransient(tc.err); got != tc.expected {
+				t.Fatalf("expected transient: %v, got: %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+// newForbiddenError returns the error returned by key vault for a request denied with the inner error code
+func newForbiddenError(t *testing.T, innerErrorCode string) error {
+	t.Helper()
+	body := fmt.Sprintf(`{"error":{"code":"Forbidden","message":"access denied","innererror":{"code":%q}}}`, innerErrorCode)
+	req, err := http.NewRequest(http.MethodGet, "https://test.vault.azure.net/secrets/secret1/", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	return runtime.NewResponseError(&http.Response{
+		StatusCode: http.StatusForbidden,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	})
+}
+
+func TestIsVaultFailure(t *testing.T) {
+	cases := []struct {
+		desc     string
+		err      error
+		expected bool
+	}{
+		{
+			desc:     "denied by the network rules of the vault",
+			err:      wrapObjectTypeError(newForbiddenError(t, "ForbiddenByFirewall"), "secret", "secret1", ""),
+			expected: true,
+		},
+		{
+			desc:     "object denied by the access policy",
+			err:      wrapObjectTypeError(newForbiddenError(t, "ForbiddenByPolicy"), "secret", "secret1", ""),
+			expected: false,
+		},
+		{
+			desc:     "object denied by the role assignments",
+			err:      wrapObjectTypeError(newForbiddenError(t, "ForbiddenByRbac"), "secret", "secret1", ""),
+			expected: false,
+		},
+		{
+			desc:     "forbidden without response",
+			err:      &azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "Forbidden"},
+			expected: false,
+		},
+		{
+			desc:     "token rejected by key vault",
+			err:      &azcore.ResponseError{StatusCode: http.StatusUnauthorized, ErrorCode: "Unauthorized"},
+			expected: true,
+		},
+		{
+			desc:     "token request rejected",
+			err:      newError(ErrorCodeAuthFailed, &azidentity.AuthenticationFailedError{RawResponse: &http.Response{StatusCode: http.StatusBadRequest}}),
+			expected: true,
+		},
+		{
+			desc:     "token request unauthorized",
+			err:      newError(ErrorCodeAuthFailed, &azidentity.AuthenticationFailedError{RawResponse: &http.Response{StatusCode: http.StatusUnauthorized}}),
+			expected: true,
+		},
+		{
+			desc:     "token endpoint unavailable",
+			err:      newError(ErrorCodeUnavailable, &azidentity.AuthenticationFailedError{RawResponse: &http.Response{StatusCode: http.StatusServiceUnavailable}}),
+			expected: false,
+		},
+		{
+			desc:     "token endpoint unreachable",
+			err:      newError(ErrorCodeUnavailable, &azidentity.AuthenticationFailedError{}),
+			expected: false,
+		},
+		{
+			desc:     "token request failed without response",
+			err:      &Error{Code: ErrorCodeAuthFailed, Err: errors.New("nmi response failed with status code: 403")},
+			expected: false,
+		},
+		{
+			desc:     "vault host not found",
+			err:      &net.DNSError{Err: "no such host", Name: "test.vault.azure.net", IsNotFound: true},
+			expected: false,
+		},
+		{
+			desc:     "object not found",
+			err:      wrapObjectTypeError(&azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "SecretNotFound"}, "secret", "secret1", ""),
+			expected: false,
+		},
+		{
+			desc:     "unavailable",
+			err:      &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable},
+			expected: false,
+		},
+		{
+			desc:     "invalid config",
+			err:      &Error{Code: ErrorCodeInvalidConfig, Err: errors.New("objects is not set")},
+			expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := isVaultFailure(tc.err); got != tc.expected {
+				t.Fatalf("expected vault failure: %v, got: %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestCredentialError(t *testing.T) {
+	cases := []struct {
+		desc         string
+		err          error
+		expectedCode ErrorCode
+	}{
+		{
+			desc:         "token request failed",
+			err:          errors.New("nmi response failed with status code: 403"),
+			expectedCode: ErrorCodeAuthFailed,
+		},
+		{
+			desc:         "token endpoint throttled",
+			err:          &azcore.ResponseError{StatusCode: http.StatusTooManyRequests},
+			expectedCode: ErrorCodeThrottled,
+		},
+		{
+			desc:         "token endpoint unavailable",
+			err:          &azcore.ResponseError{StatusCode: http.StatusBadGateway},
+			expectedCode: ErrorCodeUnavailable,
+		},
+		{
+			desc:         "token endpoint unreachable",
+			err:          fmt.Errorf("nmi request failed: %w", &url.Error{Op: "Get", URL: "http://127.0.0.1:2579/host/token/", Err: syscall.ECONNREFUSED}),
+			expectedCode: ErrorCodeUnavailable,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cred := &credential{TokenCredential: &fakeCredential{err: tc.err}}
+			_, err := cred.GetToken(context.TODO(), policy.TokenRequestOptions{})
+			if code := ErrorCodeOf(err); code != tc.expectedCode {
+				t.Fatalf("expected code: %v, got: %v", tc.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestKeyVaultClientErrorCode(t *testing.T) {
+	cases := []struct {
+		desc         string
+		tokenErr     error
+		statusCode   int
+		expectedCode ErrorCode
+	}{
+		{
+			desc:         "token acquisition failed",
+			tokenErr:     errors.New("failed to get token"),
+			expectedCode: ErrorCodeAuthFailed,
+		},
+		{
+			desc:         "secret not found",
+			statusCode:   http.StatusNotFound,
+			expectedCode: ErrorCodeNotFound,
+		},
+		{
+			desc:         "access denied",
+			statusCode:   http.StatusForbidden,
+			expectedCode: ErrorCodePermissionDenied,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") == "" {
+					w.Header().Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tid", resource="https://vault.azure.net"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(tc.statusCode)
+				_, _ = w.Write([]byte(`{"error":{"code":"Error","message":"error"}}`))
+			}))
+			defer server.Close()
+
+			caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+			cred := &credential{TokenCredential: &fakeCredential{err: tc.tokenErr}}
+			kvClient, err := NewClient(cred, server.URL, &ClientOptions{
+				CABundle:                             string(caBundle),
+				DisableChallengeResourceVerification: true,
+			})
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+
+			_, err = kvClient.GetSecret(context.TODO(), "secret1", "")
+			if code := ErrorCodeOf(err); code != tc.expectedCode {
+				t.Fatalf("expected code: %v, got: %v (error: %v)", tc.expectedCode, code, err)
+			}
+		})
+	}
+}
+
+func TestTransportErrorCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	// the connection is refused once the server is closed
+	server.Close()
+
+	_, err := server.Client().Get(server.URL) // #nosec G107
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if code := ErrorCodeOf(fmt.Errorf("failed to get secret: %w", err)); code != ErrorCodeUnavailable {
+		t.Fatalf("expected code: %v, got: %v (error: %v)", ErrorCodeUnavailable, code, err)
+	}
+}
@@ -2,13 +2,25 @@ package provider
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
 	"github.com/Azure/go-autorest/autorest/date"
 
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
 )
 
@@ -28,17 +40,58 @@ type client struct {
 	certs   *azcertificates.Client
 }
 
+// ClientOptions holds the optional transport configuration for the KeyVault client
+type ClientOptions struct {
+	// ProxyURL is the HTTP(S) proxy used to reach Key Vault
+	ProxyURL string
+	// CABundle is a PEM encoded bundle of CA certificates trusted in addition to the system roots
+	CABundle string
+	// TLSServerName overrides the server name used for SNI and certificate verification
+	TLSServerName string
+	// DisableChallengeResourceVerification disables the check that the authentication challenge
+	// resource matches the vault domain. This is required when the vault is reached through a
+	// custom DNS name or an IP address.
+	DisableChallengeResourceVerification bool
+	// Reporter reports the HTTP requests sent to the vault. The requests aren't reported if not set.
+	Reporter metrics.StatsReporter
+}
+
 // NewClient creates a new KeyVault client
-func NewClient(cred azcore.TokenCredential, vaultURI string) (KeyVault, error) {
-	secrets, err := azsecrets.NewClient(vaultURI, cred, nil)
+func NewClient(cred azcore.TokenCredential, vaultURI string, opts *ClientOptions) (KeyVault, error) {
+	if opts == nil {
+		opts = &ClientOptions{}
+	}
+	clientOpts := azcore.ClientOptions{}
+	transport, err := newTransport(opts)
 	if err != nil {
 		return nil, err
 	}
-	keys, err := azkeys.NewClient(vaultURI, cred, nil)
+	if transport != nil {
+		clientOpts.Transport = &http.Client{Transport: transport}
+	}
+	if opts.Reporter != nil {
+		// the policy runs for every retry so all the requests sent to the vault are counted
+		clientOpts.PerRetryPolicies = append(clientOpts.PerRetryPolicies, &vaultRequestPolicy{reporter: opts.Reporter})
+	}
+
+	secrets, err := azsecrets.NewClient(vaultURI, cred, &azsecrets.ClientOptions{
+		ClientOptions:                        clientOpts,
+		DisableChallengeResourceVerification: opts.DisableChallengeResourceVerification,
+	})
 	if err != nil {
 		return nil, err
 	}
-	certs, err := azcertificates.NewClient(vaultURI, cred, nil)
+	keys, err := azkeys.NewClient(vaultURI, cred, &azkeys.ClientOptions{
+		ClientOptions:                        clientOpts,
+		DisableChallengeResourceVerification: opts.DisableChallengeResourceVerification,
+	})
+	if err != nil {
+		return nil, err
+	}
+	certs, err := azcertificates.NewClient(vaultURI, cred, &azcertificates.ClientOptions{
+		ClientOptions:                        clientOpts,
+		DisableChallengeResourceVerification: opts.DisableChallengeResourceVerification,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -50,6 +103,98 @@ func NewClient(cred azcore.TokenCredential, vaultURI string) (KeyVault, error) {
 	}, nil
 }
 
+// vaultRequestPolicy reports the HTTP requests sent to the vault with the status code of the response
+type vaultRequestPolicy struct {
+	reporter metrics.StatsReporter
+}
+
+// Do sends the request and reports it with the host name of the vault
+func (p *vaultRequestPolicy) Do(req *policy.Request) (*http.Response, error) {
+	resp, err := req.Next()
+	var statusCode string
+	if resp != nil {
+		statusCode = strconv.Itoa(resp.StatusCode)
+	}
+	p.reporter.ReportVaultRequest(req.Raw().Context(), req.Raw().URL.Hostname(), statusCode)
+	return resp, err
+}
+
+// transportKey returns the key of the transport configuration. The requests with the same key
+// reach the same vault through the same proxy and trust the same certificates. The CA bundle is
+// hashed to keep the key short.
+func (opts *ClientOptions) transportKey() string {
+	var caBundle string
+	if opts.CABundle != "" {
+		hash := sha256.Sum256([]byte(opts.CABundle))
+		caBundle = hex.EncodeToString(hash[:])
+	}
+	return strings.Join([]string{
+		opts.ProxyURL,
+		caBundle,
+		opts.TLSServerName,
+		strconv.FormatBool(opts.DisableChallengeResourceVerification),
+	}, "|")
+}
+
+// newTransport returns the http transport to use for the Key Vault requests.
+// nil is returned if no proxy or TLS customization is configured so that the
+// default Azure SDK transport is used.
+func newTransport(opts *ClientOptions) (*http.Transport, error) {
+	if opts.ProxyURL == "" && opts.CABundle == "" && opts.TLSServerName == "" {
+		return nil, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.ProxyURL != "" {
+		proxyURL, err := parseProxyURL(opts.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: opts.TLSServerName,
+	}
+	if opts.CABundle != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if err = appendCABundle(rootCAs, opts.CABundle); err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
+// parseProxyURL parses the proxy URL, which must be an http or https URL with a host
+func parseProxyURL(proxy string) (*url.URL, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy url, error: %w", err)
+	}
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid proxy url scheme %q, should be http or https", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("proxy url %q does not contain a host", proxy)
+	}
+	return proxyURL, nil
+}
+
+// appendCABundle adds the PEM encoded certificates of the CA bundle to the pool
+func appendCABundle(pool *x509.CertPool, caBundle string) error {
+	if !pool.AppendCertsFromPEM([]byte(caBundle)) {
+		return fmt.Errorf("failed to parse CA bundle, no valid PEM encoded certificates found")
+	}
+	return nil
+}
+
 func (c *client) GetSecret(ctx context.Context, name, version string) (*azsecrets.SecretBundle, error) {
 	resp, err := c.secrets.GetSecret(ctx, name, version, &azsecrets.GetSecretOptions{})
 	if err != nil {
@@ -87,20 +232,10 @@ func (c *client) GetSecretVersions(ctx context.Context, name string) ([]types.Ke
 			if secret.Attributes == nil {
 				continue
 			}
-			if secret.Attributes.Enabled != nil && !*secret.Attributes.Enabled {
-				continue
-			}
 
 			id := *secret.ID
-			created := date.UnixEpoch()
-			if secret.Attributes.Created != nil {
-				created = *secret.Attributes.Created
-			}
-
-			versions = append(versions, types.KeyVaultObjectVersion{
-				Version: id.Version(),
-				Created: created,
-			})
+			a := secret.Attributes
+			versions = append(versions, newKeyVaultObjectVersion(id.Version(), a.Enabled, a.Created, a.NotBefore, a.Expires, secret.Tags))
 		}
 	}
 
@@ -120,20 +255,10 @@ func (c *client) GetKeyVersions(ctx context.Context, name string) ([]types.KeyVa
 			if key.Attributes == nil {
 				continue
 			}
-			if key.Attributes.Enabled != nil && !*key.Attributes.Enabled {
-				continue
-			}
 
 			id := *key.KID
-			created := date.UnixEpoch()
-			if key.Attributes.Created != nil {
-				created = *key.Attributes.Created
-			}
-
-			versions = append(versions, types.KeyVaultObjectVersion{
-				Version: id.Version(),
-				Created: created,
-			})
+			a := key.Attributes
+			versions = append(versions, newKeyVaultObjectVersion(id.Version(), a.Enabled, a.Created, a.NotBefore, a.Expires, key.Tags))
 		}
 	}
 
@@ -153,22 +278,30 @@ func (c *client) GetCertificateVersions(ctx context.Context, name string) ([]typ
 			if cert.Attributes == nil {
 				continue
 			}
-			if cert.Attributes.Enabled != nil && !*cert.Attributes.Enabled {
-				continue
-			}
 
 			id := *cert.ID
-			created := date.UnixEpoch()
-			if cert.Attributes.Created != nil {
-				created = *cert.Attributes.Created
-			}
-
-			versions = append(versions, types.KeyVaultObjectVersion{
-				Version: id.Version(),
-				Created: created,
-			})
+			a := cert.Attributes
+			versions = append(versions, newKeyVaultObjectVersion(id.Version(), a.Enabled, a.Created, a.NotBefore, a.Expires, cert.Tags))
 		}
 	}
 
 	return versions, nil
 }
+
+// newKeyVaultObjectVersion returns the version with the attributes returned by key vault. The
+// version is enabled if the attribute isn't set, and created at the unix epoch if the creation
+// time isn't set.
+func newKeyVaultObjectVersion(version string, enabled *bool, created, notBefore, expires *time.Time, tags map[string]*string) types.KeyVaultObjectVersion {
+	v := types.KeyVaultObjectVersion{
+		Version:   version,
+		Enabled:   enabled == nil || *enabled,
+		Created:   date.UnixEpoch(),
+		NotBefore: notBefore,
+		Expires:   expires,
+	}
+	if created != nil {
+		v.Created = *created
+	}
+	v.Tags = toStringMap(tags)
+	return v
+}
@@ -0,0 +1,195 @@
+package provider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+func TestNewTransport(t *testing.T) {
+	cases := []struct {
+		desc              string
+		opts              *ClientOptions
+		expectedNil       bool
+		expectedErr       bool
+		expectedProxy     string
+		expectedRootCAs   bool
+		expectedSNIServer string
+	}{
+		{
+			desc:        "no customization uses the default transport",
+			opts:        &ClientOptions{},
+			expectedNil: true,
+		},
+		{
+			desc:        "invalid proxy scheme",
+			opts:        &ClientOptions{ProxyURL: "socks5://proxy:1080"},
+			expectedErr: true,
+		},
+		{
+			desc:        "proxy without host",
+			opts:        &ClientOptions{ProxyURL: "http://"},
+			expectedErr: true,
+		},
+		{
+			desc:        "invalid CA bundle",
+			opts:        &ClientOptions{CABundle: "invalid"},
+			expectedErr: true,
+		},
+		{
+			desc:          "proxy url",
+			opts:          &ClientOptions{ProxyURL: "http://proxy:3128"},
+			expectedProxy: "http://proxy:3128",
+		},
+		{
+			desc:              "CA bundle and TLS server name",
+			opts:              &ClientOptions{CABundle: testCACert(t), TLSServerName: "testkv.vault.azure.net"},
+			expectedRootCAs:   true,
+			expectedSNIServer: "testkv.vault.azure.net",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			transport, err := newTransport(tc.opts)
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				return
+			}
+			if tc.expectedNil {
+				if transport != nil {
+					t.Fatalf("expected nil transport, got: %+v", transport)
+				}
+				return
+			}
+			if transport.TLSClientConfig == nil || transport.TLSClientConfig.MinVersion != tls.VersionTLS12 {
+				t.Fatalf("expected TLS config with min version TLS 1.2, got: %+v", transport.TLSClientConfig)
+			}
+			if transport.TLSClientConfig.ServerName != tc.expectedSNIServer {
+				t.Fatalf("expected server name: %s, got: %s", tc.expectedSNIServer, transport.TLSClientConfig.ServerName)
+			}
+			if (transport.TLSClientConfig.RootCAs != nil) != tc.expectedRootCAs {
+				t.Fatalf("expected root CAs set: %v, got: %v", tc.expectedRootCAs, transport.TLSClientConfig.RootCAs != nil)
+			}
+			if tc.expectedProxy != "" {
+				req := &http.Request{URL: &url.URL{Scheme: "https", Host: "testkv.vault.azure.net"}}
+				proxyURL, err := transport.Proxy(req)
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				if proxyURL.String() != tc.expectedProxy {
+					t.Fatalf("expected proxy: %s, got: %s", tc.expectedProxy, proxyURL.String())
+				}
+			}
+		})
+	}
+}
+
+func testCACert(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key, err: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate, err: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestNewKeyVaultObjectVersion(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires := created.Add(time.Hour)
+	disabled := false
+
+	v := newKeyVaultObjectVersion("v1", &disabled, &created, nil, &expires, map[string]*string{"env": to.StringPtr("prod"), "empty": nil})
+	expected := types.KeyVaultObjectVersion{
+		Version: "v1",
+		Created: created,
+		Enabled: false,
+		Expires: &expires,
+		Tags:    map[string]string{"env": "prod"},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("newKeyVaultObjectVersion() = %+v, expected %+v", v, expected)
+	}
+
+	// the version is enabled and created at the unix epoch if the attributes aren't set
+	v = newKeyVaultObjectVersion("v2", nil, nil, nil, nil, nil)
+	if !v.Enabled || !v.Created.Equal(date.UnixEpoch()) || v.Tags != nil {
+		t.Fatalf("newKeyVaultObjectVersion() = %+v, expected enabled version created at the unix epoch", v)
+	}
+}
+
+// vaultRequestReporter records the requests reported by the vault request policy
+type vaultRequestReporter struct {
+	metrics.StatsReporter
+	requests []string
+}
+
+func (r *vaultRequestReporter) ReportVaultRequest(_ context.Context, vault, statusCode string) {
+	r.requests = append(r.requests, vault+"/"+statusCode)
+}
+
+// statusTransport returns the status codes in order for the requests
+type statusTransport struct {
+	statusCodes []int
+}
+
+func (t *statusTransport) Do(req *http.Request) (*http.Response, error) {
+	statusCode := t.statusCodes[0]
+	t.statusCodes = t.statusCodes[1:]
+	return &http.Response{StatusCode: statusCode, Body: http.NoBody, Header: http.Header{}, Request: req}, nil
+}
+
+func TestVaultRequestPolicy(t *testing.T) {
+	reporter := &vaultRequestReporter{}
+	pl := runtime.NewPipeline("test", "v1", runtime.PipelineOptions{}, &policy.ClientOptions{
+		Transport:        &statusTransport{statusCodes: []int{http.StatusServiceUnavailable, http.StatusOK}},
+		Retry:            policy.RetryOptions{RetryDelay: time.Millisecond},
+		PerRetryPolicies: []policy.Policy{&vaultRequestPolicy{reporter: reporter}},
+	})
+	req, err := runtime.NewRequest(context.Background(), http.MethodGet, "https://test.vault.azure.net/secrets/secret1")
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if _, err = pl.Do(req); err != nil {
+		t.Fatalf("Do() = %v, want nil", err)
+	}
+
+	// the retried request is reported for every attempt
+	expected := []string{"test.vault.azure.net/503", "test.vault.azure.net/200"}
+	if !reflect.DeepEqual(reporter.requests, expected) {
+		t.Fatalf("expected requests %v, got %v", expected, reporter.requests)
+	}
+}
@@ -0,0 +1,186 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+)
+
+const (
+	// metadataFileSuffix is the suffix of the metadata sidecar file of an object
+	metadataFileSuffix = ".meta.json"
+	// currentFileName is the name of the file in the version history directory that has
+	// the name of the file of the current version
+	currentFileName = "current"
+	// createdLayoutFormat is the format of the creation time in the file names for the created
+	// layout. The time is formatted without ':' as it's not allowed in the file names on Windows.
+	createdLayoutFormat = "20060102T150405Z"
+
+	// metadataContentType writes the content type of the version to <file>.contentType
+	metadataContentType = "contentType"
+	// metadataTags writes the tags of the version to <file>.tags.json
+	metadataTags = "tags"
+	// metadataTagPrefix writes the value of the tag of the version to <file>.tag.<tag name>
+	metadataTagPrefix = "tag:"
+)
+
+// objectMetadata is the content of the metadata sidecar file of an object
+type objectMetadata struct {
+	ObjectName string `json:"objectName"`
+	ObjectType string `json:"objectType"`
+	// Current is the file of the current version of the object
+	Current  string            `json:"current"`
+	Versions []versionMetadata `json:"versions"`
+}
+
+// versionMetadata is the metadata of a version of the object returned by key vault
+type versionMetadata struct {
+	// File is the path of the file of the version in the mount
+	File        string            `json:"file"`
+	Version     string            `json:"version"`
+	Created     *time.Time        `json:"created,omitempty"`
+	Updated     *time.Time        `json:"updated,omitempty"`
+	NotBefore   *time.Time        `json:"notBefore,omitempty"`
+	Expires     *time.Time        `json:"expires,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// newVersionMetadata returns the metadata of the version with the attributes returned by key vault
+func newVersionMetadata(version string, created, updated, notBefore, expires *time.Time, contentType *string, tags map[string]*string) versionMetadata {
+	m := versionMetadata{
+		Version:   version,
+		Created:   created,
+		Updated:   updated,
+		NotBefore: notBefore,
+		Expires:   expires,
+		Tags:      toStringMap(tags),
+	}
+	if contentType != nil {
+		m.ContentType = *contentType
+	}
+	return m
+}
+
+// toStringMap returns the tags without the nil values. nil is returned if there are no tags.
+func toStringMap(tags map[string]*string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if v != nil {
+			m[k] = *v
+		}
+	}
+	return m
+}
+
+// getVersionFileName returns the name of the file of the version in the version history directory
+// for the layout. index is the position of the version starting with 0 at the latest version.
+func getVersionFileName(layout string, index int, objectVersion types.KeyVaultObjectVersion) string {
+	switch strings.ToLower(layout) {
+	case types.VersionHistoryLayoutVersion:
+		return objectVersion.Version
+	case types.VersionHistoryLayoutCreated:
+		// the creation time has a precision of seconds, so the versions created in the same
+		// second are distinguished by the version ID
+		return objectVersion.Created.UTC().Format(createdLayoutFormat) + "-" + objectVersion.Version
+	default:
+		return strconv.Itoa(index)
+	}
+}
+
+// validateVersionHistoryLayout checks if the version history layout is supported
+func validateVersionHistoryLayout(layout string) error {
+	switch strings.ToLower(layout) {
+	case "", types.VersionHistoryLayoutIndex, types.VersionHistoryLayoutVersion, types.VersionHistoryLayoutCreated:
+		return nil
+	default:
+		return fmt.Errorf("invalid objectVersionHistoryLayout: %v, should be index, version or created", layout)
+	}
+}
+
+// getCurrentFile returns the file with the name of the file of the current version in the version
+// history directory. The file is written for the layouts where the current version isn't at a fixed
+// file name. nil is returned for the index layout as the current version is always 0.
+func getCurrentFile(kvObject types.KeyVaultObject, resolvedKvObjects []types.KeyVaultObject) *types.SecretFile {
+	if kvObject.IsSyncingSingleVersion() || len(resolvedKvObjects) == 0 {
+		return nil
+	}
+	layout := strings.ToLower(kvObject.ObjectVersionHistoryLayout)
+	if layout == "" || layout == types.VersionHistoryLayoutIndex {
+		return nil
+	}
+	return &types.SecretFile{
+		Path:    filepath.Join(kvObject.GetFileName(), currentFileName),
+		Content: []byte(filepath.Base(resolvedKvObjects[0].GetFileName())),
+	}
+}
+
+// getMetadataFile returns the metadata sidecar file of the object with the metadata of the versions
+func getMetadataFile(kvObject types.KeyVaultObject, versions []versionMetadata) (*types.SecretFile, error) {
+	if !kvObject.MetadataFile || len(versions) == 0 {
+		return nil, nil
+	}
+	content, err := json.MarshalIndent(objectMetadata{
+		ObjectName: kvObject.ObjectName,
+		ObjectType: kvObject.ObjectType,
+		Current:    versions[0].File,
+		Versions:   versions,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata, error: %w", err)
+	}
+	return &types.SecretFile{
+		Path:    kvObject.GetFileName() + metadataFileSuffix,
+		Content: content,
+	}, nil
+}
+
+// validateMetadataFiles checks if the selected metadata files are supported
+func validateMetadataFiles(selectors []string) error {
+	for _, selector := range selectors {
+		switch {
+		case selector == metadataContentType, selector == metadataTags:
+		case strings.HasPrefix(selector, metadataTagPrefix):
+			tag := strings.TrimPrefix(selector, metadataTagPrefix)
+			if tag == "" || tag == "." || tag == ".." || strings.ContainsAny(tag, `/\`) {
+				return fmt.Errorf("invalid metadataFiles: %s, the tag name should be a valid file name", selector)
+			}
+		default:
+			return fmt.Errorf("invalid metadataFiles: %s, should be contentType, tags or tag:<tag name>", selector)
+		}
+	}
+	return nil
+}
+
+// getSelectedMetadataFiles returns the files with the selected metadata of the version. The files are
+// written even if the metadata isn't set in key vault, so the files don't come and go between rotations.
+func getSelectedMetadataFiles(kvObject types.KeyVaultObject, m versionMetadata) []types.SecretFile {
+	files := make([]types.SecretFile, 0, len(kvObject.MetadataFiles))
+	fileName := kvObject.GetFileName()
+	for _, selector := range kvObject.MetadataFiles {
+		switch {
+		case selector == metadataContentType:
+			files = append(files, types.SecretFile{Path: fileName + ".contentType", Content: []byte(m.ContentType)})
+		case selector == metadataTags:
+			tags := m.Tags
+			if tags == nil {
+				tags = map[string]string{}
+			}
+			// marshaling a map of strings doesn't fail and the keys are sorted
+			content, _ := json.Marshal(tags)
+			files = append(files, types.SecretFile{Path: fileName + ".tags.json", Content: content})
+		case strings.HasPrefix(selector, metadataTagPrefix):
+			tag := strings.TrimPrefix(selector, metadataTagPrefix)
+			files = append(files, types.SecretFile{Path: fileName + ".tag." + tag, Content: []byte(m.Tags[tag])})
+		}
+	}
+	return files
+}
@@ -0,0 +1,252 @@
+package provider
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_keyvault"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+)
+
+func TestGetVersionFileName(t *testing.T) {
+	objectVersion := types.KeyVaultObjectVersion{
+		Version: "abc",
+		Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("test", 3600)),
+	}
+
+	cases := []struct {
+		desc     string
+		layout   string
+		expected string
+	}{
+		{
+			desc:     "default layout",
+			layout:   "",
+			expected: "1",
+		},
+		{
+			desc:     "index layout",
+			layout:   "index",
+			expected: "1",
+		},
+		{
+			desc:     "version layout",
+			layout:   "version",
+			expected: "abc",
+		},
+		{
+			desc:     "created layout in UTC",
+			layout:   "Created",
+			expected: "20240102T020405Z-abc",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if actual := getVersionFileName(tc.layout, 1, objectVersion); actual != tc.expected {
+				t.Fatalf("getVersionFileName() = %s, expected %s", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestValidateVersionHistoryLayout(t *testing.T) {
+	for _, layout := range []string{"", "index", "version", "created", "Version"} {
+		if err := validateVersionHistoryLayout(layout); err != nil {
+			t.Fatalf("validateVersionHistoryLayout(%q) = %v, want nil", layout, err)
+		}
+	}
+	if err := validateVersionHistoryLayout("timestamp"); err == nil {
+		t.Fatalf("validateVersionHistoryLayout() = nil, want error")
+	}
+}
+
+func TestGetObjectFilesVersionHistoryLayout(t *testing.T) {
+	now := time.Now()
+	created1, created2 := now.Add(-time.Hour), now.Add(-2*time.Hour)
+	versions := []types.KeyVaultObjectVersion{
+		{Version: "v1", Created: created1, Enabled: true},
+		{Version: "v2", Created: created2, Enabled: true},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kvClient.EXPECT().GetSecretVersions(gomock.Any(), "secret1").Return(versions, nil)
+	for _, v := range []string{"v1", "v2"} {
+		id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/" + v)
+		kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", v).Return(&azsecrets.SecretBundle{
+			ID:          &id,
+			Value:       to.StringPtr("value-" + v),
+			ContentType: to.StringPtr("text/plain"),
+			Tags:        map[string]*string{"owner": to.StringPtr("team")},
+			Attributes:  &azsecrets.SecretAttributes{Created: &created1},
+		}, nil)
+	}
+
+	p := &provider{reporter: metrics.NewStatsReporter()}
+	kvObject := types.KeyVaultObject{
+		ObjectName:                 "secret1",
+		ObjectType:                 "secret",
+		ObjectVersionHistory:       2,
+		ObjectVersionHistoryLayout: "version",
+		MetadataFile:               true,
+	}
+	files, err := p.getObjectFiles(testContext(t), kvClient, kvObject, 0644)
+	if err != nil {
+		t.Fatalf("getObjectFiles() = %v, want nil", err)
+	}
+
+	paths := []string{}
+	for _, file := range files {
+		paths = append(paths, file.Path)
+		if file.UID == "" || file.Version == "" || file.FileMode != 0644 {
+			t.Fatalf("unexpected file %s with UID %q, version %q and mode %#o", file.Path, file.UID, file.Version, file.FileMode)
+		}
+	}
+	// the current and metadata files are reported with the UID and version of the current version
+	for _, file := range files[2:] {
+		if file.UID != files[0].UID || file.Version != "v1" {
+			t.Fatalf("expected file %s with UID %s and version v1, got UID %s and version %s", file.Path, files[0].UID, file.UID, file.Version)
+		}
+	}
+	expectedPaths := []string{
+		filepath.Join("secret1", "v1"),
+		filepath.Join("secret1", "v2"),
+		filepath.Join("secret1", "current"),
+		"secret1.meta.json",
+	}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Fatalf("expected paths %v, got %v", expectedPaths, paths)
+	}
+	if string(files[2].Content) != "v1" {
+		t.Fatalf("expected current file content v1, got %s", files[2].Content)
+	}
+
+	metadata := objectMetadata{}
+	if err = json.Unmarshal(files[3].Content, &metadata); err != nil {
+		t.Fatalf("failed to unmarshal metadata: %v", err)
+	}
+	if metadata.Current != filepath.Join("secret1", "v1") || len(metadata.Versions) != 2 {
+		t.Fatalf("unexpected metadata: %+v", metadata)
+	}
+	if v := metadata.Versions[1]; v.Version != "v2" || v.File != filepath.Join("secret1", "v2") || v.ContentType != "text/plain" || v.Tags["owner"] != "team" {
+		t.Fatalf("unexpected version metadata: %+v", v)
+	}
+}
+
+func TestGetObjectFilesIndexLayout(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kvClient.EXPECT().GetSecretVersions(gomock.Any(), "secret1").Return([]types.KeyVaultObjectVersion{{Version: "v1", Enabled: true}}, nil)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "v1").Return(&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("test")}, nil)
+
+	// the current file isn't written for the index layout and the metadata file is opt-in
+	p := &provider{reporter: metrics.NewStatsReporter()}
+	files, err := p.getObjectFiles(testContext(t), kvClient, types.KeyVaultObject{ObjectName: "secret1", ObjectType: "secret", ObjectVersionHistory: 2}, 0644)
+	if err != nil {
+		t.Fatalf("getObjectFiles() = %v, want nil", err)
+	}
+	if len(files) != 1 || files[0].Path != filepath.Join("secret1", "0") {
+		t.Fatalf("unexpected files: %v", files)
+	}
+}
+
+func TestValidateMetadataFiles(t *testing.T) {
+	cases := []struct {
+		desc        string
+		selectors   []string
+		expectedErr bool
+	}{
+		{
+			desc: "not set",
+		},
+		{
+			desc:      "supported selectors",
+			selectors: []string{"contentType", "tags", "tag:owner"},
+		},
+		{
+			desc:        "unsupported selector",
+			selectors:   []string{"expires"},
+			expectedErr: true,
+		},
+		{
+			desc:        "tag name not set",
+			selectors:   []string{"tag:"},
+			expectedErr: true,
+		},
+		{
+			desc:        "tag name with path separator",
+			selectors:   []string{"tag:../owner"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateMetadataFiles(tc.selectors)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetObjectFilesMetadataFiles(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(&azsecrets.SecretBundle{
+		ID:          &id,
+		Value:       to.StringPtr("test"),
+		ContentType: to.StringPtr("application/json"),
+		Tags:        map[string]*string{"owner": to.StringPtr("team"), "rotation": to.StringPtr("30d")},
+	}, nil)
+
+	p := &provider{reporter: metrics.NewStatsReporter()}
+	kvObject := types.KeyVaultObject{
+		ObjectName:    "secret1",
+		ObjectType:    "secret",
+		ObjectAlias:   "app",
+		MetadataFiles: []string{"contentType", "tags", "tag:owner", "tag:missing"},
+	}
+	files, err := p.getObjectFiles(testContext(t), kvClient, kvObject, 0644)
+	if err != nil {
+		t.Fatalf("getObjectFiles() = %v, want nil", err)
+	}
+
+	expected := map[string]string{
+		"app":             "test",
+		"app.contentType": "application/json",
+		"app.tags.json":   `{"owner":"team","rotation":"30d"}`,
+		"app.tag.owner":   "team",
+		"app.tag.missing": "",
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("expected %d files, got %d: %v", len(expected), len(files), files)
+	}
+	for _, file := range files {
+		content, ok := expected[file.Path]
+		if !ok || string(file.Content) != content {
+			t.Fatalf("unexpected file %s with content %q", file.Path, file.Content)
+		}
+		// the UID and version of the object are unchanged by the metadata files
+		if file.UID != "secret/secret1" || file.Version != "v1" {
+			t.Fatalf("unexpected UID %s and version %s for file %s", file.UID, file.Version, file.Path)
+		}
+	}
+}
@@ -4,31 +4,39 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net/url"
 	"os"
 	"path/filepath"
-	"reflect"
 	"regexp"
+	"runtime"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/audit"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/breaker"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cache"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cloud"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/policy"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/tracing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
-	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/pkcs12"
 	"golang.org/x/net/context"
-	"gopkg.in/yaml.v3"
+	"golang.org/x/sync/singleflight"
 	"k8s.io/klog/v2"
 )
 
@@ -43,15 +51,151 @@ type provider struct {
 	constructPEMChain              bool
 	writeCertAndKeyInSeparateFiles bool
 
-	defaultCloudEnvironment azure.Environment
+	defaultCloudEnvironment cloud.Environment
+
+	// lastKnownGoodCache stores the last successfully mounted content and serves it
+	// when key vault can't be reached. It's nil if the cache is not enabled.
+	lastKnownGoodCache *cache.Cache
+
+	// auditLogger records the object versions returned for the mounts. It's nil if the audit log is not enabled.
+	auditLogger *audit.Logger
+
+	// requests coalesces the concurrent identical key vault calls of the mounts
+	requests singleflight.Group
+
+	// circuitBreaker rejects the mounts for a vault and an identity after repeated failures
+	// caused by the vault or the identity. It's nil if the circuit breaker is not enabled.
+	circuitBreaker *breaker.Breaker
+
+	// disabledAuthModes are the identity access modes rejected by the provider
+	disabledAuthModes map[string]bool
+	// deprecatedAuthModes are the identity access modes reported as deprecated
+	deprecatedAuthModes map[string]bool
+
+	// mountPolicy restricts the identities and the vaults the pods can use. It's nil if no policy is loaded.
+	mountPolicy *policy.Policy
+
+	// newKeyVaultClient creates the key vault client for the mount
+	newKeyVaultClient func(ctx context.Context, mc *mountConfig, vaultURI string) (KeyVault, error)
+
+	// allowDisableChallengeVerification lets the SecretProviderClass disable the verification of the
+	// key vault authentication challenge resource for custom vault URLs
+	allowDisableChallengeVerification bool
+	// allowKeyVaultProxyOverride lets the SecretProviderClass set the proxy and the CA bundle
+	// used to reach key vault
+	allowKeyVaultProxyOverride bool
+
+	// dryRun validates the mount configuration without fetching the objects
+	dryRun bool
+
+	// caseInsensitivePaths is true if the files are written to a case-insensitive
+	// file system, so paths that only differ in case conflict
+	caseInsensitivePaths bool
+}
+
+// Option configures optional features of the provider
+type Option func(*provider)
+
+// WithLastKnownGoodCache enables serving the last successfully mounted content from the
+// cache when the objects can't be fetched from key vault due to transient errors
+func WithLastKnownGoodCache(c *cache.Cache) Option {
+	return func(p *provider) {
+		p.lastKnownGoodCache = c
+	}
+}
+
+// WithAuditLogger enables recording the object versions returned for each pod in the audit log
+func WithAuditLogger(l *audit.Logger) Option {
+	return func(p *provider) {
+		p.auditLogger = l
+	}
+}
+
+// WithCircuitBreaker enables rejecting the mounts for a vault and an identity with the cached
+// error after repeated failures caused by the vault or the identity, e.g. the vault was deleted
+// or the identity lost access to the vault
+func WithCircuitBreaker(b *breaker.Breaker) Option {
+	return func(p *provider) {
+		p.circuitBreaker = b
+		b.OnStateChange(func(key breaker.Key, from, to breaker.State) {
+			klog.InfoS("circuit breaker state changed", "vault", key.Vault, "identity", key.Identity, "from", from, "to", to)
+			p.reporter.ReportCircuitBreakerStateChange(context.Background(), key.Vault, string(from), string(to))
+		})
+	}
+}
+
+// WithDisabledAuthModes rejects the mounts that use the identity access modes, e.g. to
+// enforce the migration from pod identity to workload identity
+func WithDisabledAuthModes(modes ...string) Option {
+	return func(p *provider) {
+		if p.disabledAuthModes == nil {
+			p.disabledAuthModes = make(map[string]bool)
+		}
+		for _, mode := range modes {
+			p.disabledAuthModes[mode] = true
+		}
+	}
+}
+
+// WithDeprecatedAuthModes replaces the identity access modes reported as deprecated. Only pod
+// identity is reported as deprecated by default.
+func WithDeprecatedAuthModes(modes ...string) Option {
+	return func(p *provider) {
+		p.deprecatedAuthModes = make(map[string]bool)
+		for _, mode := range modes {
+			p.deprecatedAuthModes[mode] = true
+		}
+	}
+}
+
+// WithPolicy enables rejecting the mounts that aren't allowed by the policy before any token is requested
+func WithPolicy(pol *policy.Policy) Option {
+	return func(p *provider) {
+		p.mountPolicy = pol
+	}
+}
+
+// WithKeyVaultClient replaces the key vault client used to fetch the objects. The
+// authentication configuration is validated but not used to create the client.
+func WithKeyVaultClient(newClient func(vaultURI string) (KeyVault, error)) Option {
+	return func(p *provider) {
+		p.newKeyVaultClient = func(_ context.Context, _ *mountConfig, vaultURI string) (KeyVault, error) {
+			return newClient(vaultURI)
+		}
+	}
+}
+
+// WithAllowDisableChallengeResourceVerification lets the SecretProviderClass disable the verification
+// that the key vault authentication challenge resource matches the domain of the vault URL. The vault
+// URL of the SecretProviderClass then receives the key vault tokens of the identity.
+func WithAllowDisableChallengeResourceVerification() Option {
+	return func(p *provider) {
+		p.allowDisableChallengeVerification = true
+	}
+}
+
+// WithAllowKeyVaultProxyOverride lets the SecretProviderClass set the proxy and the CA bundle used to
+// reach key vault. The proxy with a trusted CA can read the key vault tokens of the identity.
+func WithAllowKeyVaultProxyOverride() Option {
+	return func(p *provider) {
+		p.allowKeyVaultProxyOverride = true
+	}
+}
+
+// WithDryRun validates the mount configuration and the objects without fetching the
+// objects from key vault. No files are returned for the mount.
+func WithDryRun() Option {
+	return func(p *provider) {
+		p.dryRun = true
+	}
 }
 
 // mountConfig holds the information for the mount event
 type mountConfig struct {
 	// the name of the Azure Key Vault instance
 	keyvaultName string
-	// the type of azure cloud based on azure go sdk
-	azureCloudEnvironment azure.Environment
+	// the azure cloud environment for the mount
+	azureCloudEnvironment cloud.Environment
 	// authConfig is the config parameters for accessing Key Vault
 	authConfig auth.Config
 	// tenantID in AAD
@@ -60,51 +204,104 @@ type mountConfig struct {
 	podName string
 	// podNamespace is the pod namespace
 	podNamespace string
+	// serviceAccountName is the name of the service account of the pod
+	serviceAccountName string
+	// keyvaultURL is the user provided key vault URL that overrides the one
+	// built from the key vault name and cloud DNS suffix
+	keyvaultURL string
+	// vaultURL is the URL of the vault the objects are fetched from
+	vaultURL string
+	// kvClientOptions is the transport configuration for the key vault client
+	kvClientOptions ClientOptions
+	// failurePolicy defines how the failure to fetch objects that don't set optional is handled
+	failurePolicy types.FailurePolicy
 }
 
 type keyvaultObject struct {
 	content        string
 	fileNameSuffix string
 	version        string
+	metadata       versionMetadata
 }
 
 // NewProvider creates a new provider
-func NewProvider(constructPEMChain, writeCertAndKeyInSeparateFiles bool, defaultCloudEnvironment azure.Environment) Interface {
-	return &provider{
+func NewProvider(constructPEMChain, writeCertAndKeyInSeparateFiles bool, defaultCloudEnvironment cloud.Environment, opts ...Option) Interface {
+	p := &provider{
 		reporter:                       metrics.NewStatsReporter(),
 		constructPEMChain:              constructPEMChain,
 		writeCertAndKeyInSeparateFiles: writeCertAndKeyInSeparateFiles,
 		defaultCloudEnvironment:        defaultCloudEnvironment,
+		newKeyVaultClient: func(ctx context.Context, mc *mountConfig, vaultURI string) (KeyVault, error) {
+			return mc.initializeKvClient(ctx, vaultURI)
+		},
+		caseInsensitivePaths: runtime.GOOS == "windows",
+		// aad-pod-identity is deprecated in favor of workload identity
+		deprecatedAuthModes: map[string]bool{auth.ModePodIdentity: true},
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 // parseAzureEnvironment returns azure environment by name
-func (p *provider) parseAzureEnvironment(cloudName string) (azure.Environment, error) {
+func (p *provider) parseAzureEnvironment(cloudName string) (cloud.Environment, error) {
 	if cloudName == "" {
 		return p.defaultCloudEnvironment, nil
 	}
-	return azure.EnvironmentFromName(cloudName)
+	return cloud.EnvironmentFromName(cloudName)
 }
 
-func (mc *mountConfig) initializeKvClient(vaultURI string) (KeyVault, error) {
+// getAzureEnvironment returns the azure environment for the mount in the order of precedence:
+// 1. the cloud environment defined inline in the SecretProviderClass
+// 2. the custom cloud environment file if the cloud name is AzureStackCloud
+// 3. the registered cloud environment by name or the default cloud environment
+// The environment is resolved for every mount and is never shared through the
+// process environment variables, so concurrent mounts can use different clouds.
+func (p *provider) getAzureEnvironment(cloudName, cloudEnvFileName, cloudEnvironment string) (cloud.Environment, error) {
+	if cloudEnvironment != "" {
+		env, err := cloud.ParseEnvironment([]byte(cloudEnvironment))
+		if err != nil {
+			return cloud.Environment{}, fmt.Errorf("failed to parse cloudEnvironment, error: %w", err)
+		}
+		return env, nil
+	}
+	if strings.EqualFold(cloudName, cloud.AzureStackCloudName) {
+		if cloudEnvFileName == "" {
+			return cloud.Environment{}, fmt.Errorf("cloudEnvFileName is required for cloudName %s", cloudName)
+		}
+		klog.V(5).InfoS("loading custom cloud environment from file", "fileName", cloudEnvFileName)
+		return cloud.EnvironmentFromFile(cloudEnvFileName)
+	}
+	return p.parseAzureEnvironment(cloudName)
+}
+
+func (mc *mountConfig) initializeKvClient(ctx context.Context, vaultURI string) (KeyVault, error) {
 	kvEndpoint := strings.TrimSuffix(mc.azureCloudEnvironment.KeyVaultEndpoint, "/")
 
-	cred, err := mc.authConfig.GetCredential(mc.podName, mc.podNamespace, kvEndpoint, mc.azureCloudEnvironment.ActiveDirectoryEndpoint, mc.tenantID, types.PodIdentityNMIPort)
+	cred, err := mc.authConfig.GetCredential(ctx, mc.podName, mc.podNamespace, kvEndpoint, mc.azureCloudEnvironment.Configuration(), mc.tenantID, types.PodIdentityNMIPort)
 	if err != nil {
-		return nil, err
+		return nil, invalidConfigError(err)
 	}
-	return NewClient(cred, vaultURI)
+	// the credential is wrapped so token acquisition failures during the key vault
+	// requests are classified as authentication errors
+	kvClient, err := NewClient(&credential{TokenCredential: cred, mode: mc.authConfig.Mode(), reporter: mc.kvClientOptions.Reporter}, vaultURI, &mc.kvClientOptions)
+	if err != nil {
+		return nil, invalidConfigError(err)
+	}
+	return kvClient, nil
 }
 
 func (mc *mountConfig) getVaultURL() (vaultURL *string, err error) {
-	// Key Vault name must be a 3-24 character string
-	if len(mc.keyvaultName) < 3 || len(mc.keyvaultName) > 24 {
-		return nil, errors.Errorf("Invalid vault name: %q, must be between 3 and 24 chars", mc.keyvaultName)
+	if mc.keyvaultURL != "" {
+		vaultURI, err := parseVaultURL(mc.keyvaultURL)
+		if err != nil {
+			return nil, err
+		}
+		return &vaultURI, nil
 	}
-	// See docs for validation spec: https://docs.microsoft.com/en-us/azure/key-vault/about-keys-secrets-and-certificates#objects-identifiers-and-versioning
-	isValid := regexp.MustCompile(`^[-A-Za-z0-9]+$`).MatchString
-	if !isValid(mc.keyvaultName) {
-		return nil, errors.Errorf("Invalid vault name: %q, must match [-a-zA-Z0-9]{3,24}", mc.keyvaultName)
+	if err = validateVaultName(mc.keyvaultName); err != nil {
+		return nil, err
 	}
 
 	vaultDNSSuffixValue := mc.azureCloudEnvironment.KeyVaultDNSSuffix
@@ -112,172 +309,571 @@ func (mc *mountConfig) getVaultURL() (vaultURL *string, err error) {
 	return &vaultURI, nil
 }
 
+// validateVaultName checks that the vault name is a 3-24 character string of alphanumerics and dashes
+func validateVaultName(keyvaultName string) error {
+	// Key Vault name must be a 3-24 character string
+	if len(keyvaultName) < 3 || len(keyvaultName) > 24 {
+		return errors.Errorf("Invalid vault name: %q, must be between 3 and 24 chars", keyvaultName)
+	}
+	// See docs for validation spec: https://docs.microsoft.com/en-us/azure/key-vault/about-keys-secrets-and-certificates#objects-identifiers-and-versioning
+	isValid := regexp.MustCompile(`^[-A-Za-z0-9]+$`).MatchString
+	if !isValid(keyvaultName) {
+		return errors.Errorf("Invalid vault name: %q, must match [-a-zA-Z0-9]{3,24}", keyvaultName)
+	}
+	return nil
+}
+
+// isVaultDomain returns true if the host in vault URL belongs to the key vault
+// DNS suffix of the cloud environment
+func (mc *mountConfig) isVaultDomain(vaultURL string) bool {
+	u, err := url.Parse(vaultURL)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(strings.ToLower(u.Hostname()), "."+strings.ToLower(mc.azureCloudEnvironment.KeyVaultDNSSuffix))
+}
+
+// parseVaultURL validates the user provided vault URL and returns it in the
+// https://<host>/ format expected by the key vault client
+func parseVaultURL(keyvaultURL string) (string, error) {
+	u, err := url.Parse(keyvaultURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "Invalid vault url: %q", keyvaultURL)
+	}
+	if u.Scheme != "https" {
+		return "", errors.Errorf("Invalid vault url: %q, scheme must be https", keyvaultURL)
+	}
+	if u.Host == "" {
+		return "", errors.Errorf("Invalid vault url: %q, host must be set", keyvaultURL)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.Errorf("Invalid vault url: %q, must not contain a path, query or fragment", keyvaultURL)
+	}
+	return "https://" + u.Host + "/", nil
+}
+
 // GetSecretsStoreObjectContent gets the objects (secret, key, certificate) from keyvault and returns the content
 // to the CSI driver. The driver will write the content to the file system.
 func (p *provider) GetSecretsStoreObjectContent(ctx context.Context, attrib, secrets map[string]string, defaultFilePermission os.FileMode) ([]types.SecretFile, error) {
+	files, mc, err := p.getSecretsStoreObjectContent(ctx, attrib, secrets, defaultFilePermission)
+	files, outcome, err := p.serveLastKnownGood(ctx, attrib, mc, files, err)
+
+	var authMode, vaultURL string
+	if mc != nil {
+		authMode = mc.authConfig.Mode()
+		vaultURL = mc.vaultURL
+	}
+	var errorCode string
+	if err != nil {
+		errorCode = string(ErrorCodeOf(err))
+	}
+	p.reporter.ReportMount(ctx, outcome, authMode, errorCode)
+	if p.auditLogger != nil && err == nil {
+		events := auditEvents(attrib, vaultURL, authMode, outcome == metrics.MountOutcomeStale, files, time.Now())
+		if auditErr := p.auditLogger.Log(events); auditErr != nil {
+			klog.ErrorS(auditErr, "failed to write audit events", "pod", klog.ObjectRef{Namespace: types.GetPodNamespace(attrib), Name: types.GetPodName(attrib)})
+		}
+	}
+	return files, err
+}
+
+// auditEvents returns the audit events of the object versions in the files of the mount. The
+// files of the same object version, e.g. the certificate and the key, are recorded once.
+func auditEvents(attrib map[string]string, vaultURL, authMode string, stale bool, files []types.SecretFile, now time.Time) []audit.Event {
+	events := []audit.Event{}
+	recorded := make(map[string]bool)
+	for _, file := range files {
+		id := file.UID + "@" + file.Version
+		if recorded[id] {
+			continue
+		}
+		recorded[id] = true
+		// the id is in the format <object type>/<object name>[/<version file name>]
+		parts := strings.SplitN(file.UID, "/", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		events = append(events, audit.Event{
+			Time:                now,
+			PodNamespace:        types.GetPodNamespace(attrib),
+			PodName:             types.GetPodName(attrib),
+			SecretProviderClass: types.GetSecretProviderClassName(attrib),
+			Vault:               vaultURL,
+			AuthMode:            authMode,
+			ObjectType:          parts[0],
+			ObjectName:          parts[1],
+			ObjectVersion:       file.Version,
+			Stale:               stale,
+		})
+	}
+	return events
+}
+
+// serveLastKnownGood stores the fetched content in the last known good cache and serves the cached
+// content if key vault or azure AD is unavailable. The outcome of the mount is returned with the files.
+func (p *provider) serveLastKnownGood(ctx context.Context, attrib map[string]string, mc *mountConfig, files []types.SecretFile, err error) ([]types.SecretFile, string, error) {
+	outcome := metrics.MountOutcomeSuccess
+	if err != nil {
+		outcome = metrics.MountOutcomeFailure
+	}
+	// the mount config is only nil for configuration errors that are never served from the cache
+	if p.lastKnownGoodCache == nil || mc == nil {
+		return files, outcome, err
+	}
+
+	podName := types.GetPodName(attrib)
+	podNamespace := types.GetPodNamespace(attrib)
+	secretProviderClass := types.GetSecretProviderClassName(attrib)
+	if podNamespace == "" || secretProviderClass == "" {
+		return files, outcome, err
+	}
+	key := cache.Key(podNamespace, secretProviderClass, lastKnownGoodFingerprint(attrib, mc))
+	podRef := klog.ObjectRef{Namespace: podNamespace, Name: podName}
+
+	if err == nil {
+		if cacheErr := p.lastKnownGoodCache.Store(key, files); cacheErr != nil {
+			klog.ErrorS(cacheErr, "failed to store last known good content", "secretProviderClass", secretProviderClass, "pod", podRef)
+		}
+		return files, outcome, nil
+	}
+	// only serve the cached content when key vault or azure AD is unavailable, other errors
+	// like permission denied or not found are returned so they aren't masked by the cache
+	if !IsTransient(err) {
+		return nil, outcome, err
+	}
+	cachedFiles, storedAt, cacheErr := p.lastKnownGoodCache.Load(key)
+	if cacheErr != nil {
+		klog.V(2).InfoS("last known good content not available", "reason", cacheErr.Error(), "secretProviderClass", secretProviderClass, "pod", podRef)
+		return nil, outcome, err
+	}
+	klog.ErrorS(err, "serving last known good content", "storedAt", storedAt, "secretProviderClass", secretProviderClass, "pod", podRef)
+	p.reporter.ReportStaleContentServed(ctx, string(ErrorCodeOf(err)), time.Since(storedAt).Seconds())
+	return cachedFiles, metrics.MountOutcomeStale, nil
+}
+
+// lastKnownGoodFingerprint returns the hash of the SecretProviderClass parameters and the identity
+// of the mount. The attributes set by the driver for the pod, e.g. the pod name and the service
+// account tokens, are excluded so the pods with the same parameters and identity share the content.
+// Pod identity mounts aren't shared with the other pods.
+func lastKnownGoodFingerprint(attrib map[string]string, mc *mountConfig) string {
+	keys := make([]string, 0, len(attrib))
+	for k := range attrib {
+		if strings.HasPrefix(k, types.CSIAttributePrefix) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%q=%q\n", k, attrib[k])
+	}
+	identity := []string{mc.authConfig.Mode(), mc.tenantID, mc.authConfig.ClientID(), mc.serviceAccountName}
+	if mc.authConfig.Mode() == auth.ModePodIdentity {
+		// the pod identity is bound to the labels of the pod that aren't known to the provider
+		identity = append(identity, mc.podName)
+	}
+	fmt.Fprintf(h, "identity=%q\n", strings.Join(identity, "/"))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// getSecretsStoreObjectContent fetches the objects from keyvault and returns the content and
+// the mount config used to fetch the objects. The mount config is nil if the auth config couldn't be created.
+func (p *provider) getSecretsStoreObjectContent(ctx context.Context, attrib, secrets map[string]string, defaultFilePermission os.FileMode) ([]types.SecretFile, *mountConfig, error) {
 	keyvaultName := types.GetKeyVaultName(attrib)
 	cloudName := types.GetCloudName(attrib)
 	userAssignedIdentityID := types.GetUserAssignedIdentityID(attrib)
 	tenantID := types.GetTenantID(attrib)
 	cloudEnvFileName := types.GetCloudEnvFileName(attrib)
+	cloudEnvironment := types.GetCloudEnvironment(attrib)
 	podName := types.GetPodName(attrib)
 	podNamespace := types.GetPodNamespace(attrib)
+	// the mount config isn't known until the auth config is created
+	var mc *mountConfig
 
 	usePodIdentity, err := types.GetUsePodIdentity(attrib)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse usePodIdentity flag, error: %w", err)
+		return nil, mc, invalidConfigError(fmt.Errorf("failed to parse usePodIdentity flag, error: %w", err))
 	}
 	useVMManagedIdentity, err := types.GetUseVMManagedIdentity(attrib)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse useVMManagedIdentity flag, error: %w", err)
+		return nil, mc, invalidConfigError(fmt.Errorf("failed to parse useVMManagedIdentity flag, error: %w", err))
 	}
 
 	// attributes for workload identity
 	workloadIdentityClientID := types.GetClientID(attrib)
 	saTokens := types.GetServiceAccountTokens(attrib)
 
-	if keyvaultName == "" {
-		return nil, fmt.Errorf("keyvaultName is not set")
+	// attributes for custom vault endpoints
+	keyvaultURL := types.GetKeyVaultURL(attrib)
+
+	failurePolicy, err := types.GetFailurePolicy(attrib)
+	if err != nil {
+		return nil, mc, invalidConfigError(err)
 	}
-	if tenantID == "" {
-		return nil, fmt.Errorf("tenantId is not set")
+	disableChallengeVerification, err := types.GetKeyVaultDisableChallengeResourceVerification(attrib)
+	if err != nil {
+		return nil, mc, invalidConfigError(fmt.Errorf("failed to parse %s flag, error: %w", types.KeyVaultDisableChallengeResourceVerificationParameter, err))
 	}
 
-	err = setAzureEnvironmentFilePath(cloudEnvFileName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to set AZURE_ENVIRONMENT_FILEPATH env to %s, error %w", cloudEnvFileName, err)
+	if keyvaultName == "" && keyvaultURL == "" {
+		return nil, mc, invalidConfigError(fmt.Errorf("keyvaultName is not set"))
 	}
-	azureCloudEnv, err := p.parseAzureEnvironment(cloudName)
+	if tenantID == "" {
+		return nil, mc, invalidConfigError(fmt.Errorf("tenantId is not set"))
+	}
+
+	azureCloudEnv, err := p.getAzureEnvironment(cloudName, cloudEnvFileName, cloudEnvironment)
 	if err != nil {
-		return nil, fmt.Errorf("cloudName %s is not valid, error: %w", cloudName, err)
+		return nil, mc, invalidConfigError(fmt.Errorf("cloudName %s is not valid, error: %w", cloudName, err))
 	}
 
 	// parse bound service account tokens for workload identity only if the clientID is set
 	var workloadIdentityToken string
 	if workloadIdentityClientID != "" {
 		if workloadIdentityToken, err = auth.ParseServiceAccountToken(saTokens); err != nil {
-			return nil, fmt.Errorf("failed to parse workload identity tokens, error: %w", err)
+			return nil, mc, invalidConfigError(fmt.Errorf("failed to parse workload identity tokens, error: %w", err))
 		}
 	}
 
 	authConfig, err := auth.NewConfig(usePodIdentity, useVMManagedIdentity, userAssignedIdentityID, workloadIdentityClientID, workloadIdentityToken, secrets)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create auth config, error: %w", err)
+		return nil, mc, invalidConfigError(fmt.Errorf("failed to create auth config, error: %w", err))
 	}
 
-	mc := &mountConfig{
+	mc = &mountConfig{
 		keyvaultName:          keyvaultName,
 		azureCloudEnvironment: azureCloudEnv,
 		authConfig:            authConfig,
 		tenantID:              tenantID,
 		podName:               podName,
 		podNamespace:          podNamespace,
+		serviceAccountName:    types.GetServiceAccountName(attrib),
+		keyvaultURL:           keyvaultURL,
+		failurePolicy:         failurePolicy,
+		kvClientOptions: ClientOptions{
+			ProxyURL:      types.GetKeyVaultProxyURL(attrib),
+			CABundle:      types.GetKeyVaultCABundle(attrib),
+			TLSServerName: types.GetKeyVaultTLSServerName(attrib),
+			Reporter:      p.reporter,
+		},
+	}
+
+	if err = p.checkAuthMode(ctx, mc); err != nil {
+		return nil, mc, err
 	}
 
 	objectsStrings := types.GetObjects(attrib)
 	if objectsStrings == "" {
-		return nil, fmt.Errorf("objects is not set")
+		return nil, mc, invalidConfigError(fmt.Errorf("objects is not set"))
 	}
 	klog.V(2).InfoS("objects string defined in secret provider class", "objects", objectsStrings, "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
 
-	objects, err := types.GetObjectsArray(objectsStrings)
-	if err != nil {
-		return nil, fmt.Errorf("failed to yaml unmarshal objects, error: %w", err)
+	keyVaultObjects, errs := parseKeyVaultObjects(objectsStrings)
+	if len(errs) > 0 {
+		return nil, mc, invalidConfigError(errs[0])
 	}
-	klog.V(2).InfoS("unmarshaled objects yaml array", "objectsArray", objects.Array, "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
-
-	keyVaultObjects := []types.KeyVaultObject{}
-	for i, object := range objects.Array {
-		var keyVaultObject types.KeyVaultObject
-		err = yaml.Unmarshal([]byte(object), &keyVaultObject)
-		if err != nil {
-			return nil, fmt.Errorf("unmarshal failed for keyVaultObjects at index %d, error: %w", i, err)
-		}
-		// remove whitespace from all fields in keyVaultObject
-		formatKeyVaultObject(&keyVaultObject)
-
-		if err = validate(keyVaultObject); err != nil {
-			return nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
-		}
-
-		keyVaultObjects = append(keyVaultObjects, keyVaultObject)
+	// the objects with the same alias or file name are rejected before any call to key vault,
+	// the files generated from the fetched content are checked after the fetch
+	if errs = validateFilePaths(keyVaultObjects, false, p.caseInsensitivePaths); len(errs) > 0 {
+		return nil, mc, invalidConfigError(errs[0])
 	}
 
 	klog.V(5).InfoS("unmarshaled key vault objects", "keyVaultObjects", keyVaultObjects, "count", len(keyVaultObjects), "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
 
 	if len(keyVaultObjects) == 0 {
-		return nil, nil
+		return nil, mc, nil
 	}
 
 	vaultURL, err := mc.getVaultURL()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get vault")
+		return nil, mc, invalidConfigError(errors.Wrap(err, "failed to get vault"))
 	}
+	mc.vaultURL = *vaultURL
 	klog.V(2).InfoS("vault url", "vaultName", mc.keyvaultName, "vaultURL", *vaultURL, "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
+	if err = p.checkPolicy(mc); err != nil {
+		return nil, mc, err
+	}
+	// a proxy trusted with the CA bundle of the SecretProviderClass can intercept the TLS connection
+	// and read the key vault tokens of the identity, so it needs to be allowed by the provider
+	if (mc.kvClientOptions.ProxyURL != "" || mc.kvClientOptions.CABundle != "") && !p.allowKeyVaultProxyOverride {
+		return nil, mc, invalidConfigError(fmt.Errorf("%s and %s are not allowed by the provider", types.KeyVaultProxyURLParameter, types.KeyVaultCABundleParameter))
+	}
+	// the authentication challenge resource returned by key vault doesn't match a custom DNS name or
+	// IP address used to reach the vault. Skipping the verification lets the vault URL receive tokens
+	// for key vault, so it needs to be enabled by both the SecretProviderClass and the provider.
+	if disableChallengeVerification {
+		if !p.allowDisableChallengeVerification {
+			return nil, mc, newError(ErrorCodePermissionDenied, fmt.Errorf("%s is not allowed by the provider", types.KeyVaultDisableChallengeResourceVerificationParameter))
+		}
+		mc.kvClientOptions.DisableChallengeResourceVerification = true
+	} else if keyvaultURL != "" && !mc.isVaultDomain(*vaultURL) {
+		klog.InfoS("vault url is not in the key vault domain, the authentication challenge will fail unless the challenge resource verification is disabled",
+			"vaultURL", *vaultURL, "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
+	}
+	if p.dryRun {
+		klog.InfoS("dry run, skipping fetching objects from key vault", "vaultURL", *vaultURL, "count", len(keyVaultObjects), "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
+		return nil, mc, nil
+	}
+
+	tracing.SetAttributes(ctx, tracing.VaultURLKey.String(*vaultURL))
+	files, err := p.fetchSecretFiles(ctx, mc, *vaultURL, keyVaultObjects, defaultFilePermission)
+	return files, mc, err
+}
+
+// checkAuthMode rejects the mount with PermissionDenied if its identity access mode is disabled
+// and reports the use of the deprecated identity access modes. The deprecated modes are logged at
+// V(2) as every mount and rotation uses them, the metric counts them by namespace.
+func (p *provider) checkAuthMode(ctx context.Context, mc *mountConfig) error {
+	mode := mc.authConfig.Mode()
+	podRef := klog.ObjectRef{Namespace: mc.podNamespace, Name: mc.podName}
+	if p.deprecatedAuthModes[mode] {
+		klog.V(2).InfoS("deprecated identity access mode used, migrate to workload identity", "authMode", mode, "pod", podRef)
+		p.reporter.ReportDeprecatedAuthMode(ctx, mode, mc.podNamespace)
+	}
+	if p.disabledAuthModes[mode] {
+		err := fmt.Errorf("identity access mode %s is disabled in the provider", mode)
+		klog.ErrorS(err, "mount denied", "pod", podRef)
+		return newError(ErrorCodePermissionDenied, err)
+	}
+	return nil
+}
+
+// checkPolicy rejects the mount with PermissionDenied if the identity or the vault of the mount
+// isn't allowed for the pod by the policy
+func (p *provider) checkPolicy(mc *mountConfig) error {
+	if p.mountPolicy == nil {
+		return nil
+	}
+	u, err := url.Parse(mc.vaultURL)
+	if err != nil {
+		return invalidConfigError(err)
+	}
+	err = p.mountPolicy.Allow(policy.Mount{
+		PodNamespace:   mc.podNamespace,
+		ServiceAccount: mc.serviceAccountName,
+		AuthMode:       mc.authConfig.Mode(),
+		ClientID:       mc.authConfig.ClientID(),
+		Vault:          u.Hostname(),
+	})
+	if err != nil {
+		klog.ErrorS(err, "mount denied by policy", "pod", klog.ObjectRef{Namespace: mc.podNamespace, Name: mc.podName})
+		return newError(ErrorCodePermissionDenied, err)
+	}
+	return nil
+}
+
+// fetchSecretFiles creates the key vault client and fetches the objects. The mount is rejected
+// with the cached error while the circuit of the vault and the identity is open.
+func (p *provider) fetchSecretFiles(ctx context.Context, mc *mountConfig, vaultURL string, keyVaultObjects []types.KeyVaultObject, defaultFilePermission os.FileMode) (_ []types.SecretFile, err error) {
+	if p.circuitBreaker != nil {
+		u, parseErr := url.Parse(vaultURL)
+		if parseErr != nil {
+			return nil, invalidConfigError(parseErr)
+		}
+		key := breaker.Key{Vault: u.Hostname(), Identity: mc.authConfig.IdentityKey(mc.tenantID, mc.podNamespace)}
+		if openErr := p.circuitBreaker.Allow(key); openErr != nil {
+			p.reporter.ReportCircuitBreakerRejected(ctx, key.Vault)
+			return nil, openErr
+		}
+		defer func() { p.circuitBreaker.Record(key, err, isVaultFailure(err)) }()
+	}
 
 	// the keyvault name is per SPC and we don't need to recreate the client for every single keyvault object defined
-	kvClient, err := mc.initializeKvClient(*vaultURL)
+	kvClient, err := p.newKeyVaultClient(ctx, mc, vaultURL)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get keyvault client")
 	}
+	// the calls are only shared by the mounts that are authorized the same way
+	if credentialKey := mc.authConfig.CredentialKey(mc.tenantID, mc.podNamespace, mc.serviceAccountName); credentialKey != "" {
+		kvClient = newCoalescingClient(kvClient, &p.requests, vaultURL, credentialKey, mc.kvClientOptions.transportKey())
+	}
+	return p.getSecretFiles(ctx, mc, kvClient, keyVaultObjects, defaultFilePermission)
+}
 
+// getSecretFiles fetches the key vault objects and returns the files to be written to the mount.
+// Optional objects that fail to be fetched are skipped and the rest of the objects are returned.
+func (p *provider) getSecretFiles(ctx context.Context, mc *mountConfig, kvClient KeyVault, keyVaultObjects []types.KeyVaultObject, defaultFilePermission os.FileMode) ([]types.SecretFile, error) {
+	podRef := klog.ObjectRef{Namespace: mc.podNamespace, Name: mc.podName}
 	files := []types.SecretFile{}
 	for _, keyVaultObject := range keyVaultObjects {
-		klog.V(5).InfoS("fetching object from key vault", "objectName", keyVaultObject.ObjectName, "objectType", keyVaultObject.ObjectType, "keyvault", mc.keyvaultName, "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
+		klog.V(5).InfoS("fetching object from key vault", "objectName", keyVaultObject.ObjectName, "objectType", keyVaultObject.ObjectType, "keyvault", mc.keyvaultName, "pod", podRef)
 
-		resolvedKvObjects, err := p.resolveObjectVersions(ctx, kvClient, keyVaultObject)
+		objectFiles, err := p.getObjectFiles(ctx, kvClient, keyVaultObject, defaultFilePermission)
+		if err != nil {
+			if !keyVaultObject.IsOptional(mc.failurePolicy) {
+				return nil, err
+			}
+			// the optional object is skipped from this mount and will be fetched again on the next rotation
+			klog.ErrorS(err, "skipping optional object that failed to be fetched", "objectName", keyVaultObject.ObjectName, "objectType", keyVaultObject.ObjectType, "keyvault", mc.keyvaultName, "pod", podRef)
+			p.reporter.ReportSkippedObject(ctx, keyVaultObject.ObjectType, keyVaultObject.ObjectName, string(ErrorCodeOf(err)))
+			continue
+		}
+
+		var bytes int
+		for _, file := range objectFiles {
+			klog.V(5).InfoS("added file to the gRPC response", "file", file.Path, "pod", podRef)
+			bytes += len(file.Content)
+		}
+		p.reporter.ReportMountedObject(ctx, keyVaultObject.ObjectType, keyVaultObject.ObjectName, bytes)
+		files = append(files, objectFiles...)
+	}
+
+	// the file names of the objects are already checked before the fetch, but the files generated from
+	// the content, e.g. the .crt and .key files of a certificate or the metadata files, can still conflict
+	// with another object. The last write for the same path silently wins in the mount, so fail the mount instead.
+	if err := validateSecretFilePaths(files, p.caseInsensitivePaths); err != nil {
+		return nil, invalidConfigError(err)
+	}
+	return files, nil
+}
+
+// getObjectFiles fetches all the resolved versions of the key vault object and returns the files
+// to be written for the object. Either all the files for the object are returned or none.
+func (p *provider) getObjectFiles(ctx context.Context, kvClient KeyVault, keyVaultObject types.KeyVaultObject, defaultFilePermission os.FileMode) ([]types.SecretFile, error) {
+	resolvedKvObjects, err := p.resolveObjectVersions(ctx, kvClient, keyVaultObject)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []types.SecretFile{}
+	metadata := []versionMetadata{}
+	for _, resolvedKvObject := range resolvedKvObjects {
+		// fetch the object from Key Vault
+		result, err := p.getKeyVaultObjectContent(ctx, kvClient, resolvedKvObject)
 		if err != nil {
 			return nil, err
 		}
+		if len(result) > 0 {
+			m := result[len(result)-1].metadata
+			m.File = resolvedKvObject.GetFileName()
+			metadata = append(metadata, m)
+		}
 
-		for _, resolvedKvObject := range resolvedKvObjects {
-			// fetch the object from Key Vault
-			result, err := p.getKeyVaultObjectContent(ctx, kvClient, resolvedKvObject)
+		for idx := range result {
+			r := result[idx]
+			objectContent, err := getContentBytes(r.content, resolvedKvObject.ObjectType, resolvedKvObject.ObjectEncoding)
 			if err != nil {
-				return nil, err
+				// the content in key vault doesn't match the objectEncoding in the SecretProviderClass
+				return nil, invalidConfigError(wrapObjectTypeError(err, resolvedKvObject.ObjectType, resolvedKvObject.ObjectName, resolvedKvObject.ObjectVersion))
 			}
 
-			for idx := range result {
-				r := result[idx]
-				objectContent, err := getContentBytes(r.content, resolvedKvObject.ObjectType, resolvedKvObject.ObjectEncoding)
-				if err != nil {
-					return nil, err
-				}
-
-				// objectUID is a unique identifier in the format <object type>/<object name>
-				// This is the object id the user sees in the SecretProviderClassPodStatus
-				objectUID := resolvedKvObject.GetObjectUID()
-				file := types.SecretFile{
-					Path:    resolvedKvObject.GetFileName() + r.fileNameSuffix,
-					Content: objectContent,
-					UID:     objectUID,
-					Version: r.version,
-				}
-				// the validity of file permission is already checked in the validate function above
-				file.FileMode, _ = resolvedKvObject.GetFilePermission(defaultFilePermission)
+			// objectUID is a unique identifier in the format <object type>/<object name>
+			// This is the object id the user sees in the SecretProviderClassPodStatus
+			objectUID := resolvedKvObject.GetObjectUID()
+			file := types.SecretFile{
+				Path:    resolvedKvObject.GetFileName() + r.fileNameSuffix,
+				Content: objectContent,
+				UID:     objectUID,
+				Version: r.version,
+			}
+			setFileAttributes(&file, resolvedKvObject, defaultFilePermission)
 
+			files = append(files, file)
+		}
+
+		// the selected metadata files of the version are reported with the UID and version of the version
+		if len(result) > 0 {
+			for _, file := range getSelectedMetadataFiles(resolvedKvObject, result[len(result)-1].metadata) {
+				file.UID = resolvedKvObject.GetObjectUID()
+				file.Version = result[len(result)-1].version
+				setFileAttributes(&file, resolvedKvObject, defaultFilePermission)
 				files = append(files, file)
-				klog.V(5).InfoS("added file to the gRPC response", "file", file.Path, "pod", klog.ObjectRef{Namespace: podNamespace, Name: podName})
 			}
 		}
 	}
+	// a required object is never dropped silently from the mount
+	if len(files) == 0 {
+		err = errors.Errorf("no version of the object was fetched")
+		return nil, newError(ErrorCodeNotFound, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion))
+	}
 
+	// the current version and metadata files are reported with the UID and version of the current version,
+	// so the files don't add an object to the SecretProviderClassPodStatus
+	extraFiles := []*types.SecretFile{getCurrentFile(keyVaultObject, resolvedKvObjects)}
+	metadataFile, err := getMetadataFile(keyVaultObject, metadata)
+	if err != nil {
+		return nil, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion)
+	}
+	extraFiles = append(extraFiles, metadataFile)
+	for _, file := range extraFiles {
+		if file == nil {
+			continue
+		}
+		file.UID = files[0].UID
+		file.Version = files[0].Version
+		setFileAttributes(file, keyVaultObject, defaultFilePermission)
+		files = append(files, *file)
+	}
 	return files, nil
 }
 
+// setFileAttributes sets the file mode, owner, group and SELinux context of the object to the file
+func setFileAttributes(file *types.SecretFile, kvObject types.KeyVaultObject, defaultFilePermission os.FileMode) {
+	// the validity of file permission, owner and group is already checked in the validate function
+	file.FileMode, _ = kvObject.GetFilePermission(defaultFilePermission)
+	file.FileOwner, _ = kvObject.GetFileOwner()
+	file.FileGroup, _ = kvObject.GetFileGroup()
+	file.SELinuxContext = kvObject.FileSELinuxContext
+}
+
 func (p *provider) resolveObjectVersions(ctx context.Context, kvClient KeyVault, kvObject types.KeyVaultObject) (versions []types.KeyVaultObject, err error) {
-	if kvObject.IsSyncingSingleVersion() {
+	// the validity of the version constraints is already checked in the validate function
+	constraints, _ := kvObject.GetObjectVersionConstraints()
+	if kvObject.IsSyncingSingleVersion() && constraints == nil {
 		// version history less than or equal to 1 means only sync the latest and
 		// don't add anything to the file name
 		return []types.KeyVaultObject{kvObject}, nil
 	}
 
+	ctx, span := tracing.StartSpan(ctx, "ResolveObjectVersions",
+		tracing.ObjectTypeKey.String(kvObject.ObjectType),
+		tracing.ObjectNameKey.String(kvObject.ObjectName))
+	defer func() { tracing.EndSpan(span, err) }()
+
 	kvObjectVersions, err := p.getKeyVaultObjectVersions(ctx, kvClient, kvObject)
 	if err != nil {
 		return nil, err
 	}
+	kvObjectVersions = filterKeyVaultObjectVersions(kvObjectVersions, constraints, kvObject.ObjectVersionIncludeUnusable, time.Now())
 
-	return getLatestNKeyVaultObjects(kvObject, kvObjectVersions), nil
+	if constraints != nil && len(kvObjectVersions) == 0 {
+		err = errors.Errorf("no usable version matches the version constraints")
+		return nil, newError(ErrorCodeNotFound, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion))
+	}
+	if constraints != nil && kvObject.IsSyncingSingleVersion() {
+		// sync the latest version that matches the constraints to the file of the object
+		sort.Sort(kvObjectVersions)
+		kvObject.ObjectVersion = kvObjectVersions[0].Version
+		return []types.KeyVaultObject{kvObject}, nil
+	}
+	objects := getLatestNKeyVaultObjects(kvObject, kvObjectVersions)
+	// all the versions are filtered out, or the pinned version is, so the object would be missing from the mount
+	if len(objects) == 0 {
+		if kvObject.ObjectVersion != "" && kvObject.ObjectVersion != "latest" {
+			err = errors.Errorf("version %s doesn't exist or isn't usable", kvObject.ObjectVersion)
+		} else {
+			err = errors.Errorf("no usable version")
+		}
+		return nil, newError(ErrorCodeNotFound, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion))
+	}
+	return objects, nil
+}
+
+// filterKeyVaultObjectVersions returns the versions that match the constraints at the given time.
+// The versions that are disabled, not active yet or expired are skipped unless includeUnusable is set.
+func filterKeyVaultObjectVersions(kvObjectVersions types.KeyVaultObjectVersionList, constraints *types.ObjectVersionConstraints, includeUnusable bool, now time.Time) types.KeyVaultObjectVersionList {
+	filtered := types.KeyVaultObjectVersionList{}
+	for _, objectVersion := range kvObjectVersions {
+		if !includeUnusable && !objectVersion.IsUsable(now) {
+			continue
+		}
+		if constraints != nil && !constraints.Matches(objectVersion, now) {
+			continue
+		}
+		filtered = append(filtered, objectVersion)
+	}
+	return filtered
 }
 
 /*
@@ -285,8 +881,8 @@ Given a base key vault object and a list of object versions and their created da
 the latest kvObject.ObjectVersionHistory versions and return key vault objects with the
 appropriate alias and version.
 
-The alias is determine by the index of the version starting with 0 at the specified version (or
-latest if no version is specified).
+The alias is determined by objectVersionHistoryLayout. By default it's the index of the version
+starting with 0 at the specified version (or latest if no version is specified).
 */
 func getLatestNKeyVaultObjects(kvObject types.KeyVaultObject, kvObjectVersions types.KeyVaultObjectVersionList) []types.KeyVaultObject {
 	baseFileName := kvObject.GetFileName()
@@ -304,7 +900,7 @@ func getLatestNKeyVaultObjects(kvObject types.KeyVaultObject, kvObjectVersions t
 			length := len(objects)
 			newObject := kvObject
 
-			newObject.ObjectAlias = filepath.Join(baseFileName, strconv.Itoa(length))
+			newObject.ObjectAlias = filepath.Join(baseFileName, getVersionFileName(kvObject.ObjectVersionHistoryLayout, length, objectVersion))
 			newObject.ObjectVersion = objectVersion.Version
 
 			objects = append(objects, newObject)
@@ -319,6 +915,12 @@ func getLatestNKeyVaultObjects(kvObject types.KeyVaultObject, kvObjectVersions t
 }
 
 func (p *provider) getKeyVaultObjectVersions(ctx context.Context, kvClient KeyVault, kvObject types.KeyVaultObject) (versions types.KeyVaultObjectVersionList, err error) {
+	ctx, span := tracing.StartSpan(ctx, "GetObjectVersions",
+		tracing.ObjectTypeKey.String(kvObject.ObjectType),
+		tracing.ObjectNameKey.String(kvObject.ObjectName),
+		tracing.ObjectVersionKey.String(kvObject.ObjectVersion))
+	defer func() { tracing.EndSpan(span, err) }()
+
 	start := time.Now()
 	defer func() {
 		var errMsg string
@@ -355,6 +957,12 @@ func getCertificateVersions(ctx context.Context, kvClient KeyVault, kvObject typ
 
 // getKeyVaultObjectContent gets content of the keyvault object
 func (p *provider) getKeyVaultObjectContent(ctx context.Context, kvClient KeyVault, kvObject types.KeyVaultObject) (result []keyvaultObject, err error) {
+	ctx, span := tracing.StartSpan(ctx, "GetObjectContent",
+		tracing.ObjectTypeKey.String(kvObject.ObjectType),
+		tracing.ObjectNameKey.String(kvObject.ObjectName),
+		tracing.ObjectVersionKey.String(kvObject.ObjectVersion))
+	defer func() { tracing.EndSpan(span, err) }()
+
 	start := time.Now()
 	defer func() {
 		var errMsg string
@@ -392,6 +1000,11 @@ func (p *provider) getSecret(ctx context.Context, kvClient KeyVault, kvObject ty
 	content := *secret.Value
 	id := *secret.ID
 	version := id.Version()
+	var attributes azsecrets.SecretAttributes
+	if secret.Attributes != nil {
+		attributes = *secret.Attributes
+	}
+	metadata := newVersionMetadata(version, attributes.Created, attributes.Updated, attributes.NotBefore, attributes.Expires, secret.ContentType, secret.Tags)
 	result := []keyvaultObject{}
 	// if the secret is part of a certificate, then we need to convert the certificate and key to PEM format
 	if secret.Kid != nil && len(*secret.Kid) > 0 {
@@ -417,13 +1030,13 @@ func (p *provider) getSecret(ctx context.Context, kvClient KeyVault, kvObject ty
 			// contains the cert and key in a single file to maintain backward compatibility with the existing behavior.
 			cert, key := splitCertAndKey(content)
 			result = append(result,
-				keyvaultObject{version: version, content: cert, fileNameSuffix: ".crt"},
-				keyvaultObject{version: version, content: key, fileNameSuffix: ".key"},
+				keyvaultObject{version: version, content: cert, fileNameSuffix: ".crt", metadata: metadata},
+				keyvaultObject{version: version, content: key, fileNameSuffix: ".key", metadata: metadata},
 			)
 		}
 	}
 
-	result = append(result, keyvaultObject{content: content, version: version})
+	result = append(result, keyvaultObject{content: content, version: version, metadata: metadata})
 	return result, nil
 }
 
@@ -442,6 +1055,11 @@ func (p *provider) getKey(ctx context.Context, kvClient KeyVault, kvObject types
 
 	id := *keybundle.Key.KID
 	version := id.Version()
+	var attributes azkeys.KeyAttributes
+	if keybundle.Attributes != nil {
+		attributes = *keybundle.Attributes
+	}
+	metadata := newVersionMetadata(version, attributes.Created, attributes.Updated, attributes.NotBefore, attributes.Expires, nil, keybundle.Tags)
 	// for object type "key" the public key is written to the file in PEM format
 	switch *keybundle.Key.Kty {
 	case azkeys.JSONWebKeyTypeRSA, azkeys.JSONWebKeyTypeRSAHSM:
@@ -463,7 +1081,7 @@ func (p *provider) getKey(ctx context.Context, kvClient KeyVault, kvObject types
 		}
 		var pemData []byte
 		pemData = append(pemData, pem.EncodeToMemory(pubKeyBlock)...)
-		return []keyvaultObject{{content: string(pemData), version: version}}, nil
+		return []keyvaultObject{{content: string(pemData), version: version, metadata: metadata}}, nil
 	case azkeys.JSONWebKeyTypeEC, azkeys.JSONWebKeyTypeECHSM:
 		xb := keybundle.Key.X
 		yb := keybundle.Key.Y
@@ -487,7 +1105,7 @@ func (p *provider) getKey(ctx context.Context, kvClient KeyVault, kvObject types
 		}
 		var pemData []byte
 		pemData = append(pemData, pem.EncodeToMemory(pubKeyBlock)...)
-		return []keyvaultObject{{content: string(pemData), version: version}}, nil
+		return []keyvaultObject{{content: string(pemData), version: version, metadata: metadata}}, nil
 	default:
 		err := errors.Errorf("failed to get key. key type '%s' currently not supported", *keybundle.Key.Kty)
 		return nil, wrapObjectTypeError(err, kvObject.ObjectType, kvObject.ObjectName, kvObject.ObjectVersion)
@@ -510,6 +1128,11 @@ func (p *provider) getCertificate(ctx context.Context, kvClient KeyVault, kvObje
 
 	id := *certbundle.ID
 	version := id.Version()
+	var attributes azcertificates.CertificateAttributes
+	if certbundle.Attributes != nil {
+		attributes = *certbundle.Attributes
+	}
+	metadata := newVersionMetadata(version, attributes.Created, attributes.Updated, attributes.NotBefore, attributes.Expires, nil, certbundle.Tags)
 
 	certBlock := &pem.Block{
 		Type:  types.CertificateType,
@@ -517,7 +1140,7 @@ func (p *provider) getCertificate(ctx context.Context, kvClient KeyVault, kvObje
 	}
 	var pemData []byte
 	pemData = append(pemData, pem.EncodeToMemory(certBlock)...)
-	return []keyvaultObject{{content: string(pemData), version: version}}, nil
+	return []keyvaultObject{{content: string(pemData), version: version, metadata: metadata}}, nil
 }
 
 func wrapObjectTypeError(err error, objectType, objectName, objectVersion string) error {
@@ -606,16 +1229,6 @@ func parsePrivateKey(block []byte) (interface{}, error) {
 	return nil, fmt.Errorf("failed to parse key for type pkcs1, pkcs8 or ec")
 }
 
-// setAzureEnvironmentFilePath sets the AZURE_ENVIRONMENT_FILEPATH env var which is used by
-// go-autorest for AZURESTACKCLOUD
-func setAzureEnvironmentFilePath(envFileName string) error {
-	if envFileName == "" {
-		return nil
-	}
-	klog.V(5).InfoS("setting AZURE_ENVIRONMENT_FILEPATH for custom cloud", "fileName", envFileName)
-	return os.Setenv(azure.EnvironmentFilepathName, envFileName)
-}
-
 // getContentBytes takes the given content string and returns the bytes to write to disk
 // If an encoding is specified it will decode the string first
 func getContentBytes(content, objectType, objectEncoding string) ([]byte, error) {
@@ -639,18 +1252,23 @@ func formatKeyVaultObject(object *types.KeyVaultObject) {
 	if object == nil {
 		return
 	}
-	objectPtr := reflect.ValueOf(object)
-	objectValue := objectPtr.Elem()
-
-	for i := 0; i < objectValue.NumField(); i++ {
-		field := objectValue.Field(i)
-		if field.Type() != reflect.TypeOf("") {
-			continue
-		}
-		str := field.Interface().(string)
-		str = strings.TrimSpace(str)
-		field.SetString(str)
+	object.ObjectName = strings.TrimSpace(object.ObjectName)
+	object.ObjectAlias = strings.TrimSpace(object.ObjectAlias)
+	object.ObjectVersion = strings.TrimSpace(object.ObjectVersion)
+	object.ObjectVersionHistoryLayout = strings.TrimSpace(object.ObjectVersionHistoryLayout)
+	for i := range object.MetadataFiles {
+		object.MetadataFiles[i] = strings.TrimSpace(object.MetadataFiles[i])
 	}
+	object.ObjectVersionNotBefore = strings.TrimSpace(object.ObjectVersionNotBefore)
+	object.ObjectVersionNotAfter = strings.TrimSpace(object.ObjectVersionNotAfter)
+	object.ObjectVersionMinAge = strings.TrimSpace(object.ObjectVersionMinAge)
+	object.ObjectType = strings.TrimSpace(object.ObjectType)
+	object.ObjectFormat = strings.TrimSpace(object.ObjectFormat)
+	object.ObjectEncoding = strings.TrimSpace(object.ObjectEncoding)
+	object.FilePermission = strings.TrimSpace(object.FilePermission)
+	object.FileOwner = strings.TrimSpace(object.FileOwner)
+	object.FileGroup = strings.TrimSpace(object.FileGroup)
+	object.FileSELinuxContext = strings.TrimSpace(object.FileSELinuxContext)
 }
 
 type node struct {
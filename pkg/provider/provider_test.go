@@ -6,27 +6,38 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
-	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
 	"k8s.io/klog/v2"
 
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/audit"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/breaker"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cache"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cloud"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/policy"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_keyvault"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
 )
@@ -34,7 +45,7 @@ import (
 func TestGetVaultURL(t *testing.T) {
 	testEnvs := []string{"", "AZUREPUBLICCLOUD", "AZURECHINACLOUD", "AZUREGERMANCLOUD", "AZUREUSGOVERNMENTCLOUD"}
 	vaultDNSSuffix := []string{"vault.azure.net", "vault.azure.net", "vault.azure.cn", "vault.microsoftazure.de", "vault.usgovcloudapi.net"}
-	testProvider := provider{defaultCloudEnvironment: azure.PublicCloud}
+	testProvider := provider{defaultCloudEnvironment: cloud.PublicCloud}
 
 	cases := []struct {
 		desc        string
@@ -87,9 +98,241 @@ func TestGetVaultURL(t *testing.T) {
 	}
 }
 
+func TestGetCustomVaultURL(t *testing.T) {
+	cases := []struct {
+		desc                string
+		keyvaultURL         string
+		expectedURL         string
+		expectedIsVaultHost bool
+		expectedErr         bool
+	}{
+		{
+			desc:        "invalid scheme",
+			keyvaultURL: "http://testkv.vault.azure.net/",
+			expectedErr: true,
+		},
+		{
+			desc:        "missing host",
+			keyvaultURL: "https:///",
+			expectedErr: true,
+		},
+		{
+			desc:        "url with path",
+			keyvaultURL: "https://testkv.vault.azure.net/secrets",
+			expectedErr: true,
+		},
+		{
+			desc:                "vault domain without trailing slash",
+			keyvaultURL:         "https://testkv.vault.azure.net",
+			expectedURL:         "https://testkv.vault.azure.net/",
+			expectedIsVaultHost: true,
+		},
+		{
+			desc:        "custom dns name",
+			keyvaultURL: "https://keyvault.contoso.internal/",
+			expectedURL: "https://keyvault.contoso.internal/",
+		},
+		{
+			desc:        "ip address with port",
+			keyvaultURL: "https://10.0.0.4:8443",
+			expectedURL: "https://10.0.0.4:8443/",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			mc := &mountConfig{
+				keyvaultURL:           tc.keyvaultURL,
+				azureCloudEnvironment: cloud.PublicCloud,
+			}
+			vaultURL, err := mc.getVaultURL()
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				return
+			}
+			if *vaultURL != tc.expectedURL {
+				t.Fatalf("expected vault url: %s, got: %s", tc.expectedURL, *vaultURL)
+			}
+			if got := mc.isVaultDomain(*vaultURL); got != tc.expectedIsVaultHost {
+				t.Fatalf("expected isVaultDomain: %v, got: %v", tc.expectedIsVaultHost, got)
+			}
+		})
+	}
+}
+
+func TestGetSecretsStoreObjectContentChallengeResourceVerification(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	cases := []struct {
+		desc             string
+		disable          string
+		allowDisable     bool
+		expectedDisabled bool
+		expectedErr      bool
+	}{
+		{
+			desc: "verification kept for a custom vault url without opt-in",
+		},
+		{
+			desc:        "opt-in not allowed by the provider",
+			disable:     "true",
+			expectedErr: true,
+		},
+		{
+			desc:             "opt-in allowed by the provider",
+			disable:          "true",
+			allowDisable:     true,
+			expectedDisabled: true,
+		},
+		{
+			desc:         "provider allows but the SecretProviderClass doesn't opt-in",
+			disable:      "false",
+			allowDisable: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			attrib := map[string]string{
+				"keyvaultName":         "testKV",
+				"keyvaultURL":          "https://10.0.0.4",
+				"tenantId":             "tid",
+				"useVMManagedIdentity": "true",
+				"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret`,
+				types.KeyVaultDisableChallengeResourceVerificationParameter: tc.disable,
+			}
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+			var opts []Option
+			if tc.allowDisable {
+				opts = append(opts, WithAllowDisableChallengeResourceVerification())
+			}
+			p := NewProvider(false, false, cloud.PublicCloud, opts...).(*provider)
+			var clientOptions *ClientOptions
+			p.newKeyVaultClient = func(_ context.Context, mc *mountConfig, _ string) (KeyVault, error) {
+				clientOptions = &mc.kvClientOptions
+				return kvClient, nil
+			}
+			if !tc.expectedErr {
+				kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+					&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("secret")}, nil,
+				)
+			}
+
+			_, err := p.GetSecretsStoreObjectContent(testContext(t), attrib, nil, 0644)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				if code := ErrorCodeOf(err); code != ErrorCodePermissionDenied {
+					t.Fatalf("expected error code %s, got %s", ErrorCodePermissionDenied, code)
+				}
+				if clientOptions != nil {
+					t.Fatalf("expected the key vault client to not be created")
+				}
+				return
+			}
+			if clientOptions.DisableChallengeResourceVerification != tc.expectedDisabled {
+				t.Fatalf("expected challenge resource verification disabled: %v, got: %v", tc.expectedDisabled, clientOptions.DisableChallengeResourceVerification)
+			}
+		})
+	}
+}
+
+func TestGetSecretsStoreObjectContentProxyOverride(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	cases := []struct {
+		desc          string
+		parameters    map[string]string
+		allowOverride bool
+		expectedErr   bool
+	}{
+		{
+			desc: "no proxy or CA bundle",
+		},
+		{
+			desc:        "proxy not allowed by the provider",
+			parameters:  map[string]string{types.KeyVaultProxyURLParameter: "http://proxy:3128"},
+			expectedErr: true,
+		},
+		{
+			desc:        "CA bundle not allowed by the provider",
+			parameters:  map[string]string{types.KeyVaultCABundleParameter: "ca"},
+			expectedErr: true,
+		},
+		{
+			desc:          "proxy and CA bundle allowed by the provider",
+			parameters:    map[string]string{types.KeyVaultProxyURLParameter: "http://proxy:3128", types.KeyVaultCABundleParameter: "ca"},
+			allowOverride: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			attrib := map[string]string{
+				"keyvaultName":         "testKV",
+				"tenantId":             "tid",
+				"useVMManagedIdentity": "true",
+				"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret`,
+			}
+			for k, v := range tc.parameters {
+				attrib[k] = v
+			}
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+			var opts []Option
+			if tc.allowOverride {
+				opts = append(opts, WithAllowKeyVaultProxyOverride())
+			}
+			p := NewProvider(false, false, cloud.PublicCloud, opts...).(*provider)
+			var clientOptions *ClientOptions
+			p.newKeyVaultClient = func(_ context.Context, mc *mountConfig, _ string) (KeyVault, error) {
+				clientOptions = &mc.kvClientOptions
+				return kvClient, nil
+			}
+			if !tc.expectedErr {
+				kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+					&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("secret")}, nil,
+				)
+			}
+
+			_, err := p.GetSecretsStoreObjectContent(testContext(t), attrib, nil, 0644)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				// InvalidConfig is returned to the driver as InvalidArgument
+				if code := ErrorCodeOf(err); code != ErrorCodeInvalidConfig {
+					t.Fatalf("expected error code %s, got %s", ErrorCodeInvalidConfig, code)
+				}
+				if clientOptions != nil {
+					t.Fatalf("expected the key vault client to not be created")
+				}
+				return
+			}
+			if clientOptions.ProxyURL != tc.parameters[types.KeyVaultProxyURLParameter] || clientOptions.CABundle != tc.parameters[types.KeyVaultCABundleParameter] {
+				t.Fatalf("unexpected client options: %+v", clientOptions)
+			}
+		})
+	}
+}
+
 func TestParseAzureEnvironment(t *testing.T) {
 	envNamesArray := []string{"AZURECHINACLOUD", "AZUREGERMANCLOUD", "AZUREPUBLICCLOUD", "AZUREUSGOVERNMENTCLOUD", ""}
-	testProvider := provider{defaultCloudEnvironment: azure.PublicCloud}
+	testProvider := provider{defaultCloudEnvironment: cloud.PublicCloud}
 
 	for _, envName := range envNamesArray {
 		azureEnv, err := testProvider.parseAzureEnvironment(envName)
@@ -228,35 +471,87 @@ lKn75l/9h0PwiiPaI0TGKN2O8AwvhGGwDElmFhYtXedbbaST6rbVRDUj
 	}
 }
 
-func TestParseAzureEnvironmentAzureStackCloud(t *testing.T) {
-	testProvider := provider{defaultCloudEnvironment: azure.PublicCloud}
+func TestGetAzureEnvironmentAzureStackCloud(t *testing.T) {
+	testProvider := provider{defaultCloudEnvironment: cloud.PublicCloud}
 	azureStackCloudEnvName := "AZURESTACKCLOUD"
 	file, err := os.CreateTemp("", "ut")
 	defer os.Remove(file.Name())
 	if err != nil {
 		t.Fatalf("expected error to be nil, got: %+v", err)
 	}
-	_, err = io.WriteString(file, fmt.Sprintf(`{"name": "%s"}`, azureStackCloudEnvName))
+	_, err = io.WriteString(file, fmt.Sprintf(`{"name": "%s", "activeDirectoryEndpoint": "https://login.microsoftonline.com/", "keyVaultEndpoint": "https://vault.azure.net/", "keyVaultDNSSuffix": "vault.azure.net"}`, azureStackCloudEnvName))
 	if err != nil {
 		t.Fatalf("expected error to be nil, got: %+v", err)
 	}
-	_, err = testProvider.parseAzureEnvironment(azureStackCloudEnvName)
+	_, err = testProvider.getAzureEnvironment(azureStackCloudEnvName, "", "")
 	if err == nil {
-		t.Fatalf("expected error to be not nil as AZURE_ENVIRONMENT_FILEPATH is not set")
+		t.Fatalf("expected error to be not nil as cloudEnvFileName is not set")
 	}
 
-	err = setAzureEnvironmentFilePath(file.Name())
-	defer os.Unsetenv(azure.EnvironmentFilepathName)
-	if err != nil {
-		t.Fatalf("expected error to be nil, got: %+v", err)
-	}
-	env, err := testProvider.parseAzureEnvironment(azureStackCloudEnvName)
+	env, err := testProvider.getAzureEnvironment(azureStackCloudEnvName, file.Name(), "")
 	if err != nil {
 		t.Fatalf("expected error to be nil, got: %+v", err)
 	}
 	if env.Name != azureStackCloudEnvName {
 		t.Fatalf("expected environment name to be '%s', got: '%s'", azureStackCloudEnvName, env.Name)
 	}
+	if _, ok := os.LookupEnv("AZURE_ENVIRONMENT_FILEPATH"); ok {
+		t.Fatalf("expected AZURE_ENVIRONMENT_FILEPATH to not be set")
+	}
+}
+
+func TestGetAzureEnvironmentInline(t *testing.T) {
+	testProvider := provider{defaultCloudEnvironment: cloud.PublicCloud}
+
+	cases := []struct {
+		desc             string
+		cloudName        string
+		cloudEnvironment string
+		expectedEnv      cloud.Environment
+		expectedErr      bool
+	}{
+		{
+			desc:        "default cloud environment",
+			expectedEnv: cloud.PublicCloud,
+		},
+		{
+			desc:        "cloud environment by name",
+			cloudName:   "AzureChinaCloud",
+			expectedEnv: cloud.ChinaCloud,
+		},
+		{
+			desc:      "inline cloud environment takes precedence over cloud name",
+			cloudName: "AzureChinaCloud",
+			cloudEnvironment: `
+name: MyCloud
+activeDirectoryEndpoint: https://login.mycloud.contoso/
+keyVaultEndpoint: https://vault.mycloud.contoso/
+keyVaultDNSSuffix: vault.mycloud.contoso`,
+			expectedEnv: cloud.Environment{
+				Name:                    "MyCloud",
+				ActiveDirectoryEndpoint: "https://login.mycloud.contoso/",
+				KeyVaultEndpoint:        "https://vault.mycloud.contoso/",
+				KeyVaultDNSSuffix:       "vault.mycloud.contoso",
+			},
+		},
+		{
+			desc:             "inline cloud environment missing endpoints",
+			cloudEnvironment: `{"name": "MyCloud"}`,
+			expectedErr:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			env, err := testProvider.getAzureEnvironment(tc.cloudName, "", tc.cloudEnvironment)
+			if tc.expectedErr && err == nil || !tc.expectedErr && err != nil {
+				t.Fatalf("expected error: %v, got error: %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(env, tc.expectedEnv) {
+				t.Fatalf("expected env: %+v, got: %+v", tc.expectedEnv, env)
+			}
+		})
+	}
 }
 
 func TestGetContentBytes(t *testing.T) {
@@ -599,6 +894,157 @@ func TestGetLatestNKeyVaultObjects(t *testing.T) {
 	}
 }
 
+func TestResolveObjectVersions(t *testing.T) {
+	now := time.Now()
+	notBefore := now.Add(time.Hour)
+	expires := now.Add(-time.Hour)
+	versions := []types.KeyVaultObjectVersion{
+		{Version: "a", Created: now.Add(-10 * time.Minute), Enabled: true},
+		{Version: "b", Created: now.Add(-2 * time.Hour), Enabled: true},
+		{Version: "c", Created: now.Add(-3 * time.Hour), Enabled: true},
+		// not active yet, can't be fetched from key vault
+		{Version: "d", Created: now.Add(-4 * time.Hour), Enabled: true, NotBefore: &notBefore},
+		{Version: "e", Created: now.Add(-48 * time.Hour), Enabled: true},
+		{Version: "f", Created: now.Add(-49 * time.Hour), Enabled: false},
+		{Version: "g", Created: now.Add(-50 * time.Hour), Enabled: true, Expires: &expires},
+	}
+
+	cases := []struct {
+		desc             string
+		kvObject         types.KeyVaultObject
+		expectedVersions []string
+		expectedAliases  []string
+		expectedCode     ErrorCode
+	}{
+		{
+			desc: "single version without constraints doesn't list versions",
+			kvObject: types.KeyVaultObject{
+				ObjectName: "secret1",
+				ObjectType: "secret",
+			},
+			expectedVersions: []string{""},
+			expectedAliases:  []string{""},
+		},
+		{
+			desc: "latest version older than the min age",
+			kvObject: types.KeyVaultObject{
+				ObjectName:          "secret1",
+				ObjectType:          "secret",
+				ObjectVersionMinAge: "1h",
+			},
+			expectedVersions: []string{"b"},
+			expectedAliases:  []string{""},
+		},
+		{
+			desc: "version history skips inactive versions and matches the creation time range",
+			kvObject: types.KeyVaultObject{
+				ObjectName:             "secret1",
+				ObjectType:             "secret",
+				ObjectVersionHistory:   3,
+				ObjectVersionNotBefore: now.Add(-24 * time.Hour).Format(time.RFC3339),
+			},
+			expectedVersions: []string{"a", "b", "c"},
+			expectedAliases:  []string{filepath.Join("secret1", "0"), filepath.Join("secret1", "1"), filepath.Join("secret1", "2")},
+		},
+		{
+			desc: "version history without constraints skips unusable versions",
+			kvObject: types.KeyVaultObject{
+				ObjectName:           "secret1",
+				ObjectType:           "secret",
+				ObjectVersionHistory: 5,
+			},
+			expectedVersions: []string{"a", "b", "c", "e"},
+			expectedAliases:  []string{filepath.Join("secret1", "0"), filepath.Join("secret1", "1"), filepath.Join("secret1", "2"), filepath.Join("secret1", "3")},
+		},
+		{
+			desc: "version history includes unusable versions with the override",
+			kvObject: types.KeyVaultObject{
+				ObjectName:                   "secret1",
+				ObjectType:                   "secret",
+				ObjectVersionHistory:         10,
+				ObjectVersionIncludeUnusable: true,
+			},
+			expectedVersions: []string{"a", "b", "c", "d", "e", "f", "g"},
+			expectedAliases: []string{
+				filepath.Join("secret1", "0"), filepath.Join("secret1", "1"), filepath.Join("secret1", "2"), filepath.Join("secret1", "3"),
+				filepath.Join("secret1", "4"), filepath.Join("secret1", "5"), filepath.Join("secret1", "6"),
+			},
+		},
+		{
+			desc: "no version matches the constraints",
+			kvObject: types.KeyVaultObject{
+				ObjectName:            "secret1",
+				ObjectType:            "secret",
+				ObjectVersionNotAfter: now.Add(-72 * time.Hour).Format(time.RFC3339),
+			},
+			expectedCode: ErrorCodeNotFound,
+		},
+		{
+			desc: "all versions filtered",
+			kvObject: types.KeyVaultObject{
+				ObjectName:           "secret2",
+				ObjectType:           "secret",
+				ObjectVersionHistory: 3,
+			},
+			expectedCode: ErrorCodeNotFound,
+		},
+		{
+			desc: "pinned version filtered",
+			kvObject: types.KeyVaultObject{
+				ObjectName:           "secret1",
+				ObjectType:           "secret",
+				ObjectVersion:        "f",
+				ObjectVersionHistory: 3,
+			},
+			expectedCode: ErrorCodeNotFound,
+		},
+		{
+			desc: "pinned version doesn't exist",
+			kvObject: types.KeyVaultObject{
+				ObjectName:           "secret1",
+				ObjectType:           "secret",
+				ObjectVersion:        "z",
+				ObjectVersionHistory: 3,
+			},
+			expectedCode: ErrorCodeNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+			kvClient.EXPECT().GetSecretVersions(gomock.Any(), "secret1").Return(versions, nil).AnyTimes()
+			// every version of secret2 is disabled, not active yet or expired
+			kvClient.EXPECT().GetSecretVersions(gomock.Any(), "secret2").Return(
+				[]types.KeyVaultObjectVersion{versions[3], versions[5], versions[6]}, nil,
+			).AnyTimes()
+
+			p := &provider{reporter: metrics.NewStatsReporter()}
+			objects, err := p.resolveObjectVersions(testContext(t), kvClient, tc.kvObject)
+			if tc.expectedCode != "" {
+				if ErrorCodeOf(err) != tc.expectedCode {
+					t.Fatalf("expected error code: %v, got: %v", tc.expectedCode, ErrorCodeOf(err))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveObjectVersions() = %v, want nil", err)
+			}
+			actualVersions, actualAliases := []string{}, []string{}
+			for _, object := range objects {
+				actualVersions = append(actualVersions, object.ObjectVersion)
+				actualAliases = append(actualAliases, object.ObjectAlias)
+			}
+			if !reflect.DeepEqual(actualVersions, tc.expectedVersions) || !reflect.DeepEqual(actualAliases, tc.expectedAliases) {
+				t.Fatalf("expected versions %v with aliases %v, got %v with %v", tc.expectedVersions, tc.expectedAliases, actualVersions, actualAliases)
+			}
+		})
+	}
+}
+
 func TestFormatKeyVaultObject(t *testing.T) {
 	cases := []struct {
 		desc                   string
@@ -1124,22 +1570,25 @@ fpTPteqfpl8iGQIhAOo8tpUYiREVSYZu130fN0Gvy4WmJMFAi7JrVeSnZ7uP
 
 func TestGetSecretsStoreObjectContent(t *testing.T) {
 	cases := []struct {
-		desc        string
-		parameters  map[string]string
-		secrets     map[string]string
-		expectedErr bool
+		desc         string
+		parameters   map[string]string
+		secrets      map[string]string
+		expectedErr  bool
+		expectedCode ErrorCode
 	}{
 		{
-			desc:        "keyvault name not provided",
-			parameters:  map[string]string{},
-			expectedErr: true,
+			desc:         "keyvault name not provided",
+			parameters:   map[string]string{},
+			expectedErr:  true,
+			expectedCode: ErrorCodeInvalidConfig,
 		},
 		{
 			desc: "tenantID not provided",
 			parameters: map[string]string{
 				"keyvaultName": "testKV",
 			},
-			expectedErr: true,
+			expectedErr:  true,
+			expectedCode: ErrorCodeInvalidConfig,
 		},
 		{
 			desc: "usePodIdentity not a boolean as expected",
@@ -1148,7 +1597,8 @@ func TestGetSecretsStoreObjectContent(t *testing.T) {
 				"tenantId":       "tid",
 				"usePodIdentity": "tru",
 			},
-			expectedErr: true,
+			expectedErr:  true,
+			expectedCode: ErrorCodeInvalidConfig,
 		},
 		{
 			desc: "useVMManagedIdentity not a boolean as expected",
@@ -1158,7 +1608,18 @@ func TestGetSecretsStoreObjectContent(t *testing.T) {
 				"usePodIdentity":       "false",
 				"useVMManagedIdentity": "tru",
 			},
-			expectedErr: true,
+			expectedErr:  true,
+			expectedCode: ErrorCodeInvalidConfig,
+		},
+		{
+			desc: "invalid failure policy",
+			parameters: map[string]string{
+				"keyvaultName":  "testKV",
+				"tenantId":      "tid",
+				"failurePolicy": "Skip",
+			},
+			expectedErr:  true,
+			expectedCode: ErrorCodeInvalidConfig,
 		},
 		{
 			desc: "invalid cloud name",
@@ -1167,7 +1628,8 @@ func TestGetSecretsStoreObjectContent(t *testing.T) {
 				"tenantId":     "tid",
 				"cloudName":    "AzureCloud",
 			},
-			expectedErr: true,
+			expectedErr:  true,
+			expectedCode: ErrorCodeInvalidConfig,
 		},
 		{
 			desc: "check azure cloud env file path is set",
@@ -1177,7 +1639,8 @@ func TestGetSecretsStoreObjectContent(t *testing.T) {
 				"cloudName":        "AzureStackCloud",
 				"cloudEnvFileName": "/etc/kubernetes/akscustom.json",
 			},
-			expectedErr: true,
+			expectedErr:  true,
+			expectedCode: ErrorCodeInvalidConfig,
 		},
 		{
 			desc: "objects array not set",
@@ -1186,21 +1649,19 @@ func TestGetSecretsStoreObjectContent(t *testing.T) {
 				"tenantId":             "tid",
 				"useVMManagedIdentity": "true",
 			},
-			expectedErr: true,
+			expectedErr:  true,
+			expectedCode: ErrorCodeInvalidConfig,
 		},
 		{
-			desc: "objects not configured as an array",
+			desc: "objects not configured as a list",
 			parameters: map[string]string{
 				"keyvaultName":         "testKV",
 				"tenantId":             "tid",
 				"useVMManagedIdentity": "true",
-				"objects": `
-        - |
-          objectName: secret1
-          objectType: secret
-          objectVersion: ""`,
+				"objects":              "secret1",
 			},
-			expectedErr: true,
+			expectedErr:  true,
+			expectedCode: ErrorCodeInvalidConfig,
 		},
 		{
 			desc: "objects array is empty",
@@ -1227,7 +1688,8 @@ func TestGetSecretsStoreObjectContent(t *testing.T) {
           objectFormat: pkcs
           objectVersion: ""`,
 			},
-			expectedErr: true,
+			expectedErr:  true,
+			expectedCode: ErrorCodeInvalidConfig,
 		},
 		{
 			desc: "invalid object encoding",
@@ -1243,7 +1705,8 @@ func TestGetSecretsStoreObjectContent(t *testing.T) {
           objectEncoding: utf-16
           objectVersion: ""`,
 			},
-			expectedErr: true,
+			expectedErr:  true,
+			expectedCode: ErrorCodeInvalidConfig,
 		},
 		{
 			desc: "error fetching from keyvault",
@@ -1267,11 +1730,14 @@ func TestGetSecretsStoreObjectContent(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.desc, func(t *testing.T) {
-			p := NewProvider(false, false, azure.PublicCloud)
+			p := NewProvider(false, false, cloud.PublicCloud)
 
 			_, err := p.GetSecretsStoreObjectContent(testContext(t), tc.parameters, tc.secrets, 0420)
 			if tc.expectedErr {
 				assert.NotNil(t, err)
+				if tc.expectedCode != "" {
+					assert.Equal(t, tc.expectedCode, ErrorCodeOf(err))
+				}
 			} else {
 				assert.Nil(t, err)
 			}
@@ -1590,8 +2056,9 @@ SIVZww73PTGisLmXfIvKvr8GBA==
 			},
 			expectedKeyVaultObject: []keyvaultObject{
 				{
-					content: "secret1value",
-					version: "v1",
+					content:  "secret1value",
+					version:  "v1",
+					metadata: versionMetadata{Version: "v1"},
 				},
 			},
 		},
@@ -1608,8 +2075,9 @@ SIVZww73PTGisLmXfIvKvr8GBA==
 			},
 			expectedKeyVaultObject: []keyvaultObject{
 				{
-					content: testCert + testPrivateKey,
-					version: "v1",
+					content:  testCert + testPrivateKey,
+					version:  "v1",
+					metadata: versionMetadata{Version: "v1", ContentType: "application/x-pem-file"},
 				},
 			},
 		},
@@ -1627,8 +2095,9 @@ SIVZww73PTGisLmXfIvKvr8GBA==
 			},
 			expectedKeyVaultObject: []keyvaultObject{
 				{
-					content: testPFX,
-					version: "v1",
+					content:  testPFX,
+					version:  "v1",
+					metadata: versionMetadata{Version: "v1", ContentType: "application/x-pkcs12"},
 				},
 			},
 		},
@@ -1646,8 +2115,9 @@ SIVZww73PTGisLmXfIvKvr8GBA==
 			},
 			expectedKeyVaultObject: []keyvaultObject{
 				{
-					content: testPrivateKey + testCert,
-					version: "v1",
+					content:  testPrivateKey + testCert,
+					version:  "v1",
+					metadata: versionMetadata{Version: "v1", ContentType: "application/x-pkcs12"},
 				},
 			},
 		},
@@ -1664,8 +2134,9 @@ SIVZww73PTGisLmXfIvKvr8GBA==
 			},
 			expectedKeyVaultObject: []keyvaultObject{
 				{
-					content: testPrivateKey + testCert,
-					version: "v1",
+					content:  testPrivateKey + testCert,
+					version:  "v1",
+					metadata: versionMetadata{Version: "v1", ContentType: "application/x-pkcs12"},
 				},
 			},
 		},
@@ -1685,16 +2156,19 @@ SIVZww73PTGisLmXfIvKvr8GBA==
 				{
 					content:        testCert,
 					version:        "v1",
+					metadata:       versionMetadata{Version: "v1", ContentType: "application/x-pkcs12"},
 					fileNameSuffix: ".crt",
 				},
 				{
 					content:        testPrivateKey,
 					version:        "v1",
+					metadata:       versionMetadata{Version: "v1", ContentType: "application/x-pkcs12"},
 					fileNameSuffix: ".key",
 				},
 				{
-					content: testPrivateKey + testCert,
-					version: "v1",
+					content:  testPrivateKey + testCert,
+					version:  "v1",
+					metadata: versionMetadata{Version: "v1", ContentType: "application/x-pkcs12"},
 				},
 			},
 		},
@@ -1809,7 +2283,8 @@ func TestGetCertificate(t *testing.T) {
 dGVzdA==
 -----END CERTIFICATE-----
 `,
-			version: "v1",
+			version:  "v1",
+			metadata: versionMetadata{Version: "v1"},
 		},
 	}
 
@@ -1866,6 +2341,818 @@ func TestGetCertificateError(t *testing.T) {
 	}
 }
 
+func TestGetSecretFilesFailurePolicy(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	optional, required := true, false
+
+	cases := []struct {
+		desc          string
+		failurePolicy types.FailurePolicy
+		optional      *bool
+		expectedErr   bool
+		expectedFiles int
+	}{
+		{
+			desc:          "failure policy Fail fails the mount",
+			failurePolicy: types.FailurePolicyFail,
+			expectedErr:   true,
+		},
+		{
+			desc:          "optional object is skipped with failure policy Fail",
+			failurePolicy: types.FailurePolicyFail,
+			optional:      &optional,
+			expectedFiles: 1,
+		},
+		{
+			desc:          "failure policy Ignore skips the object",
+			failurePolicy: types.FailurePolicyIgnore,
+			expectedFiles: 1,
+		},
+		{
+			desc:          "required object fails the mount with failure policy Ignore",
+			failurePolicy: types.FailurePolicyIgnore,
+			optional:      &required,
+			expectedErr:   true,
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := testContext(t)
+
+			p := &provider{reporter: metrics.NewStatsReporter()}
+			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+			kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+				&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("test")}, nil,
+			)
+			kvClient.EXPECT().GetSecret(gomock.Any(), "secret2", "").Return(
+				nil, &azcore.ResponseError{StatusCode: http.StatusNotFound},
+			)
+
+			mc := &mountConfig{failurePolicy: tc.failurePolicy}
+			keyVaultObjects := []types.KeyVaultObject{
+				{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret},
+				{ObjectName: "secret2", ObjectType: types.VaultObjectTypeSecret, Optional: tc.optional},
+			}
+
+			files, err := p.getSecretFiles(ctx, mc, kvClient, keyVaultObjects, 0644)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatalf("getSecretFiles() = nil, want error")
+				}
+				if code := ErrorCodeOf(err); code != ErrorCodeNotFound {
+					t.Fatalf("expected error code: %v, got: %v", ErrorCodeNotFound, code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getSecretFiles() = %v, want nil", err)
+			}
+			if len(files) != tc.expectedFiles {
+				t.Fatalf("expected %d files, got %d", tc.expectedFiles, len(files))
+			}
+			if files[0].Path != "secret1" {
+				t.Fatalf("expected file secret1, got %s", files[0].Path)
+			}
+		})
+	}
+}
+
+func TestGetSecretFilesPathConflict(t *testing.T) {
+	id1 := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	id2 := azsecrets.ID("https://test.vault.azure.net/secrets/secret2/v1")
+
+	cases := []struct {
+		desc                 string
+		alias                string
+		caseInsensitivePaths bool
+		expectedErr          bool
+	}{
+		{
+			desc:        "unique file paths",
+			alias:       "secret2",
+			expectedErr: false,
+		},
+		{
+			desc:        "alias same as the metadata file of another object",
+			alias:       "secret1.contentType",
+			expectedErr: true,
+		},
+		{
+			desc:        "alias in a directory with the same name as the metadata file",
+			alias:       "secret1.contentType/secret2",
+			expectedErr: true,
+		},
+		{
+			desc:                 "alias differs in case on a case sensitive file system",
+			alias:                "SECRET1.CONTENTTYPE",
+			caseInsensitivePaths: false,
+			expectedErr:          false,
+		},
+		{
+			desc:                 "alias differs in case on a case insensitive file system",
+			alias:                "SECRET1.CONTENTTYPE",
+			caseInsensitivePaths: true,
+			expectedErr:          true,
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := testContext(t)
+
+			p := &provider{reporter: metrics.NewStatsReporter(), caseInsensitivePaths: tc.caseInsensitivePaths}
+			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+			kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+				&azsecrets.SecretBundle{ID: &id1, Value: to.StringPtr("test1"), ContentType: to.StringPtr("text/plain")}, nil,
+			)
+			kvClient.EXPECT().GetSecret(gomock.Any(), "secret2", "").Return(
+				&azsecrets.SecretBundle{ID: &id2, Value: to.StringPtr("test2")}, nil,
+			)
+
+			// the files generated from the content are only known after the fetch
+			keyVaultObjects := []types.KeyVaultObject{
+				{ObjectName: "secret1", ObjectType: types.VaultObjectTypeSecret, MetadataFiles: []string{"contentType"}},
+				{ObjectName: "secret2", ObjectType: types.VaultObjectTypeSecret, ObjectAlias: tc.alias},
+			}
+
+			_, err := p.getSecretFiles(ctx, &mountConfig{}, kvClient, keyVaultObjects, 0644)
+			if !tc.expectedErr {
+				if err != nil {
+					t.Fatalf("getSecretFiles() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("getSecretFiles() = nil, want error")
+			}
+			if code := ErrorCodeOf(err); code != ErrorCodeInvalidConfig {
+				t.Fatalf("expected error code: %v, got: %v", ErrorCodeInvalidConfig, code)
+			}
+			if !strings.Contains(err.Error(), "secret/secret1") || !strings.Contains(err.Error(), "secret/secret2") {
+				t.Fatalf("expected error to name both objects, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetSecretsStoreObjectContentPathConflict(t *testing.T) {
+	cases := []struct {
+		desc                 string
+		alias                string
+		caseInsensitivePaths bool
+	}{
+		{
+			desc:  "alias same as another object name",
+			alias: "secret1",
+		},
+		{
+			desc:  "alias in a directory with the same name as another file",
+			alias: "secret1/secret2",
+		},
+		{
+			desc:                 "alias differs in case on a case insensitive file system",
+			alias:                "SECRET1",
+			caseInsensitivePaths: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			attrib := map[string]string{
+				"keyvaultName":         "testKV",
+				"tenantId":             "tid",
+				"useVMManagedIdentity": "true",
+				"objects": fmt.Sprintf(`
+      array:
+        - |
+          objectName: secret1
+          objectType: secret
+        - |
+          objectName: secret2
+          objectType: secret
+          objectAlias: %s`, tc.alias),
+			}
+
+			p := NewProvider(false, false, cloud.PublicCloud).(*provider)
+			p.caseInsensitivePaths = tc.caseInsensitivePaths
+			// the conflict is detected before any call to key vault
+			p.newKeyVaultClient = func(_ context.Context, _ *mountConfig, _ string) (KeyVault, error) {
+				t.Fatalf("unexpected key vault client for conflicting objects")
+				return nil, nil
+			}
+
+			_, err := p.GetSecretsStoreObjectContent(testContext(t), attrib, nil, 0644)
+			if code := ErrorCodeOf(err); code != ErrorCodeInvalidConfig {
+				t.Fatalf("expected error code: %v, got: %v: %v", ErrorCodeInvalidConfig, code, err)
+			}
+			if !strings.Contains(err.Error(), "secret/secret1") || !strings.Contains(err.Error(), "secret/secret2") {
+				t.Fatalf("expected error to name both objects, got: %v", err)
+			}
+		})
+	}
+}
+
+// mountReporter records the outcomes and identity access modes of the mounts
+type mountReporter struct {
+	metrics.StatsReporter
+	mounts []string
+}
+
+func (r *mountReporter) ReportMount(_ context.Context, outcome, authMode, errorCode string) {
+	r.mounts = append(r.mounts, strings.Join([]string{outcome, authMode, errorCode}, "/"))
+}
+
+func TestGetSecretsStoreObjectContentLastKnownGoodCache(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	attrib := map[string]string{
+		"keyvaultName":         "testKV",
+		"tenantId":             "tid",
+		"useVMManagedIdentity": "true",
+		"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret`,
+		types.CSIAttributePodName:             "pod1",
+		types.CSIAttributePodNamespace:        "default",
+		types.CSIAttributeSecretProviderClass: "spc1",
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := testContext(t)
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+
+	dir := t.TempDir()
+	lastKnownGoodCache, err := cache.New(dir, filepath.Join(dir, "key"), time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	p := NewProvider(false, false, cloud.PublicCloud, WithLastKnownGoodCache(lastKnownGoodCache)).(*provider)
+	p.newKeyVaultClient = func(_ context.Context, _ *mountConfig, _ string) (KeyVault, error) {
+		return kvClient, nil
+	}
+	reporter := &mountReporter{StatsReporter: p.reporter}
+	p.reporter = reporter
+
+	// successful mount stores the content in the cache
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("test")}, nil,
+	)
+	expectedFiles, err := p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644)
+	if err != nil {
+		t.Fatalf("GetSecretsStoreObjectContent() = %v, want nil", err)
+	}
+
+	// key vault unavailable serves the cached content
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		nil, &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable},
+	)
+	files, err := p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644)
+	if err != nil {
+		t.Fatalf("GetSecretsStoreObjectContent() = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Fatalf("GetSecretsStoreObjectContent() = %v, want %v", files, expectedFiles)
+	}
+
+	// non transient errors are not masked by the cache
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		nil, &azcore.ResponseError{StatusCode: http.StatusNotFound},
+	)
+	if _, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644); ErrorCodeOf(err) != ErrorCodeNotFound {
+		t.Fatalf("expected error code: %v, got: %v", ErrorCodeNotFound, ErrorCodeOf(err))
+	}
+
+	// key vault unreachable serves the cached content to the other pods with the same parameters and identity
+	attrib[types.CSIAttributePodName] = "pod2"
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		nil, &url.Error{Op: "Get", URL: "https://testkv.vault.azure.net/secrets/secret1/", Err: syscall.ECONNREFUSED},
+	)
+	files, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644)
+	if err != nil {
+		t.Fatalf("GetSecretsStoreObjectContent() = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Fatalf("GetSecretsStoreObjectContent() = %v, want %v", files, expectedFiles)
+	}
+
+	// no cached content for the identity returns the error
+	attrib["userAssignedIdentityID"] = "clientid"
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		nil, &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable},
+	)
+	if _, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644); ErrorCodeOf(err) != ErrorCodeUnavailable {
+		t.Fatalf("expected error code: %v, got: %v", ErrorCodeUnavailable, ErrorCodeOf(err))
+	}
+
+	// no cached content for the namespace returns the error
+	delete(attrib, "userAssignedIdentityID")
+	attrib[types.CSIAttributePodNamespace] = "other"
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		nil, &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable},
+	)
+	if _, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644); ErrorCodeOf(err) != ErrorCodeUnavailable {
+		t.Fatalf("expected error code: %v, got: %v", ErrorCodeUnavailable, ErrorCodeOf(err))
+	}
+
+	expectedMounts := []string{
+		"success/managedIdentity/",
+		"stale/managedIdentity/",
+		"failure/managedIdentity/NotFound",
+		"stale/managedIdentity/",
+		"failure/managedIdentity/Unavailable",
+		"failure/managedIdentity/Unavailable",
+	}
+	if !reflect.DeepEqual(reporter.mounts, expectedMounts) {
+		t.Fatalf("expected mounts %v, got %v", expectedMounts, reporter.mounts)
+	}
+}
+
+func TestGetSecretsStoreObjectContentCircuitBreaker(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	attrib := map[string]string{
+		"keyvaultName":         "testKV",
+		"tenantId":             "tid",
+		"useVMManagedIdentity": "true",
+		"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret`,
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := testContext(t)
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+
+	circuitBreaker, err := breaker.New(breaker.Config{FailureThreshold: 2, OpenDuration: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to create circuit breaker: %v", err)
+	}
+	p := NewProvider(false, false, cloud.PublicCloud, WithCircuitBreaker(circuitBreaker), WithKeyVaultClient(func(string) (KeyVault, error) {
+		return kvClient, nil
+	}))
+
+	// object not found doesn't open the circuit of the vault
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		nil, &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "SecretNotFound"},
+	).Times(2)
+	for i := 0; i < 2; i++ {
+		if _, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644); ErrorCodeOf(err) != ErrorCodeNotFound {
+			t.Fatalf("expected error code: %v, got: %v", ErrorCodeNotFound, ErrorCodeOf(err))
+		}
+	}
+
+	// access denied to the object doesn't open the circuit of the vault
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		nil, newForbiddenError(t, "ForbiddenByRbac"),
+	).Times(2)
+	for i := 0; i < 2; i++ {
+		if _, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644); ErrorCodeOf(err) != ErrorCodePermissionDenied {
+			t.Fatalf("expected error code: %v, got: %v", ErrorCodePermissionDenied, ErrorCodeOf(err))
+		}
+	}
+
+	// access denied to the vault opens the circuit after the threshold
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		nil, newForbiddenError(t, "ForbiddenByFirewall"),
+	).Times(2)
+	for i := 0; i < 2; i++ {
+		if _, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644); ErrorCodeOf(err) != ErrorCodePermissionDenied {
+			t.Fatalf("expected error code: %v, got: %v", ErrorCodePermissionDenied, ErrorCodeOf(err))
+		}
+	}
+
+	// the open circuit returns the cached error without calling key vault
+	_, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644)
+	var openErr *breaker.OpenError
+	if !errors.As(err, &openErr) || ErrorCodeOf(err) != ErrorCodePermissionDenied {
+		t.Fatalf("expected open circuit error with code %v, got: %v", ErrorCodePermissionDenied, err)
+	}
+	expectedKey := breaker.Key{Vault: "testKV.vault.azure.net", Identity: "managedIdentity/"}
+	if openErr.Key != expectedKey {
+		t.Fatalf("expected circuit key %v, got %v", expectedKey, openErr.Key)
+	}
+
+	// the circuit is per identity
+	attrib["userAssignedIdentityID"] = "clientid"
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("test")}, nil,
+	)
+	if _, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644); err != nil {
+		t.Fatalf("GetSecretsStoreObjectContent() = %v, want nil", err)
+	}
+}
+
+func TestGetSecretsStoreObjectContentAuditLog(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	attrib := map[string]string{
+		"keyvaultName":         "testKV",
+		"tenantId":             "tid",
+		"useVMManagedIdentity": "true",
+		"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret
+          metadataFile: true`,
+		types.CSIAttributePodName:             "pod1",
+		types.CSIAttributePodNamespace:        "default",
+		types.CSIAttributeSecretProviderClass: "spc1",
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := testContext(t)
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLogger, err := audit.New(audit.Config{Path: path, MaxSizeMB: 1})
+	if err != nil {
+		t.Fatalf("failed to create audit logger: %v", err)
+	}
+	p := NewProvider(false, false, cloud.PublicCloud, WithAuditLogger(auditLogger), WithKeyVaultClient(func(string) (KeyVault, error) {
+		return kvClient, nil
+	}))
+
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("supersecretvalue")}, nil,
+	)
+	if _, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644); err != nil {
+		t.Fatalf("GetSecretsStoreObjectContent() = %v, want nil", err)
+	}
+	// failed mounts are not recorded
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		nil, &azcore.ResponseError{StatusCode: http.StatusForbidden},
+	)
+	if _, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644); err == nil {
+		t.Fatalf("GetSecretsStoreObjectContent() = nil, want error")
+	}
+	auditLogger.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	if strings.Contains(string(data), "supersecretvalue") {
+		t.Fatalf("expected the audit log to not contain the content, got %s", data)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	// the secret file and its metadata file are recorded once
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 audit event, got %d: %s", len(lines), data)
+	}
+	var event audit.Event
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatalf("failed to unmarshal audit event: %v", err)
+	}
+	event.Time = time.Time{}
+	expected := audit.Event{
+		PodNamespace:        "default",
+		PodName:             "pod1",
+		SecretProviderClass: "spc1",
+		Vault:               "https://testKV.vault.azure.net/",
+		AuthMode:            "managedIdentity",
+		ObjectType:          "secret",
+		ObjectName:          "secret1",
+		ObjectVersion:       "v1",
+	}
+	if event != expected {
+		t.Fatalf("expected audit event %+v, got %+v", expected, event)
+	}
+}
+
+// deprecatedAuthReporter records the deprecated identity access modes of the mounts
+type deprecatedAuthReporter struct {
+	metrics.StatsReporter
+	modes []string
+}
+
+func (r *deprecatedAuthReporter) ReportDeprecatedAuthMode(_ context.Context, authMode, namespace string) {
+	r.modes = append(r.modes, namespace+"/"+authMode)
+}
+
+func TestGetSecretsStoreObjectContentDisabledAuthModes(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	cases := []struct {
+		desc          string
+		attrib        map[string]string
+		disabled      []string
+		deprecated    []string
+		expectedErr   bool
+		expectedModes []string
+	}{
+		{
+			desc:   "managed identity not deprecated by default",
+			attrib: map[string]string{"useVMManagedIdentity": "true"},
+		},
+		{
+			desc:          "deprecated mode reported",
+			attrib:        map[string]string{"useVMManagedIdentity": "true"},
+			deprecated:    []string{auth.ModePodIdentity, auth.ModeManagedIdentity},
+			expectedModes: []string{"default/managedIdentity"},
+		},
+		{
+			desc:        "managed identity disabled",
+			attrib:      map[string]string{"useVMManagedIdentity": "true"},
+			disabled:    []string{auth.ModeManagedIdentity},
+			expectedErr: true,
+		},
+		{
+			desc:          "pod identity disabled",
+			attrib:        map[string]string{"usePodIdentity": "true"},
+			disabled:      []string{auth.ModePodIdentity, auth.ModeManagedIdentity},
+			expectedErr:   true,
+			expectedModes: []string{"default/podIdentity"},
+		},
+		{
+			desc:        "no deprecated modes",
+			attrib:      map[string]string{"usePodIdentity": "true"},
+			disabled:    []string{auth.ModePodIdentity},
+			deprecated:  []string{},
+			expectedErr: true,
+		},
+		{
+			desc:     "workload identity allowed",
+			attrib:   map[string]string{"clientID": "clientid", types.CSIAttributeServiceAccountTokens: `{"api://AzureADTokenExchange":{"token":"token","expirationTimestamp":"2023-01-01T00:00:00Z"}}`},
+			disabled: []string{auth.ModePodIdentity, auth.ModeManagedIdentity},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			attrib := map[string]string{
+				"keyvaultName": "testKV",
+				"tenantId":     "tid",
+				"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret`,
+				types.CSIAttributePodName:      "pod1",
+				types.CSIAttributePodNamespace: "default",
+			}
+			for k, v := range tc.attrib {
+				attrib[k] = v
+			}
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+			clientCreated := false
+			opts := []Option{WithDisabledAuthModes(tc.disabled...), WithKeyVaultClient(func(string) (KeyVault, error) {
+				clientCreated = true
+				return kvClient, nil
+			})}
+			if tc.deprecated != nil {
+				opts = append(opts, WithDeprecatedAuthModes(tc.deprecated...))
+			}
+			p := NewProvider(false, false, cloud.PublicCloud, opts...).(*provider)
+			reporter := &deprecatedAuthReporter{StatsReporter: p.reporter}
+			p.reporter = reporter
+			if !tc.expectedErr {
+				kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+					&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("secret")}, nil,
+				)
+			}
+
+			_, err := p.GetSecretsStoreObjectContent(testContext(t), attrib, nil, 0644)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				if code := ErrorCodeOf(err); code != ErrorCodePermissionDenied {
+					t.Fatalf("expected error code %s, got %s", ErrorCodePermissionDenied, code)
+				}
+				if clientCreated {
+					t.Fatalf("expected the key vault client to not be created")
+				}
+			}
+			if !reflect.DeepEqual(reporter.modes, tc.expectedModes) {
+				t.Fatalf("expected deprecated modes %v, got %v", tc.expectedModes, reporter.modes)
+			}
+		})
+	}
+}
+
+func TestGetSecretsStoreObjectContentPolicy(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	mountPolicy := &policy.Policy{
+		Rules: []policy.Rule{
+			{
+				Namespaces: []string{"kube-system"},
+				AuthModes:  []string{"managedIdentity"},
+				ClientIDs:  []string{"clientid"},
+				Vaults:     []string{"testKV.vault.azure.net"},
+			},
+		},
+	}
+	cases := []struct {
+		desc        string
+		attrib      map[string]string
+		expectedErr bool
+	}{
+		{
+			desc: "allowed",
+			attrib: map[string]string{
+				"userAssignedIdentityID": "clientid",
+			},
+		},
+		{
+			desc: "node identity denied",
+			attrib: map[string]string{
+				"userAssignedIdentityID": "",
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "namespace denied",
+			attrib: map[string]string{
+				"userAssignedIdentityID":       "clientid",
+				types.CSIAttributePodNamespace: "default",
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "vault denied",
+			attrib: map[string]string{
+				"userAssignedIdentityID": "clientid",
+				"keyvaultName":           "otherKV",
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			attrib := map[string]string{
+				"keyvaultName":         "testKV",
+				"tenantId":             "tid",
+				"useVMManagedIdentity": "true",
+				"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret`,
+				types.CSIAttributePodName:      "pod1",
+				types.CSIAttributePodNamespace: "kube-system",
+			}
+			for k, v := range tc.attrib {
+				attrib[k] = v
+			}
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+			clientCreated := false
+			p := NewProvider(false, false, cloud.PublicCloud, WithPolicy(mountPolicy), WithKeyVaultClient(func(string) (KeyVault, error) {
+				clientCreated = true
+				return kvClient, nil
+			}))
+			if !tc.expectedErr {
+				kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+					&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("secret")}, nil,
+				)
+			}
+
+			_, err := p.GetSecretsStoreObjectContent(testContext(t), attrib, nil, 0644)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				if code := ErrorCodeOf(err); code != ErrorCodePermissionDenied {
+					t.Fatalf("expected error code %s, got %s", ErrorCodePermissionDenied, code)
+				}
+				// the mount is rejected before any token is requested
+				if clientCreated {
+					t.Fatalf("expected the key vault client to not be created")
+				}
+			}
+		})
+	}
+}
+
+func TestGetSecretsStoreObjectContentOptions(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	attrib := map[string]string{
+		"keyvaultName":         "testKV",
+		"tenantId":             "tid",
+		"useVMManagedIdentity": "true",
+		"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret`,
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := testContext(t)
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	newClient := func(vaultURI string) (KeyVault, error) {
+		if vaultURI != "https://testKV.vault.azure.net/" {
+			t.Fatalf("expected vault uri https://testKV.vault.azure.net/, got %s", vaultURI)
+		}
+		return kvClient, nil
+	}
+
+	// the objects are fetched with the replaced key vault client
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("test")}, nil,
+	)
+	p := NewProvider(false, false, cloud.PublicCloud, WithKeyVaultClient(newClient))
+	files, err := p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644)
+	if err != nil {
+		t.Fatalf("GetSecretsStoreObjectContent() = %v, want nil", err)
+	}
+	if len(files) != 1 || string(files[0].Content) != "test" || files[0].Version != "v1" {
+		t.Fatalf("unexpected files: %v", files)
+	}
+
+	// dry run validates the configuration without creating the client
+	p = NewProvider(false, false, cloud.PublicCloud, WithDryRun(), WithKeyVaultClient(func(string) (KeyVault, error) {
+		t.Fatalf("unexpected key vault client for dry run")
+		return nil, nil
+	}))
+	if files, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644); err != nil || len(files) != 0 {
+		t.Fatalf("GetSecretsStoreObjectContent() = %v, %v, want no files and nil error", files, err)
+	}
+
+	attrib["objects"] = `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret
+          objectEncoding: invalid`
+	if _, err = p.GetSecretsStoreObjectContent(ctx, attrib, nil, 0644); ErrorCodeOf(err) != ErrorCodeInvalidConfig {
+		t.Fatalf("expected error code: %v, got: %v", ErrorCodeInvalidConfig, ErrorCodeOf(err))
+	}
+}
+
+func TestGetSecretsStoreObjectContentFileAttributes(t *testing.T) {
+	id := azsecrets.ID("https://test.vault.azure.net/secrets/secret1/v1")
+	attrib := map[string]string{
+		"keyvaultName":         "testKV",
+		"tenantId":             "tid",
+		"useVMManagedIdentity": "true",
+		"objects": `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret
+          fileOwner: "1000"
+          fileGroup: "2000"
+          fileSELinuxContext: system_u:object_r:container_file_t:s0`,
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	kvClient := mock_keyvault.NewMockKeyVault(ctrl)
+	kvClient.EXPECT().GetSecret(gomock.Any(), "secret1", "").Return(
+		&azsecrets.SecretBundle{ID: &id, Value: to.StringPtr("test")}, nil,
+	)
+	p := NewProvider(false, false, cloud.PublicCloud, WithKeyVaultClient(func(string) (KeyVault, error) {
+		return kvClient, nil
+	}))
+	files, err := p.GetSecretsStoreObjectContent(testContext(t), attrib, nil, 0644)
+	if err != nil {
+		t.Fatalf("GetSecretsStoreObjectContent() = %v, want nil", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
+	}
+	if files[0].FileOwner == nil || *files[0].FileOwner != 1000 || files[0].FileGroup == nil || *files[0].FileGroup != 2000 {
+		t.Fatalf("unexpected file owner and group: %v, %v", files[0].FileOwner, files[0].FileGroup)
+	}
+	if files[0].SELinuxContext != "system_u:object_r:container_file_t:s0" {
+		t.Fatalf("unexpected SELinux context: %s", files[0].SELinuxContext)
+	}
+
+	attrib["objects"] = `
+      array:
+        - |
+          objectName: secret1
+          objectType: secret
+          fileOwner: root`
+	if _, err = p.GetSecretsStoreObjectContent(testContext(t), attrib, nil, 0644); ErrorCodeOf(err) != ErrorCodeInvalidConfig {
+		t.Fatalf("expected error code: %v, got: %v", ErrorCodeInvalidConfig, ErrorCodeOf(err))
+	}
+}
+
 func testContext(t *testing.T) context.Context {
 	ctx, cancel := context.WithCancel(context.Background())
 	t.Cleanup(cancel)
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 	"k8s.io/klog/v2"
@@ -60,6 +62,11 @@ func GetCloudEnvFileName(parameters map[string]string) string {
 	return strings.TrimSpace(parameters[CloudEnvFileNameParameter])
 }
 
+// GetCloudEnvironment returns the inline cloud environment
+func GetCloudEnvironment(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[CloudEnvironmentParameter])
+}
+
 // GetPodName returns the pod name
 func GetPodName(parameters map[string]string) string {
 	return strings.TrimSpace(parameters[CSIAttributePodName])
@@ -80,11 +87,65 @@ func GetServiceAccountTokens(parameters map[string]string) string {
 	return strings.TrimSpace(parameters[CSIAttributeServiceAccountTokens])
 }
 
+// GetServiceAccountName returns the name of the service account of the pod
+func GetServiceAccountName(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[CSIAttributeServiceAccountName])
+}
+
 // GetObjects returns the key vault objects
 func GetObjects(parameters map[string]string) string {
 	return strings.TrimSpace(parameters[ObjectsParameter])
 }
 
+// GetKeyVaultURL returns the key vault URL
+func GetKeyVaultURL(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[KeyVaultURLParameter])
+}
+
+// GetKeyVaultTLSServerName returns the key vault TLS server name
+func GetKeyVaultTLSServerName(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[KeyVaultTLSServerNameParameter])
+}
+
+// GetKeyVaultProxyURL returns the key vault proxy URL
+func GetKeyVaultProxyURL(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[KeyVaultProxyURLParameter])
+}
+
+// GetKeyVaultCABundle returns the key vault CA bundle
+func GetKeyVaultCABundle(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[KeyVaultCABundleParameter])
+}
+
+// GetKeyVaultDisableChallengeResourceVerification returns true if the verification of the key vault
+// authentication challenge resource is disabled
+func GetKeyVaultDisableChallengeResourceVerification(parameters map[string]string) (bool, error) {
+	str := strings.TrimSpace(parameters[KeyVaultDisableChallengeResourceVerificationParameter])
+	if str == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(str)
+}
+
+// GetSecretProviderClassName returns the name of the SecretProviderClass
+func GetSecretProviderClassName(parameters map[string]string) string {
+	return strings.TrimSpace(parameters[CSIAttributeSecretProviderClass])
+}
+
+// GetFailurePolicy returns the failure policy for the objects. The default
+// failure policy is Fail.
+func GetFailurePolicy(parameters map[string]string) (FailurePolicy, error) {
+	str := strings.TrimSpace(parameters[FailurePolicyParameter])
+	switch {
+	case str == "", strings.EqualFold(str, string(FailurePolicyFail)):
+		return FailurePolicyFail, nil
+	case strings.EqualFold(str, string(FailurePolicyIgnore)):
+		return FailurePolicyIgnore, nil
+	default:
+		return "", fmt.Errorf("invalid failurePolicy: %s, should be %s or %s", str, FailurePolicyFail, FailurePolicyIgnore)
+	}
+}
+
 // GetObjectsArray returns the key vault objects array
 func GetObjectsArray(objects string) (StringArray, error) {
 	var a StringArray
@@ -92,6 +153,147 @@ func GetObjectsArray(objects string) (StringArray, error) {
 	return a, err
 }
 
+// ObjectEntry is an object in the objects parameter of the SecretProviderClass
+type ObjectEntry struct {
+	node *yaml.Node
+	err  error
+}
+
+// knownObjectFields are the fields of the KeyVaultObject in the objects parameter
+var knownObjectFields = func() []string {
+	t := reflect.TypeOf(KeyVaultObject{})
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		fields = append(fields, strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0])
+	}
+	return fields
+}()
+
+// GetObjectEntries returns the entries of the objects parameter. The objects are accepted
+// as the array of YAML strings:
+//
+//	array:
+//	  - |
+//	    objectName: secret1
+//	    objectType: secret
+//
+// or as a native YAML or JSON list of objects:
+//
+//   - objectName: secret1
+//     objectType: secret
+//
+// The entries of the array can also be native objects. The line numbers in the errors are
+// the lines in the objects parameter.
+func GetObjectEntries(objects string) ([]ObjectEntry, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(objects), &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	var items []*yaml.Node
+	switch root.Kind {
+	case yaml.SequenceNode:
+		items = root.Content
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			key, value := root.Content[i], root.Content[i+1]
+			if key.Value != "array" {
+				return nil, fmt.Errorf("line %d: field %s not found, objects should be a list or set in the array field", key.Line, key.Value)
+			}
+			switch {
+			case value.Kind == yaml.SequenceNode:
+				items = value.Content
+			case value.Tag == "!!null":
+			default:
+				return nil, fmt.Errorf("line %d: array should be a list of objects", value.Line)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("line %d: objects should be a list or set in the array field", root.Line)
+	}
+
+	entries := make([]ObjectEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, newObjectEntry(item))
+	}
+	return entries, nil
+}
+
+// newObjectEntry returns the entry for the object node. An object in a YAML string is
+// parsed and the lines are offset by the line of the string in the objects parameter.
+func newObjectEntry(item *yaml.Node) ObjectEntry {
+	if item.Kind != yaml.ScalarNode || item.Tag != "!!str" {
+		return ObjectEntry{node: item}
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(item.Value), &doc); err != nil {
+		return ObjectEntry{err: fmt.Errorf("object at line %d: %w", item.Line, err)}
+	}
+	if len(doc.Content) == 0 {
+		return ObjectEntry{err: fmt.Errorf("line %d: object is empty", item.Line)}
+	}
+	// the content of a block scalar starts on the line after the indicator
+	offset := item.Line - 1
+	if item.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		offset = item.Line
+	}
+	offsetLines(doc.Content[0], offset)
+	return ObjectEntry{node: doc.Content[0]}
+}
+
+// offsetLines adds the offset to the lines of the node and its children
+func offsetLines(node *yaml.Node, offset int) {
+	node.Line += offset
+	for _, child := range node.Content {
+		offsetLines(child, offset)
+	}
+}
+
+// Decode decodes the entry into the key vault object. Unknown fields are returned as errors.
+func (e ObjectEntry) Decode() (KeyVaultObject, error) {
+	var kv KeyVaultObject
+	if e.err != nil {
+		return kv, e.err
+	}
+	if e.node.Kind != yaml.MappingNode {
+		return kv, fmt.Errorf("line %d: object should be a mapping of the object fields", e.node.Line)
+	}
+
+	var errs []string
+	for i := 0; i+1 < len(e.node.Content); i += 2 {
+		key := e.node.Content[i]
+		if err := checkObjectField(key); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return kv, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	if err := e.node.Decode(&kv); err != nil {
+		return kv, err
+	}
+	return kv, nil
+}
+
+// checkObjectField returns an error if the field is not a field of the KeyVaultObject
+func checkObjectField(key *yaml.Node) error {
+	for _, field := range knownObjectFields {
+		if key.Value == field {
+			return nil
+		}
+	}
+	for _, field := range knownObjectFields {
+		if strings.EqualFold(key.Value, field) {
+			return fmt.Errorf("line %d: field %s not found, did you mean %s", key.Line, key.Value, field)
+		}
+	}
+	return fmt.Errorf("line %d: field %s not found", key.Line, key.Value)
+}
+
 // IsSyncingSingleVersion returns true if the object is configured
 // to only sync a single specific version of the secret
 func (kv KeyVaultObject) IsSyncingSingleVersion() bool {
@@ -100,7 +302,7 @@ func (kv KeyVaultObject) IsSyncingSingleVersion() bool {
 
 // GetObjectUID returns UID for the object with the format:
 // <object type>/<object name> if syncing a single version
-// <object type/<object name>/<version index> if syncing multiple versions
+// <object type/<object name>/<version file name> if syncing multiple versions
 func (kv KeyVaultObject) GetObjectUID() string {
 	if kv.IsSyncingSingleVersion() {
 		return fmt.Sprintf("%s/%s", kv.ObjectType, kv.ObjectName)
@@ -133,3 +335,84 @@ func (kv KeyVaultObject) GetFilePermission(defaultFilePermission os.FileMode) (i
 	}
 	return int32(permission), nil
 }
+
+// GetFileOwner returns the numeric user id of the file owner. nil is returned if the owner is not set.
+func (kv KeyVaultObject) GetFileOwner() (*int64, error) {
+	return parseFileID(kv.FileOwner, "file owner")
+}
+
+// GetFileGroup returns the numeric group id of the file. nil is returned if the group is not set.
+func (kv KeyVaultObject) GetFileGroup() (*int64, error) {
+	return parseFileID(kv.FileGroup, "file group")
+}
+
+// parseFileID parses the numeric user or group id
+func parseFileID(value, name string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a numeric id: %w", name, err)
+	}
+	result := int64(id)
+	return &result, nil
+}
+
+// ObjectVersionConstraints are the constraints the versions of the object must match to be synced
+type ObjectVersionConstraints struct {
+	// NotBefore and NotAfter is the range of the creation time of the versions, zero if not set
+	NotBefore time.Time
+	NotAfter  time.Time
+	// MinAge is the duration since the creation of the versions
+	MinAge time.Duration
+}
+
+// GetObjectVersionConstraints returns the version constraints of the object. nil is returned
+// if no constraint is set.
+func (kv KeyVaultObject) GetObjectVersionConstraints() (*ObjectVersionConstraints, error) {
+	if kv.ObjectVersionNotBefore == "" && kv.ObjectVersionNotAfter == "" && kv.ObjectVersionMinAge == "" {
+		return nil, nil
+	}
+	constraints := &ObjectVersionConstraints{}
+	var err error
+	if kv.ObjectVersionNotBefore != "" {
+		if constraints.NotBefore, err = time.Parse(time.RFC3339, kv.ObjectVersionNotBefore); err != nil {
+			return nil, fmt.Errorf("invalid objectVersionNotBefore: %s, should be in the RFC 3339 format", kv.ObjectVersionNotBefore)
+		}
+	}
+	if kv.ObjectVersionNotAfter != "" {
+		if constraints.NotAfter, err = time.Parse(time.RFC3339, kv.ObjectVersionNotAfter); err != nil {
+			return nil, fmt.Errorf("invalid objectVersionNotAfter: %s, should be in the RFC 3339 format", kv.ObjectVersionNotAfter)
+		}
+	}
+	if !constraints.NotBefore.IsZero() && !constraints.NotAfter.IsZero() && constraints.NotAfter.Before(constraints.NotBefore) {
+		return nil, fmt.Errorf("objectVersionNotAfter: %s is before objectVersionNotBefore: %s", kv.ObjectVersionNotAfter, kv.ObjectVersionNotBefore)
+	}
+	if kv.ObjectVersionMinAge != "" {
+		if constraints.MinAge, err = time.ParseDuration(kv.ObjectVersionMinAge); err != nil || constraints.MinAge < 0 {
+			return nil, fmt.Errorf("invalid objectVersionMinAge: %s, should be a duration such as 1h", kv.ObjectVersionMinAge)
+		}
+	}
+	return constraints, nil
+}
+
+// Matches returns true if the version matches the constraints at the given time
+func (c *ObjectVersionConstraints) Matches(version KeyVaultObjectVersion, now time.Time) bool {
+	if !c.NotBefore.IsZero() && version.Created.Before(c.NotBefore) {
+		return false
+	}
+	if !c.NotAfter.IsZero() && version.Created.After(c.NotAfter) {
+		return false
+	}
+	return now.Sub(version.Created) >= c.MinAge
+}
+
+// IsOptional returns true if the object can be skipped when it can't be fetched.
+// The optional field of the object takes precedence over the failure policy.
+func (kv KeyVaultObject) IsOptional(failurePolicy FailurePolicy) bool {
+	if kv.Optional != nil {
+		return *kv.Optional
+	}
+	return failurePolicy == FailurePolicyIgnore
+}
@@ -4,7 +4,9 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetKeyVaultName(t *testing.T) {
@@ -516,6 +518,45 @@ func TestGetServiceAccountTokens(t *testing.T) {
 	}
 }
 
+func TestGetServiceAccountName(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   string
+	}{
+		{
+			name: "empty",
+			parameters: map[string]string{
+				CSIAttributeServiceAccountName: "",
+			},
+			expected: "",
+		},
+		{
+			name: "not empty",
+			parameters: map[string]string{
+				CSIAttributeServiceAccountName: "test",
+			},
+			expected: "test",
+		},
+		{
+			name: "trim spaces",
+			parameters: map[string]string{
+				CSIAttributeServiceAccountName: " test ",
+			},
+			expected: "test",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := GetServiceAccountName(test.parameters)
+			if actual != test.expected {
+				t.Errorf("GetServiceAccountName() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
 func TestGetObjects(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -555,6 +596,300 @@ func TestGetObjects(t *testing.T) {
 	}
 }
 
+func TestGetCloudEnvironment(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   string
+	}{
+		{
+			name: "empty",
+			parameters: map[string]string{
+				CloudEnvironmentParameter: "",
+			},
+			expected: "",
+		},
+		{
+			name: "not empty",
+			parameters: map[string]string{
+				CloudEnvironmentParameter: "{}",
+			},
+			expected: "{}",
+		},
+		{
+			name: "trim spaces",
+			parameters: map[string]string{
+				CloudEnvironmentParameter: " {} ",
+			},
+			expected: "{}",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := GetCloudEnvironment(test.parameters)
+			if actual != test.expected {
+				t.Errorf("GetCloudEnvironment() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetKeyVaultURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   string
+	}{
+		{
+			name: "empty",
+			parameters: map[string]string{
+				KeyVaultURLParameter: "",
+			},
+			expected: "",
+		},
+		{
+			name: "not empty",
+			parameters: map[string]string{
+				KeyVaultURLParameter: "https://kv.example.com/",
+			},
+			expected: "https://kv.example.com/",
+		},
+		{
+			name: "trim spaces",
+			parameters: map[string]string{
+				KeyVaultURLParameter: " https://kv.example.com/ ",
+			},
+			expected: "https://kv.example.com/",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := GetKeyVaultURL(test.parameters)
+			if actual != test.expected {
+				t.Errorf("GetKeyVaultURL() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetKeyVaultTLSServerName(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   string
+	}{
+		{
+			name: "empty",
+			parameters: map[string]string{
+				KeyVaultTLSServerNameParameter: "",
+			},
+			expected: "",
+		},
+		{
+			name: "not empty",
+			parameters: map[string]string{
+				KeyVaultTLSServerNameParameter: "kv.vault.azure.net",
+			},
+			expected: "kv.vault.azure.net",
+		},
+		{
+			name: "trim spaces",
+			parameters: map[string]string{
+				KeyVaultTLSServerNameParameter: " kv.vault.azure.net ",
+			},
+			expected: "kv.vault.azure.net",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := GetKeyVaultTLSServerName(test.parameters)
+			if actual != test.expected {
+				t.Errorf("GetKeyVaultTLSServerName() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetKeyVaultProxyURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   string
+	}{
+		{
+			name: "empty",
+			parameters: map[string]string{
+				KeyVaultProxyURLParameter: "",
+			},
+			expected: "",
+		},
+		{
+			name: "not empty",
+			parameters: map[string]string{
+				KeyVaultProxyURLParameter: "http://proxy:3128",
+			},
+			expected: "http://proxy:3128",
+		},
+		{
+			name: "trim spaces",
+			parameters: map[string]string{
+				KeyVaultProxyURLParameter: " http://proxy:3128 ",
+			},
+			expected: "http://proxy:3128",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := GetKeyVaultProxyURL(test.parameters)
+			if actual != test.expected {
+				t.Errorf("GetKeyVaultProxyURL() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetKeyVaultCABundle(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   string
+	}{
+		{
+			name: "empty",
+			parameters: map[string]string{
+				KeyVaultCABundleParameter: "",
+			},
+			expected: "",
+		},
+		{
+			name: "not empty",
+			parameters: map[string]string{
+				KeyVaultCABundleParameter: "test",
+			},
+			expected: "test",
+		},
+		{
+			name: "trim spaces",
+			parameters: map[string]string{
+				KeyVaultCABundleParameter: " test ",
+			},
+			expected: "test",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := GetKeyVaultCABundle(test.parameters)
+			if actual != test.expected {
+				t.Errorf("GetKeyVaultCABundle() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetKeyVaultDisableChallengeResourceVerification(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expected    bool
+		expectedErr bool
+	}{
+		{
+			name:       "empty",
+			parameters: map[string]string{},
+			expected:   false,
+		},
+		{
+			name: "set to true",
+			parameters: map[string]string{
+				KeyVaultDisableChallengeResourceVerificationParameter: " true ",
+			},
+			expected: true,
+		},
+		{
+			name: "invalid",
+			parameters: map[string]string{
+				KeyVaultDisableChallengeResourceVerificationParameter: "test",
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := GetKeyVaultDisableChallengeResourceVerification(test.parameters)
+			if test.expectedErr != (err != nil) {
+				t.Fatalf("GetKeyVaultDisableChallengeResourceVerification() error = %v, expected error: %v", err, test.expectedErr)
+			}
+			if actual != test.expected {
+				t.Errorf("GetKeyVaultDisableChallengeResourceVerification() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetFailurePolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   FailurePolicy
+	}{
+		{
+			name: "empty",
+			parameters: map[string]string{
+				FailurePolicyParameter: "",
+			},
+			expected: FailurePolicyFail,
+		},
+		{
+			name: "set to Fail",
+			parameters: map[string]string{
+				FailurePolicyParameter: "Fail",
+			},
+			expected: FailurePolicyFail,
+		},
+		{
+			name: "set to Ignore",
+			parameters: map[string]string{
+				FailurePolicyParameter: "Ignore",
+			},
+			expected: FailurePolicyIgnore,
+		},
+		{
+			name: "case insensitive and trim spaces",
+			parameters: map[string]string{
+				FailurePolicyParameter: " ignore ",
+			},
+			expected: FailurePolicyIgnore,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := GetFailurePolicy(test.parameters)
+			if err != nil {
+				t.Errorf("GetFailurePolicy() error = %v, expected nil", err)
+			}
+			if actual != test.expected {
+				t.Errorf("GetFailurePolicy() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetFailurePolicyError(t *testing.T) {
+	parameters := map[string]string{
+		FailurePolicyParameter: "Skip",
+	}
+	if _, err := GetFailurePolicy(parameters); err == nil {
+		t.Errorf("GetFailurePolicy() error = nil, expected error")
+	}
+}
+
 func TestGetObjectsArray(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -598,6 +933,165 @@ func TestGetObjectsArrayError(t *testing.T) {
 	}
 }
 
+func TestGetObjectEntries(t *testing.T) {
+	expected := []KeyVaultObject{
+		{ObjectName: "secret1", ObjectType: "secret"},
+		{ObjectName: "cert1", ObjectType: "cert", ObjectVersionHistory: 2},
+	}
+
+	tests := []struct {
+		name     string
+		objects  string
+		expected []KeyVaultObject
+	}{
+		{
+			name:     "empty",
+			objects:  "",
+			expected: []KeyVaultObject{},
+		},
+		{
+			name:     "empty array",
+			objects:  "array:",
+			expected: []KeyVaultObject{},
+		},
+		{
+			name:     "array of yaml strings",
+			objects:  "array:\n  - |\n    objectName: secret1\n    objectType: secret\n  - |\n    objectName: cert1\n    objectType: cert\n    objectVersionHistory: 2\n",
+			expected: expected,
+		},
+		{
+			name:     "array of native objects",
+			objects:  "array:\n  - objectName: secret1\n    objectType: secret\n  - |\n    objectName: cert1\n    objectType: cert\n    objectVersionHistory: 2\n",
+			expected: expected,
+		},
+		{
+			name:     "native yaml list",
+			objects:  "- objectName: secret1\n  objectType: secret\n- objectName: cert1\n  objectType: cert\n  objectVersionHistory: 2\n",
+			expected: expected,
+		},
+		{
+			name:     "json list",
+			objects:  `[{"objectName": "secret1", "objectType": "secret"}, {"objectName": "cert1", "objectType": "cert", "objectVersionHistory": 2}]`,
+			expected: expected,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := GetObjectEntries(test.objects)
+			if err != nil {
+				t.Fatalf("GetObjectEntries() error = %v", err)
+			}
+			actual := []KeyVaultObject{}
+			for _, entry := range entries {
+				kv, err := entry.Decode()
+				if err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				actual = append(actual, kv)
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("GetObjectEntries() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetObjectEntriesError(t *testing.T) {
+	tests := []struct {
+		name        string
+		objects     string
+		expectedErr string
+	}{
+		{
+			name:        "invalid yaml",
+			objects:     "array: [",
+			expectedErr: "line 1",
+		},
+		{
+			name:        "scalar",
+			objects:     "invalid",
+			expectedErr: "line 1: objects should be a list or set in the array field",
+		},
+		{
+			name:        "unknown field in the objects",
+			objects:     "array:\n  - |\n    objectName: secret1\nitems: []",
+			expectedErr: "line 4: field items not found",
+		},
+		{
+			name:        "array is not a list",
+			objects:     "array: secret1",
+			expectedErr: "line 1: array should be a list of objects",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := GetObjectEntries(test.objects)
+			if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("GetObjectEntries() error = %v, expected %q", err, test.expectedErr)
+			}
+		})
+	}
+}
+
+func TestObjectEntryDecodeError(t *testing.T) {
+	tests := []struct {
+		name        string
+		objects     string
+		expectedErr string
+	}{
+		{
+			name:        "misspelled field in yaml string",
+			objects:     "array:\n  - |\n    objectName: secret1\n    objectAliass: alias\n",
+			expectedErr: "line 4: field objectAliass not found",
+		},
+		{
+			name:        "field with invalid case in yaml string",
+			objects:     "array:\n  - |\n    objectName: secret1\n    objecttype: secret\n",
+			expectedErr: "line 4: field objecttype not found, did you mean objectType",
+		},
+		{
+			name:        "unknown field in native list",
+			objects:     "- objectName: secret1\n  objectType: secret\n  version: v1\n",
+			expectedErr: "line 3: field version not found",
+		},
+		{
+			name:        "invalid field type",
+			objects:     "- objectName: secret1\n  objectType: secret\n  objectVersionHistory: latest\n",
+			expectedErr: "line 3",
+		},
+		{
+			name:        "invalid yaml string",
+			objects:     "array:\n  - |\n    objectName: [\n",
+			expectedErr: "object at line 2",
+		},
+		{
+			name:        "object is not a mapping",
+			objects:     "- secret1\n- [secret2]\n",
+			expectedErr: "line 2: object should be a mapping of the object fields",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := GetObjectEntries(test.objects)
+			if err != nil {
+				t.Fatalf("GetObjectEntries() error = %v", err)
+			}
+			var decodeErr error
+			for _, entry := range entries {
+				if _, err := entry.Decode(); err != nil {
+					decodeErr = err
+				}
+			}
+			if decodeErr == nil || !strings.Contains(decodeErr.Error(), test.expectedErr) {
+				t.Errorf("Decode() error = %v, expected %q", decodeErr, test.expectedErr)
+			}
+		})
+	}
+}
+
 func TestIsSyncingSingleVersion(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -812,3 +1306,268 @@ func TestGetFilePermissionError(t *testing.T) {
 		})
 	}
 }
+
+func TestGetFileOwnerAndGroup(t *testing.T) {
+	cases := []struct {
+		name          string
+		object        KeyVaultObject
+		expectedOwner *int64
+		expectedGroup *int64
+	}{
+		{
+			name:   "owner and group not set",
+			object: KeyVaultObject{},
+		},
+		{
+			name: "owner and group set",
+			object: KeyVaultObject{
+				FileOwner: "1000",
+				FileGroup: "0",
+			},
+			expectedOwner: int64Ptr(1000),
+			expectedGroup: int64Ptr(0),
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			owner, err := test.object.GetFileOwner()
+			if err != nil {
+				t.Errorf("GetFileOwner() error = %v", err)
+			}
+			if !reflect.DeepEqual(owner, test.expectedOwner) {
+				t.Errorf("GetFileOwner() = %v, expected %v", owner, test.expectedOwner)
+			}
+			group, err := test.object.GetFileGroup()
+			if err != nil {
+				t.Errorf("GetFileGroup() error = %v", err)
+			}
+			if !reflect.DeepEqual(group, test.expectedGroup) {
+				t.Errorf("GetFileGroup() = %v, expected %v", group, test.expectedGroup)
+			}
+		})
+	}
+}
+
+func TestGetFileOwnerAndGroupError(t *testing.T) {
+	for _, value := range []string{"root", "-1", "4294967296"} {
+		t.Run(value, func(t *testing.T) {
+			object := KeyVaultObject{FileOwner: value, FileGroup: value}
+			if _, err := object.GetFileOwner(); err == nil {
+				t.Errorf("GetFileOwner() error = nil, expected error")
+			}
+			if _, err := object.GetFileGroup(); err == nil {
+				t.Errorf("GetFileGroup() error = nil, expected error")
+			}
+		})
+	}
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func TestGetObjectVersionConstraints(t *testing.T) {
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		object   KeyVaultObject
+		expected *ObjectVersionConstraints
+	}{
+		{
+			name:   "constraints not set",
+			object: KeyVaultObject{},
+		},
+		{
+			name: "all constraints set",
+			object: KeyVaultObject{
+				ObjectVersionNotBefore: "2024-01-01T00:00:00Z",
+				ObjectVersionNotAfter:  "2024-02-01T00:00:00Z",
+				ObjectVersionMinAge:    "1h",
+			},
+			expected: &ObjectVersionConstraints{NotBefore: notBefore, NotAfter: notAfter, MinAge: time.Hour},
+		},
+		{
+			name: "min age set",
+			object: KeyVaultObject{
+				ObjectVersionMinAge: "30m",
+			},
+			expected: &ObjectVersionConstraints{MinAge: 30 * time.Minute},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := test.object.GetObjectVersionConstraints()
+			if err != nil {
+				t.Errorf("GetObjectVersionConstraints() error = %v", err)
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("GetObjectVersionConstraints() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetObjectVersionConstraintsError(t *testing.T) {
+	cases := []struct {
+		name   string
+		object KeyVaultObject
+	}{
+		{
+			name:   "invalid not before",
+			object: KeyVaultObject{ObjectVersionNotBefore: "2024-01-01"},
+		},
+		{
+			name:   "invalid not after",
+			object: KeyVaultObject{ObjectVersionNotAfter: "yesterday"},
+		},
+		{
+			name: "not after before not before",
+			object: KeyVaultObject{
+				ObjectVersionNotBefore: "2024-02-01T00:00:00Z",
+				ObjectVersionNotAfter:  "2024-01-01T00:00:00Z",
+			},
+		},
+		{
+			name:   "invalid min age",
+			object: KeyVaultObject{ObjectVersionMinAge: "1d"},
+		},
+		{
+			name:   "negative min age",
+			object: KeyVaultObject{ObjectVersionMinAge: "-1h"},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.object.GetObjectVersionConstraints(); err == nil {
+				t.Errorf("GetObjectVersionConstraints() error = nil, expected error")
+			}
+		})
+	}
+}
+
+func TestObjectVersionConstraintsMatches(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	constraints := &ObjectVersionConstraints{
+		NotBefore: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		MinAge:    time.Hour,
+	}
+
+	cases := []struct {
+		name     string
+		created  time.Time
+		expected bool
+	}{
+		{
+			name:     "created in the range and older than min age",
+			created:  time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			expected: true,
+		},
+		{
+			name:    "created before not before",
+			created: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "created in the range but newer than min age",
+			created: now.Add(-30 * time.Minute),
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := constraints.Matches(KeyVaultObjectVersion{Created: test.created}, now); actual != test.expected {
+				t.Errorf("Matches() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestKeyVaultObjectVersionIsUsable(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	cases := []struct {
+		name     string
+		version  KeyVaultObjectVersion
+		expected bool
+	}{
+		{
+			name:     "enabled without activation and expiry",
+			version:  KeyVaultObjectVersion{Enabled: true},
+			expected: true,
+		},
+		{
+			name:    "disabled",
+			version: KeyVaultObjectVersion{},
+		},
+		{
+			name:     "active and not expired",
+			version:  KeyVaultObjectVersion{Enabled: true, NotBefore: &past, Expires: &future},
+			expected: true,
+		},
+		{
+			name:    "not active yet",
+			version: KeyVaultObjectVersion{Enabled: true, NotBefore: &future},
+		},
+		{
+			name:    "expired",
+			version: KeyVaultObjectVersion{Enabled: true, Expires: &past},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.version.IsUsable(now); actual != test.expected {
+				t.Errorf("IsUsable() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestIsOptional(t *testing.T) {
+	optional, required := true, false
+	cases := []struct {
+		name          string
+		object        KeyVaultObject
+		failurePolicy FailurePolicy
+		expected      bool
+	}{
+		{
+			name:          "optional not set with failure policy Fail",
+			object:        KeyVaultObject{},
+			failurePolicy: FailurePolicyFail,
+			expected:      false,
+		},
+		{
+			name:          "optional not set with failure policy Ignore",
+			object:        KeyVaultObject{},
+			failurePolicy: FailurePolicyIgnore,
+			expected:      true,
+		},
+		{
+			name:          "optional overrides failure policy Fail",
+			object:        KeyVaultObject{Optional: &optional},
+			failurePolicy: FailurePolicyFail,
+			expected:      true,
+		},
+		{
+			name:          "required overrides failure policy Ignore",
+			object:        KeyVaultObject{Optional: &required},
+			failurePolicy: FailurePolicyIgnore,
+			expected:      false,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.object.IsOptional(test.failurePolicy); actual != test.expected {
+				t.Errorf("IsOptional() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
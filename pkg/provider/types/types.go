@@ -22,12 +22,25 @@ const (
 	ObjectEncodingBase64 = "base64"
 	ObjectEncodingUtf8   = "utf-8"
 
+	// VersionHistoryLayoutIndex names the version files by the index starting with 0 at the latest version
+	VersionHistoryLayoutIndex = "index"
+	// VersionHistoryLayoutVersion names the version files by the version id
+	VersionHistoryLayoutVersion = "version"
+	// VersionHistoryLayoutCreated names the version files by the creation time of the version
+	VersionHistoryLayoutCreated = "created"
+
 	// pod identity NMI port
 	PodIdentityNMIPort = "2579"
 
+	// CSIAttributePrefix is the prefix of the pod attributes set by the driver in the mount attributes
+	CSIAttributePrefix = "csi.storage.k8s.io/"
+
 	CSIAttributePodName              = "csi.storage.k8s.io/pod.name"
 	CSIAttributePodNamespace         = "csi.storage.k8s.io/pod.namespace"
 	CSIAttributeServiceAccountTokens = "csi.storage.k8s.io/serviceAccount.tokens" // nolint
+	CSIAttributeServiceAccountName   = "csi.storage.k8s.io/serviceAccount.name"
+	// CSIAttributeSecretProviderClass is the name of the SecretProviderClass set by the driver in the mount attributes
+	CSIAttributeSecretProviderClass = "secretProviderClass"
 
 	// KeyVaultNameParameter is the name of the key vault name parameter
 	KeyVaultNameParameter = "keyvaultName"
@@ -45,11 +58,42 @@ const (
 	TenantIDParameter = "tenantId"
 	// CloudEnvFileNameParameter is the name of the cloud env file name parameter
 	CloudEnvFileNameParameter = "cloudEnvFileName"
+	// CloudEnvironmentParameter is the name of the cloud environment parameter
+	// This is used to define the custom cloud environment inline in the SecretProviderClass
+	CloudEnvironmentParameter = "cloudEnvironment"
 	// ClientIDParameter is the name of the client ID parameter
 	// This clientID is used for workload identity
 	ClientIDParameter = "clientID"
 	// ObjectsParameter is the name of the objects parameter
 	ObjectsParameter = "objects"
+	// KeyVaultURLParameter is the name of the key vault URL parameter
+	// When set, this URL is used instead of the one built from the key vault name and cloud DNS suffix
+	KeyVaultURLParameter = "keyvaultURL"
+	// KeyVaultTLSServerNameParameter is the name of the key vault TLS server name parameter
+	// This is used for SNI and certificate verification when the key vault URL is an IP address
+	KeyVaultTLSServerNameParameter = "keyvaultTLSServerName"
+	// KeyVaultProxyURLParameter is the name of the key vault proxy URL parameter
+	KeyVaultProxyURLParameter = "keyvaultProxyURL"
+	// KeyVaultCABundleParameter is the name of the key vault CA bundle parameter
+	// The value is a PEM encoded bundle of CA certificates trusted in addition to the system roots
+	KeyVaultCABundleParameter = "keyvaultCABundle"
+	// KeyVaultDisableChallengeResourceVerificationParameter is the name of the parameter that disables the
+	// verification that the authentication challenge resource matches the domain of the key vault URL.
+	// It's only allowed if the provider is started with --allow-disable-challenge-resource-verification.
+	KeyVaultDisableChallengeResourceVerificationParameter = "keyvaultDisableChallengeResourceVerification"
+	// FailurePolicyParameter is the name of the failure policy parameter
+	// This defines how failures to fetch objects that don't set optional are handled
+	FailurePolicyParameter = "failurePolicy"
+)
+
+// FailurePolicy defines how a failure to fetch an object from key vault is handled
+type FailurePolicy string
+
+const (
+	// FailurePolicyFail fails the mount if the object can't be fetched
+	FailurePolicyFail FailurePolicy = "Fail"
+	// FailurePolicyIgnore skips the object if it can't be fetched and mounts the rest
+	FailurePolicyIgnore FailurePolicy = "Ignore"
 )
 
 // KeyVaultObject holds keyvault object related config
@@ -62,6 +106,19 @@ type KeyVaultObject struct {
 	ObjectVersion string `json:"objectVersion" yaml:"objectVersion"`
 	// The number of versions to load for this secret starting at the latest version
 	ObjectVersionHistory int32 `json:"objectVersionHistory" yaml:"objectVersionHistory"`
+	// ObjectVersionHistoryLayout is the naming of the files in the version history directory.
+	// Supported layouts are index, version and created.
+	ObjectVersionHistoryLayout string `json:"objectVersionHistoryLayout" yaml:"objectVersionHistoryLayout"`
+	// ObjectVersionNotBefore and ObjectVersionNotAfter select the versions created in the
+	// time range. The times are in the RFC 3339 format.
+	ObjectVersionNotBefore string `json:"objectVersionNotBefore" yaml:"objectVersionNotBefore"`
+	ObjectVersionNotAfter  string `json:"objectVersionNotAfter" yaml:"objectVersionNotAfter"`
+	// ObjectVersionMinAge selects the versions created at least the duration ago, e.g. 1h.
+	// It's used to soak a new version before it's synced.
+	ObjectVersionMinAge string `json:"objectVersionMinAge" yaml:"objectVersionMinAge"`
+	// ObjectVersionIncludeUnusable includes the versions that are disabled, not active yet or
+	// expired when the versions are resolved. They're skipped by default.
+	ObjectVersionIncludeUnusable bool `json:"objectVersionIncludeUnusable" yaml:"objectVersionIncludeUnusable"`
 	// the type of the Azure Key Vault objects
 	ObjectType string `json:"objectType" yaml:"objectType"`
 	// the format of the Azure Key Vault objects
@@ -70,8 +127,23 @@ type KeyVaultObject struct {
 	// The encoding of the object in KeyVault
 	// Supported encodings are Base64, Hex, Utf-8
 	ObjectEncoding string `json:"objectEncoding" yaml:"objectEncoding"`
+	// MetadataFile writes the metadata of the synced versions to the <alias>.meta.json file
+	MetadataFile bool `json:"metadataFile" yaml:"metadataFile"`
+	// MetadataFiles selects the metadata of the version written to separate files next to the
+	// file of the version. Supported values are contentType, tags and tag:<tag name>.
+	MetadataFiles []string `json:"metadataFiles" yaml:"metadataFiles"`
 	// FilePermission is the file permissions
 	FilePermission string `json:"filePermission" yaml:"filePermission"`
+	// FileOwner is the numeric user id of the file owner
+	FileOwner string `json:"fileOwner" yaml:"fileOwner"`
+	// FileGroup is the numeric group id of the file
+	FileGroup string `json:"fileGroup" yaml:"fileGroup"`
+	// FileSELinuxContext is the SELinux context of the file in the user:role:type[:level] format
+	FileSELinuxContext string `json:"fileSELinuxContext" yaml:"fileSELinuxContext"`
+	// Optional marks the object as not required for the mount. If the object can't
+	// be fetched, it's skipped instead of failing the mount. When not set, the
+	// failurePolicy of the SecretProviderClass is used.
+	Optional *bool `json:"optional" yaml:"optional"`
 }
 
 // SecretFile holds content and metadata of a secret file that is sent
@@ -82,6 +154,11 @@ type SecretFile struct {
 	FileMode int32
 	UID      string
 	Version  string
+	// FileOwner, FileGroup and SELinuxContext are the ownership and SELinux label hints
+	// for the file. They're applied only if supported by the writer of the files.
+	FileOwner      *int64
+	FileGroup      *int64
+	SELinuxContext string
 }
 
 // StringArray holds a list of strings
@@ -94,6 +171,25 @@ type StringArray struct {
 type KeyVaultObjectVersion struct {
 	Version string
 	Created time.Time
+	// Enabled is false if the version is disabled in key vault
+	Enabled bool
+	// NotBefore is the time the version becomes active, nil if not set
+	NotBefore *time.Time
+	// Expires is the time the version expires, nil if not set
+	Expires *time.Time
+	// Tags are the tags of the version
+	Tags map[string]string
+}
+
+// IsUsable returns true if the version is enabled, active and not expired at the given time
+func (v KeyVaultObjectVersion) IsUsable(now time.Time) bool {
+	if !v.Enabled {
+		return false
+	}
+	if v.NotBefore != nil && v.NotBefore.After(now) {
+		return false
+	}
+	return v.Expires == nil || v.Expires.After(now)
 }
 
 // KeyVaultObjectVersionList holds a list of KeyVaultObjectVersion
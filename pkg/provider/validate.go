@@ -1,24 +1,286 @@
 package provider
 
 import (
+	"crypto/x509"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cloud"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
+// ValidationOptions are the provider settings of the nodes that change the files written for the objects
+type ValidationOptions struct {
+	// WriteCertAndKeyInSeparateFiles is set if the provider writes the .crt and .key files of the certificates
+	WriteCertAndKeyInSeparateFiles bool
+	// CaseInsensitivePaths is set if the files are written to a case-insensitive file system, e.g. on Windows nodes
+	CaseInsensitivePaths bool
+}
+
+// ValidateParameters validates the SecretProviderClass parameters without fetching the
+// objects from key vault. All the errors are returned in an aggregate error.
+func ValidateParameters(parameters map[string]string, opts ValidationOptions) error {
+	var errs []error
+	if keyvaultURL := types.GetKeyVaultURL(parameters); keyvaultURL != "" {
+		if _, err := parseVaultURL(keyvaultURL); err != nil {
+			errs = append(errs, err)
+		}
+	} else if keyvaultName := types.GetKeyVaultName(parameters); keyvaultName != "" {
+		if err := validateVaultName(keyvaultName); err != nil {
+			errs = append(errs, err)
+		}
+	} else {
+		errs = append(errs, fmt.Errorf("keyvaultName is not set"))
+	}
+	if types.GetTenantID(parameters) == "" {
+		errs = append(errs, fmt.Errorf("tenantId is not set"))
+	}
+	if err := validateCloudEnvironment(parameters); err != nil {
+		errs = append(errs, err)
+	}
+	if proxyURL := types.GetKeyVaultProxyURL(parameters); proxyURL != "" {
+		if _, err := parseProxyURL(proxyURL); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if caBundle := types.GetKeyVaultCABundle(parameters); caBundle != "" {
+		if err := appendCABundle(x509.NewCertPool(), caBundle); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := types.GetKeyVaultDisableChallengeResourceVerification(parameters); err != nil {
+		errs = append(errs, fmt.Errorf("failed to parse %s flag, error: %w", types.KeyVaultDisableChallengeResourceVerificationParameter, err))
+	}
+	if _, err := types.GetUsePodIdentity(parameters); err != nil {
+		errs = append(errs, fmt.Errorf("failed to parse usePodIdentity flag, error: %w", err))
+	}
+	if _, err := types.GetUseVMManagedIdentity(parameters); err != nil {
+		errs = append(errs, fmt.Errorf("failed to parse useVMManagedIdentity flag, error: %w", err))
+	}
+	if _, err := types.GetFailurePolicy(parameters); err != nil {
+		errs = append(errs, err)
+	}
+
+	objectsStrings := types.GetObjects(parameters)
+	if objectsStrings == "" {
+		errs = append(errs, fmt.Errorf("objects is not set"))
+		return utilerrors.NewAggregate(errs)
+	}
+	keyVaultObjects, objectErrs := parseKeyVaultObjects(objectsStrings)
+	errs = append(errs, objectErrs...)
+	errs = append(errs, validateFilePaths(keyVaultObjects, opts.WriteCertAndKeyInSeparateFiles, opts.CaseInsensitivePaths)...)
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateCloudEnvironment checks the cloudEnvironment and the cloudName the same way as the mount.
+// The cloud environment file of AzureStackCloud is on the nodes, so only its name is checked.
+func validateCloudEnvironment(parameters map[string]string) error {
+	if cloudEnvironment := types.GetCloudEnvironment(parameters); cloudEnvironment != "" {
+		if _, err := cloud.ParseEnvironment([]byte(cloudEnvironment)); err != nil {
+			return fmt.Errorf("failed to parse cloudEnvironment, error: %w", err)
+		}
+		return nil
+	}
+	cloudName := types.GetCloudName(parameters)
+	if cloudName == "" {
+		return nil
+	}
+	if strings.EqualFold(cloudName, cloud.AzureStackCloudName) {
+		if types.GetCloudEnvFileName(parameters) == "" {
+			return fmt.Errorf("cloudEnvFileName is required for cloudName %s", cloudName)
+		}
+		return nil
+	}
+	if _, err := cloud.EnvironmentFromName(cloudName); err != nil {
+		return fmt.Errorf("cloudName %s is not valid, error: %w", cloudName, err)
+	}
+	return nil
+}
+
+// parseKeyVaultObjects parses and validates the objects in the SecretProviderClass. The
+// valid objects are returned with the errors for all the invalid objects.
+func parseKeyVaultObjects(objectsStrings string) ([]types.KeyVaultObject, []error) {
+	entries, err := types.GetObjectEntries(objectsStrings)
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to yaml unmarshal objects, error: %w", err)}
+	}
+
+	var errs []error
+	keyVaultObjects := []types.KeyVaultObject{}
+	for i, entry := range entries {
+		keyVaultObject, err := entry.Decode()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unmarshal failed for keyVaultObjects at index %d, error: %w", i, err))
+			continue
+		}
+		// remove whitespace from all fields in keyVaultObject
+		formatKeyVaultObject(&keyVaultObject)
+
+		if err = validate(keyVaultObject); err != nil {
+			errs = append(errs, wrapObjectTypeError(err, keyVaultObject.ObjectType, keyVaultObject.ObjectName, keyVaultObject.ObjectVersion))
+			continue
+		}
+		keyVaultObjects = append(keyVaultObjects, keyVaultObject)
+	}
+	return keyVaultObjects, errs
+}
+
 // validate is a helper function to validate the given object
 func validate(kv types.KeyVaultObject) error {
+	if err := validateObjectType(kv.ObjectType); err != nil {
+		return err
+	}
 	if err := validateObjectFormat(kv.ObjectFormat, kv.ObjectType); err != nil {
 		return err
 	}
 	if err := validateObjectEncoding(kv.ObjectEncoding, kv.ObjectType); err != nil {
 		return err
 	}
+	if _, err := kv.GetFilePermission(os.FileMode(0)); err != nil {
+		return err
+	}
+	if _, err := kv.GetFileOwner(); err != nil {
+		return err
+	}
+	if _, err := kv.GetFileGroup(); err != nil {
+		return err
+	}
+	if err := validateSELinuxContext(kv.FileSELinuxContext); err != nil {
+		return err
+	}
+	if err := validateVersionHistoryLayout(kv.ObjectVersionHistoryLayout); err != nil {
+		return err
+	}
+	if err := validateMetadataFiles(kv.MetadataFiles); err != nil {
+		return err
+	}
+	constraints, err := kv.GetObjectVersionConstraints()
+	if err != nil {
+		return err
+	}
+	if constraints != nil && kv.ObjectVersion != "" && kv.ObjectVersion != "latest" {
+		return fmt.Errorf("objectVersion: %s can't be set with objectVersionNotBefore, objectVersionNotAfter or objectVersionMinAge", kv.ObjectVersion)
+	}
 	return validateFileName(kv.GetFileName())
 }
 
+// validateSecretFilePaths checks that every file is written to a distinct path in the mount.
+// A file conflicts with another if they have the same path, or if the path of one file is a
+// directory of the other, e.g. the .crt file of a certificate and an alias with the same name,
+// or the versions directory of objectVersionHistory and an alias in it. The paths are compared
+// case-insensitively when the files are written to a case-insensitive file system.
+func validateSecretFilePaths(files []types.SecretFile, caseInsensitive bool) error {
+	filesByPath := make(map[string]types.SecretFile, len(files))
+	// dirs holds the parent directories of the file paths and the file written in the directory
+	dirs := make(map[string]types.SecretFile)
+	for _, file := range files {
+		path := normalizePath(file.Path, caseInsensitive)
+		if other, ok := filesByPath[path]; ok {
+			return conflictError(other, file)
+		}
+		if other, ok := dirs[path]; ok {
+			return conflictError(other, file)
+		}
+		for dir := filepath.ToSlash(filepath.Dir(path)); dir != "." && dir != "/"; dir = filepath.ToSlash(filepath.Dir(dir)) {
+			if other, ok := filesByPath[dir]; ok {
+				return conflictError(other, file)
+			}
+			if _, ok := dirs[dir]; !ok {
+				dirs[dir] = file
+			}
+		}
+		filesByPath[path] = file
+	}
+	return nil
+}
+
+// normalizePath returns the clean slash-separated path, in lower case on a case-insensitive file system
+func normalizePath(path string, caseInsensitive bool) string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if caseInsensitive {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// conflictError returns the error for the files of two objects that are written to the same path
+func conflictError(file, other types.SecretFile) error {
+	return fmt.Errorf("objects %s and %s conflict on file paths %s and %s, set a unique objectAlias", file.UID, other.UID, file.Path, other.Path)
+}
+
+// validateSELinuxContext checks if the SELinux context has the user:role:type[:level] format
+func validateSELinuxContext(context string) error {
+	if context == "" {
+		return nil
+	}
+	parts := strings.SplitN(context, ":", 4)
+	if len(parts) < 3 {
+		return fmt.Errorf("invalid fileSELinuxContext: %v, should be user:role:type[:level]", context)
+	}
+	for _, part := range parts {
+		if part == "" || strings.ContainsAny(part, " \t\n") {
+			return fmt.Errorf("invalid fileSELinuxContext: %v, should be user:role:type[:level]", context)
+		}
+	}
+	return nil
+}
+
+// validateObjectType checks if the object type is supported
+func validateObjectType(objectType string) error {
+	switch objectType {
+	case types.VaultObjectTypeSecret, types.VaultObjectTypeKey, types.VaultObjectTypeCertificate:
+		return nil
+	default:
+		return fmt.Errorf("invalid objectType: %v, should be secret, key or cert", objectType)
+	}
+}
+
+// validateFilePaths checks that the objects are written to distinct paths in the mount. An
+// object conflicts with another if they have the same file name or if the file name of one
+// object is a directory of the other, e.g. the versions directory of objectVersionHistory.
+// The .crt and .key files of the secrets are included if the cert and key are written in
+// separate files, as the secrets that are part of a certificate are only known after the fetch.
+func validateFilePaths(keyVaultObjects []types.KeyVaultObject, splitCertFiles, caseInsensitive bool) []error {
+	paths := make([][]string, len(keyVaultObjects))
+	for i, kv := range keyVaultObjects {
+		fileName := kv.GetFileName()
+		paths[i] = []string{normalizePath(fileName, caseInsensitive)}
+		// the files of multiple versions are written in the directory of the object
+		constraints, _ := kv.GetObjectVersionConstraints()
+		if splitCertFiles && kv.ObjectType == types.VaultObjectTypeSecret && kv.IsSyncingSingleVersion() && constraints == nil {
+			paths[i] = append(paths[i], normalizePath(fileName+".crt", caseInsensitive), normalizePath(fileName+".key", caseInsensitive))
+		}
+	}
+
+	var errs []error
+	for i := range keyVaultObjects {
+		for j := 0; j < i; j++ {
+			if path, otherPath, ok := conflictingPaths(paths[i], paths[j]); ok {
+				errs = append(errs, fmt.Errorf("objects %s/%s and %s/%s conflict on file paths %s and %s, set a unique objectAlias",
+					keyVaultObjects[j].ObjectType, keyVaultObjects[j].ObjectName, keyVaultObjects[i].ObjectType, keyVaultObjects[i].ObjectName, otherPath, path))
+			}
+		}
+	}
+	return errs
+}
+
+// conflictingPaths returns the first pair of paths of two objects that are the same or where one
+// path is a directory of the other
+func conflictingPaths(paths, otherPaths []string) (string, string, bool) {
+	for _, path := range paths {
+		for _, otherPath := range otherPaths {
+			if path == otherPath || strings.HasPrefix(path, otherPath+"/") || strings.HasPrefix(otherPath, path+"/") {
+				return path, otherPath, true
+			}
+		}
+	}
+	return "", "", false
+}
+
 // validateObjectFormat checks if the object format is valid and is supported
 // for the given object type
 func validateObjectFormat(objectFormat, objectType string) error {
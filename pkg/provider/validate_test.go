@@ -1,8 +1,13 @@
 package provider
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 func TestValidateObjectFormat(t *testing.T) {
@@ -145,3 +150,436 @@ func TestValidateFilePath(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateObjectType(t *testing.T) {
+	cases := []struct {
+		desc        string
+		objectType  string
+		expectedErr error
+	}{
+		{
+			desc:        "object type secret",
+			objectType:  "secret",
+			expectedErr: nil,
+		},
+		{
+			desc:        "object type key",
+			objectType:  "key",
+			expectedErr: nil,
+		},
+		{
+			desc:        "object type cert",
+			objectType:  "cert",
+			expectedErr: nil,
+		},
+		{
+			desc:        "object type not valid",
+			objectType:  "certificate",
+			expectedErr: fmt.Errorf("invalid objectType: certificate, should be secret, key or cert"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateObjectType(tc.objectType)
+			if tc.expectedErr != nil && err.Error() != tc.expectedErr.Error() || tc.expectedErr == nil && err != nil {
+				t.Fatalf("expected err: %+v, got: %+v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateSELinuxContext(t *testing.T) {
+	cases := []struct {
+		desc        string
+		context     string
+		expectedErr bool
+	}{
+		{
+			desc:    "context not set",
+			context: "",
+		},
+		{
+			desc:    "context without level",
+			context: "system_u:object_r:container_file_t",
+		},
+		{
+			desc:    "context with level",
+			context: "system_u:object_r:container_file_t:s0:c1,c2",
+		},
+		{
+			desc:        "context without type",
+			context:     "system_u:object_r",
+			expectedErr: true,
+		},
+		{
+			desc:        "context with empty role",
+			context:     "system_u::container_file_t",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateSELinuxContext(tc.context)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateFilePaths(t *testing.T) {
+	cases := []struct {
+		desc            string
+		objects         []types.KeyVaultObject
+		splitCertFiles  bool
+		caseInsensitive bool
+		expectedErr     bool
+	}{
+		{
+			desc: "unique file names",
+			objects: []types.KeyVaultObject{
+				{ObjectName: "secret1", ObjectType: "secret"},
+				{ObjectName: "secret1", ObjectType: "key", ObjectAlias: "key1"},
+			},
+		},
+		{
+			desc: "same object name without alias",
+			objects: []types.KeyVaultObject{
+				{ObjectName: "secret1", ObjectType: "secret"},
+				{ObjectName: "secret1", ObjectType: "cert"},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "alias same as another object name",
+			objects: []types.KeyVaultObject{
+				{ObjectName: "secret1", ObjectType: "secret"},
+				{ObjectName: "secret2", ObjectType: "secret", ObjectAlias: "secret1"},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "alias in the version history directory of another object",
+			objects: []types.KeyVaultObject{
+				{ObjectName: "secret1", ObjectType: "secret", ObjectVersionHistory: 3},
+				{ObjectName: "secret2", ObjectType: "secret", ObjectAlias: "secret1/0"},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "file name with the same prefix",
+			objects: []types.KeyVaultObject{
+				{ObjectName: "secret1", ObjectType: "secret"},
+				{ObjectName: "secret10", ObjectType: "secret"},
+			},
+		},
+		{
+			desc: "alias same as the .crt file of a secret",
+			objects: []types.KeyVaultObject{
+				{ObjectName: "tls", ObjectType: "secret"},
+				{ObjectName: "secret1", ObjectType: "secret", ObjectAlias: "tls.crt"},
+			},
+			splitCertFiles: true,
+			expectedErr:    true,
+		},
+		{
+			desc: "alias same as the .crt file of a secret without separate files",
+			objects: []types.KeyVaultObject{
+				{ObjectName: "tls", ObjectType: "secret"},
+				{ObjectName: "secret1", ObjectType: "secret", ObjectAlias: "tls.crt"},
+			},
+		},
+		{
+			desc: "alias same as the .key file of a secret with version history",
+			objects: []types.KeyVaultObject{
+				{ObjectName: "tls", ObjectType: "secret", ObjectVersionHistory: 2},
+				{ObjectName: "secret1", ObjectType: "secret", ObjectAlias: "tls.key"},
+			},
+			splitCertFiles: true,
+		},
+		{
+			desc: "file names that differ in case",
+			objects: []types.KeyVaultObject{
+				{ObjectName: "Secret1", ObjectType: "secret"},
+				{ObjectName: "secret1", ObjectType: "secret"},
+			},
+		},
+		{
+			desc: "file names that differ in case on a case-insensitive file system",
+			objects: []types.KeyVaultObject{
+				{ObjectName: "Secret1", ObjectType: "secret"},
+				{ObjectName: "secret1", ObjectType: "secret"},
+			},
+			caseInsensitive: true,
+			expectedErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			errs := validateFilePaths(tc.objects, tc.splitCertFiles, tc.caseInsensitive)
+			if tc.expectedErr != (len(errs) > 0) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, errs)
+			}
+		})
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	cases := []struct {
+		desc         string
+		parameters   map[string]string
+		expectedErrs int
+	}{
+		{
+			desc: "valid parameters",
+			parameters: map[string]string{
+				"keyvaultName": "kv1",
+				"tenantId":     "tid",
+				"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret
+    filePermission: "0600"
+    metadataFiles: [contentType, "tag:owner"]
+  - |
+    objectName: cert1
+    objectType: cert`,
+			},
+		},
+		{
+			desc: "all errors are returned",
+			parameters: map[string]string{
+				"usePodIdentity": "yes",
+				"failurePolicy":  "Retry",
+				"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret
+    objectEncoding: base32
+  - |
+    objectName: key1
+    objectType: key
+    objectFormat: pfx
+  - |
+    objectName: secret2
+    objectType: secret
+    filePermission: "0999"
+  - |
+    objectName: secret3
+    objectType: secret
+  - |
+    objectName: secret4
+    objectType: secret
+    objectAlias: secret3`,
+			},
+			// keyvaultName, tenantId, usePodIdentity, failurePolicy, 3 invalid objects and the file path conflict
+			expectedErrs: 8,
+		},
+		{
+			desc: "invalid version constraints",
+			parameters: map[string]string{
+				"keyvaultName": "kv1",
+				"tenantId":     "tid",
+				"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret
+    objectVersionNotBefore: 2024-01-01
+  - |
+    objectName: secret2
+    objectType: secret
+    objectVersionMinAge: 1h
+    objectVersion: v1
+  - |
+    objectName: secret3
+    objectType: secret
+    objectVersionNotBefore: "2024-02-01T00:00:00Z"
+    objectVersionNotAfter: "2024-01-01T00:00:00Z"`,
+			},
+			expectedErrs: 3,
+		},
+		{
+			desc: "invalid vault and transport parameters",
+			parameters: map[string]string{
+				"keyvaultName":     "kv1",
+				"keyvaultURL":      "http://kv.vault.azure.net",
+				"tenantId":         "tid",
+				"keyvaultProxyURL": "socks5://proxy:1080",
+				"keyvaultCABundle": "not a certificate",
+				"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret`,
+			},
+			expectedErrs: 3,
+		},
+		{
+			desc: "invalid vault name",
+			parameters: map[string]string{
+				"keyvaultName": "kv_1",
+				"tenantId":     "tid",
+				"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret`,
+			},
+			expectedErrs: 1,
+		},
+		{
+			desc: "unknown cloud name",
+			parameters: map[string]string{
+				"keyvaultName": "kv1",
+				"tenantId":     "tid",
+				"cloudName":    "MoonCloud",
+				"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret`,
+			},
+			expectedErrs: 1,
+		},
+		{
+			desc: "AzureStackCloud without cloud environment file",
+			parameters: map[string]string{
+				"keyvaultName": "kv1",
+				"tenantId":     "tid",
+				"cloudName":    "AzureStackCloud",
+				"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret`,
+			},
+			expectedErrs: 1,
+		},
+		{
+			desc: "invalid cloud environment",
+			parameters: map[string]string{
+				"keyvaultName":     "kv1",
+				"tenantId":         "tid",
+				"cloudEnvironment": `{"name": "custom"}`,
+				"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret`,
+			},
+			expectedErrs: 1,
+		},
+		{
+			desc: "objects not set",
+			parameters: map[string]string{
+				"keyvaultName": "kv1",
+				"tenantId":     "tid",
+			},
+			expectedErrs: 1,
+		},
+		{
+			desc: "invalid objects yaml",
+			parameters: map[string]string{
+				"keyvaultName": "kv1",
+				"tenantId":     "tid",
+				"objects":      "array: [",
+			},
+			expectedErrs: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := ValidateParameters(tc.parameters, ValidationOptions{})
+			if tc.expectedErrs == 0 {
+				if err != nil {
+					t.Fatalf("ValidateParameters() = %v, want nil", err)
+				}
+				return
+			}
+			var agg utilerrors.Aggregate
+			if !errors.As(err, &agg) {
+				t.Fatalf("expected aggregate error, got: %v", err)
+			}
+			if len(agg.Errors()) != tc.expectedErrs {
+				t.Fatalf("expected %d errors, got %d: %v", tc.expectedErrs, len(agg.Errors()), agg.Errors())
+			}
+		})
+	}
+}
+
+func TestValidateSecretFilePaths(t *testing.T) {
+	cases := []struct {
+		desc            string
+		files           []types.SecretFile
+		caseInsensitive bool
+		expectedErr     bool
+	}{
+		{
+			desc: "unique file paths",
+			files: []types.SecretFile{
+				{Path: "cert1", UID: "secret/cert1"},
+				{Path: "cert1.crt", UID: "secret/cert1"},
+				{Path: "cert1.key", UID: "secret/cert1"},
+				{Path: "secret1/0", UID: "secret/secret1/0"},
+				{Path: "secret1/1", UID: "secret/secret1/1"},
+			},
+		},
+		{
+			desc: "cert file conflicts with another alias",
+			files: []types.SecretFile{
+				{Path: "cert1", UID: "secret/cert1"},
+				{Path: "cert1.crt", UID: "secret/cert1"},
+				{Path: "cert1.crt", UID: "cert/cert2"},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "version history directory conflicts with another alias",
+			files: []types.SecretFile{
+				{Path: "secret1/0", UID: "secret/secret1/0"},
+				{Path: "secret1", UID: "secret/secret2"},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "alias in the directory of another file",
+			files: []types.SecretFile{
+				{Path: "secret1", UID: "secret/secret1"},
+				{Path: "secret1/secret2", UID: "secret/secret2"},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "paths differ in case on case sensitive file system",
+			files: []types.SecretFile{
+				{Path: "secret1", UID: "secret/secret1"},
+				{Path: "Secret1", UID: "secret/secret2"},
+			},
+		},
+		{
+			desc: "paths differ in case on case insensitive file system",
+			files: []types.SecretFile{
+				{Path: "secret1", UID: "secret/secret1"},
+				{Path: "Secret1", UID: "secret/secret2"},
+			},
+			caseInsensitive: true,
+			expectedErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateSecretFilePaths(tc.files, tc.caseInsensitive)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,497 @@
+package secretsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	secretsstorev1 "sigs.k8s.io/secrets-store-csi-driver/apis/v1"
+	secretsstoreclientset "sigs.k8s.io/secrets-store-csi-driver/pkg/client/clientset/versioned"
+	secretsstoreinformers "sigs.k8s.io/secrets-store-csi-driver/pkg/client/informers/externalversions"
+	secretsstorelisters "sigs.k8s.io/secrets-store-csi-driver/pkg/client/listers/apis/v1"
+	"sigs.k8s.io/secrets-store-csi-driver/pkg/util/secretutil"
+)
+
+const (
+	// SyncAnnotation is the annotation that enables syncing the secretObjects of the
+	// SecretProviderClass to Kubernetes secrets without a pod mount
+	SyncAnnotation = "secrets-store.csi.azure.com/sync"
+	// ManagedByLabel is the label set on the Kubernetes secrets managed by the controller
+	ManagedByLabel = "secrets-store.csi.azure.com/managed-by"
+	// ManagedByValue is the value of the managed by label
+	ManagedByValue = "secret-sync-controller"
+
+	// AllNamespaces in the namespaces of the configuration syncs the SecretProviderClasses of all the namespaces
+	AllNamespaces = "*"
+
+	// providerName is the name of the provider in the SecretProviderClass
+	providerName = "azure"
+	// defaultFilePermission is the file permission passed to the provider. The file mode
+	// is not used when the content is written to Kubernetes secrets.
+	defaultFilePermission = 0644
+)
+
+// rejectedParameters are the SecretProviderClass parameters that select the identity or change where
+// its tokens are sent. The controller fetches the objects of all the namespaces with its own identity,
+// so a namespace can't select another identity, or send the tokens of the controller to a proxy, a
+// CA or an endpoint it controls.
+var rejectedParameters = []string{
+	types.UsePodIdentityParameter,
+	types.UseVMManagedIdentityParameter,
+	types.UserAssignedIdentityIDParameter,
+	types.ClientIDParameter,
+	types.KeyVaultProxyURLParameter,
+	types.KeyVaultCABundleParameter,
+	types.KeyVaultDisableChallengeResourceVerificationParameter,
+	types.CloudEnvironmentParameter,
+	types.CloudEnvFileNameParameter,
+}
+
+// Config is the configuration for the secret sync controller
+type Config struct {
+	// ResyncInterval is the interval at which the objects are fetched from key vault
+	// and the Kubernetes secrets are updated with the latest content
+	ResyncInterval time.Duration
+	// Workers is the number of SecretProviderClasses synced concurrently
+	Workers int
+	// Namespaces are the namespaces whose SecretProviderClasses are synced. AllNamespaces
+	// syncs all the namespaces.
+	Namespaces []string
+
+	// ClientID is the client ID of the workload identity federated with the service account
+	// of the controller. TokenFile must be set.
+	ClientID string
+	// TokenFile is the projected service account token of the controller used for workload identity
+	TokenFile string
+	// UseVMManagedIdentity fetches the objects with the managed identity of the node the controller runs on
+	UseVMManagedIdentity bool
+	// UserAssignedIdentityID is the client ID of the user-assigned managed identity of the node.
+	// The system-assigned managed identity is used if not set.
+	UserAssignedIdentityID string
+}
+
+// Validate checks that the identity of the controller and the namespaces are configured
+func (c Config) Validate() error {
+	if len(c.Namespaces) == 0 {
+		return fmt.Errorf("the namespaces synced by the controller are not configured")
+	}
+	switch {
+	case c.ClientID != "" && c.UseVMManagedIdentity:
+		return fmt.Errorf("only one of the workload identity and the managed identity can be configured")
+	case c.ClientID != "":
+		if c.TokenFile == "" {
+			return fmt.Errorf("the service account token file is required for the workload identity")
+		}
+	case c.UseVMManagedIdentity:
+	default:
+		return fmt.Errorf("the identity of the controller is not configured")
+	}
+	if c.UserAssignedIdentityID != "" && !c.UseVMManagedIdentity {
+		return fmt.Errorf("the user-assigned identity ID is only used with the managed identity")
+	}
+	return nil
+}
+
+// allowsNamespace returns true if the SecretProviderClasses of the namespace are synced
+func (c Config) allowsNamespace(namespace string) bool {
+	for _, ns := range c.Namespaces {
+		if ns == AllNamespaces || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// Controller syncs the secretObjects of the annotated SecretProviderClasses to Kubernetes
+// secrets. The content is fetched with the same provider used for the pod mounts.
+type Controller struct {
+	kubeClient kubernetes.Interface
+	provider   provider.Interface
+	config     Config
+
+	spcLister    secretsstorelisters.SecretProviderClassLister
+	secretLister corev1listers.SecretLister
+	informers    []cache.SharedIndexInformer
+
+	queue workqueue.RateLimitingInterface
+}
+
+// New creates the secret sync controller
+func New(kubeClient kubernetes.Interface, secretsStoreClient secretsstoreclientset.Interface, p provider.Interface, config Config) *Controller {
+	// the informer resync enqueues every SecretProviderClass at the resync interval, so
+	// the objects are fetched again and the rotated content is synced to the secrets
+	spcInformerFactory := secretsstoreinformers.NewSharedInformerFactory(secretsStoreClient, config.ResyncInterval)
+	spcInformer := spcInformerFactory.Secretsstore().V1().SecretProviderClasses()
+
+	// only the secrets managed by the controller are watched to correct drift
+	secretInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+		kubeinformers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = ManagedByLabel + "=" + ManagedByValue
+		}))
+	secretInformer := secretInformerFactory.Core().V1().Secrets()
+
+	c := &Controller{
+		kubeClient:   kubeClient,
+		provider:     p,
+		config:       config,
+		spcLister:    spcInformer.Lister(),
+		secretLister: secretInformer.Lister(),
+		informers:    []cache.SharedIndexInformer{spcInformer.Informer(), secretInformer.Informer()},
+		queue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "secret-sync"),
+	}
+
+	spcInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+	})
+	secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, obj interface{}) { c.enqueueOwner(obj) },
+		DeleteFunc: c.enqueueOwner,
+	})
+	return c
+}
+
+// Run starts the informers and the workers. It blocks until the context is done.
+func (c *Controller) Run(ctx context.Context) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	klog.InfoS("starting secret sync controller", "workers", c.config.Workers, "resyncInterval", c.config.ResyncInterval)
+	hasSynced := []cache.InformerSynced{}
+	for _, informer := range c.informers {
+		go informer.Run(ctx.Done())
+		hasSynced = append(hasSynced, informer.HasSynced)
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), hasSynced...) {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	for i := 0; i < c.config.Workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+	<-ctx.Done()
+	klog.InfoS("stopping secret sync controller")
+	return nil
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	item, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	key := item.(string)
+	if err := c.sync(ctx, key); err != nil {
+		klog.ErrorS(err, "failed to sync secret provider class", "secretProviderClass", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// sync fetches the objects for the SecretProviderClass and creates or updates the
+// Kubernetes secrets defined in secretObjects
+func (c *Controller) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	spc, err := c.spcLister.SecretProviderClasses(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		// the secrets are garbage collected through the owner references
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !isSyncEnabled(spc) {
+		// the secrets synced before the sync was disabled are deleted
+		return c.deleteStaleSecrets(ctx, spc, nil)
+	}
+	if !c.config.allowsNamespace(spc.Namespace) {
+		klog.V(2).InfoS("skipping secret provider class in a namespace that isn't synced", "secretProviderClass", klog.KObj(spc))
+		return c.deleteStaleSecrets(ctx, spc, nil)
+	}
+	if err = c.deleteStaleSecrets(ctx, spc, spc.Spec.SecretObjects); err != nil {
+		return err
+	}
+
+	attrib, err := c.getAttributes(spc)
+	if err != nil {
+		return err
+	}
+	files, err := c.provider.GetSecretsStoreObjectContent(ctx, attrib, nil, defaultFilePermission)
+	if err != nil {
+		return fmt.Errorf("failed to get objects, error: %w", err)
+	}
+	contents := make(map[string][]byte, len(files))
+	for _, file := range files {
+		contents[file.Path] = file.Content
+	}
+
+	var errs []string
+	for _, secretObject := range spc.Spec.SecretObjects {
+		if secretObject == nil {
+			continue
+		}
+		if err := c.syncSecret(ctx, spc, *secretObject, contents); err != nil {
+			errs = append(errs, fmt.Sprintf("secret %s: %v", secretObject.SecretName, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to sync secrets: %s", strings.Join(errs, "; "))
+	}
+	klog.V(2).InfoS("synced secret provider class", "secretProviderClass", klog.KObj(spc), "secrets", len(spc.Spec.SecretObjects))
+	return nil
+}
+
+// syncSecret creates the Kubernetes secret or updates it if it doesn't match the desired state
+func (c *Controller) syncSecret(ctx context.Context, spc *secretsstorev1.SecretProviderClass, secretObject secretsstorev1.SecretObject, contents map[string][]byte) error {
+	desired, err := buildSecret(spc, secretObject, contents)
+	if err != nil {
+		return err
+	}
+
+	current, err := c.secretLister.Secrets(spc.Namespace).Get(desired.Name)
+	if apierrors.IsNotFound(err) {
+		// the secret might exist without the managed by label, which is not in the informer cache
+		if _, err = c.kubeClient.CoreV1().Secrets(spc.Namespace).Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				return fmt.Errorf("secret already exists and is not managed by the secret sync controller")
+			}
+			return err
+		}
+		klog.InfoS("created secret", "secret", klog.KObj(desired), "secretProviderClass", klog.KObj(spc))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(current, spc) {
+		return fmt.Errorf("secret is not controlled by the secret provider class")
+	}
+	if current.Type != desired.Type {
+		// the secret type is immutable, so the secret is recreated
+		if err = c.kubeClient.CoreV1().Secrets(spc.Namespace).Delete(ctx, current.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		_, err = c.kubeClient.CoreV1().Secrets(spc.Namespace).Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	}
+	if !needsUpdate(current, desired) {
+		return nil
+	}
+
+	updated := current.DeepCopy()
+	updated.Data = desired.Data
+	updated.Labels = mergeMaps(updated.Labels, desired.Labels)
+	updated.Annotations = mergeMaps(updated.Annotations, desired.Annotations)
+	if _, err = c.kubeClient.CoreV1().Secrets(spc.Namespace).Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.InfoS("updated secret", "secret", klog.KObj(updated), "secretProviderClass", klog.KObj(spc))
+	return nil
+}
+
+// deleteStaleSecrets deletes the secrets controlled by the SecretProviderClass that aren't in the
+// secret objects, e.g. the secret object was removed or the sync was disabled
+func (c *Controller) deleteStaleSecrets(ctx context.Context, spc *secretsstorev1.SecretProviderClass, secretObjects []*secretsstorev1.SecretObject) error {
+	// the informer only caches the secrets managed by the controller
+	secrets, err := c.secretLister.Secrets(spc.Namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	desired := make(map[string]bool, len(secretObjects))
+	for _, secretObject := range secretObjects {
+		if secretObject != nil {
+			desired[strings.TrimSpace(secretObject.SecretName)] = true
+		}
+	}
+	for _, secret := range secrets {
+		if desired[secret.Name] || !metav1.IsControlledBy(secret, spc) {
+			continue
+		}
+		if err = c.kubeClient.CoreV1().Secrets(secret.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		klog.InfoS("deleted secret", "secret", klog.KObj(secret), "secretProviderClass", klog.KObj(spc))
+	}
+	return nil
+}
+
+// getAttributes returns the mount attributes for the SecretProviderClass. There is no pod for
+// the sync, so the objects are fetched with the identity configured for the controller and the
+// SecretProviderClass can't select another identity or redirect its tokens.
+func (c *Controller) getAttributes(spc *secretsstorev1.SecretProviderClass) (map[string]string, error) {
+	for _, param := range rejectedParameters {
+		if _, ok := spc.Spec.Parameters[param]; ok {
+			return nil, fmt.Errorf("%s can't be set in the synced SecretProviderClass, the objects are fetched with the identity of the controller", param)
+		}
+	}
+	attrib := make(map[string]string, len(spc.Spec.Parameters)+4)
+	for k, v := range spc.Spec.Parameters {
+		attrib[k] = v
+	}
+	attrib[types.CSIAttributePodNamespace] = spc.Namespace
+	attrib[types.CSIAttributeSecretProviderClass] = spc.Name
+
+	if c.config.UseVMManagedIdentity {
+		attrib[types.UseVMManagedIdentityParameter] = "true"
+		if c.config.UserAssignedIdentityID != "" {
+			attrib[types.UserAssignedIdentityIDParameter] = c.config.UserAssignedIdentityID
+		}
+		return attrib, nil
+	}
+	if c.config.ClientID == "" || c.config.TokenFile == "" {
+		return nil, fmt.Errorf("the identity of the controller is not configured")
+	}
+	attrib[types.ClientIDParameter] = c.config.ClientID
+	token, err := os.ReadFile(c.config.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token file, error: %w", err)
+	}
+	tokens := auth.SATokens{}
+	tokens.APIAzureADTokenExchange.Token = strings.TrimSpace(string(token))
+	saTokens, err := json.Marshal(tokens)
+	if err != nil {
+		return nil, err
+	}
+	attrib[types.CSIAttributeServiceAccountTokens] = string(saTokens)
+	return attrib, nil
+}
+
+// buildSecret returns the desired Kubernetes secret for the secret object
+func buildSecret(spc *secretsstorev1.SecretProviderClass, secretObject secretsstorev1.SecretObject, contents map[string][]byte) (*corev1.Secret, error) {
+	if err := secretutil.ValidateSecretObject(secretObject); err != nil {
+		return nil, err
+	}
+	secretType := secretutil.GetSecretType(strings.TrimSpace(secretObject.Type))
+
+	data := make(map[string][]byte, len(secretObject.Data))
+	for _, d := range secretObject.Data {
+		if d == nil {
+			continue
+		}
+		objectName := strings.TrimSpace(d.ObjectName)
+		dataKey := strings.TrimSpace(d.Key)
+		if objectName == "" || dataKey == "" {
+			return nil, fmt.Errorf("objectName and key in secretObjects.data must be set")
+		}
+		content, ok := contents[objectName]
+		if !ok {
+			return nil, fmt.Errorf("object %s not found in the objects fetched from key vault", objectName)
+		}
+		if secretType == corev1.SecretTypeTLS {
+			var err error
+			if content, err = secretutil.GetCertPart(content, dataKey); err != nil {
+				return nil, fmt.Errorf("failed to get cert data from object %s, error: %w", objectName, err)
+			}
+		}
+		data[dataKey] = content
+	}
+
+	labels := mergeMaps(nil, secretObject.Labels)
+	labels[ManagedByLabel] = ManagedByValue
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        strings.TrimSpace(secretObject.SecretName),
+			Namespace:   spc.Namespace,
+			Labels:      labels,
+			Annotations: mergeMaps(nil, secretObject.Annotations),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(spc, secretsstorev1.SchemeGroupVersion.WithKind("SecretProviderClass")),
+			},
+		},
+		Type: secretType,
+		Data: data,
+	}, nil
+}
+
+// needsUpdate returns true if the data, labels or annotations of the current secret
+// drifted from the desired state
+func needsUpdate(current, desired *corev1.Secret) bool {
+	if !reflect.DeepEqual(current.Data, desired.Data) {
+		return true
+	}
+	for k, v := range desired.Labels {
+		if current.Labels[k] != v {
+			return true
+		}
+	}
+	for k, v := range desired.Annotations {
+		if current.Annotations[k] != v {
+			return true
+		}
+	}
+	return false
+}
+
+// isSyncEnabled returns true if the SecretProviderClass is for this provider and is
+// annotated to be synced by the controller
+func isSyncEnabled(spc *secretsstorev1.SecretProviderClass) bool {
+	return string(spc.Spec.Provider) == providerName && strings.EqualFold(spc.Annotations[SyncAnnotation], "true")
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// enqueueOwner enqueues the SecretProviderClass that owns the secret, so changes made to
+// the secret outside the controller are reverted
+func (c *Controller) enqueueOwner(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return
+	}
+	owner := metav1.GetControllerOf(secret)
+	if owner == nil || owner.Kind != "SecretProviderClass" {
+		return
+	}
+	c.queue.Add(secret.Namespace + "/" + owner.Name)
+}
+
+func mergeMaps(dst, src map[string]string) map[string]string {
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
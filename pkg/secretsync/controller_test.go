@@ -0,0 +1,443 @@
+package secretsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_provider"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+
+	"github.com/golang/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	secretsstorev1 "sigs.k8s.io/secrets-store-csi-driver/apis/v1"
+	secretsstorefake "sigs.k8s.io/secrets-store-csi-driver/pkg/client/clientset/versioned/fake"
+)
+
+func newTestSecretProviderClass(annotations map[string]string, secretType string) *secretsstorev1.SecretProviderClass {
+	return &secretsstorev1.SecretProviderClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "spc1",
+			Namespace:   "default",
+			UID:         "spc-uid",
+			Annotations: annotations,
+		},
+		Spec: secretsstorev1.SecretProviderClassSpec{
+			Provider: "azure",
+			Parameters: map[string]string{
+				"keyvaultName": "kv",
+				"tenantId":     "tid",
+			},
+			SecretObjects: []*secretsstorev1.SecretObject{
+				{
+					SecretName: "secret1",
+					Type:       secretType,
+					Labels:     map[string]string{"app": "test"},
+					Data: []*secretsstorev1.SecretObjectData{
+						{ObjectName: "object1", Key: "key1"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// testConfig syncs the default namespace with the managed identity of the node
+var testConfig = Config{Namespaces: []string{"default"}, UseVMManagedIdentity: true}
+
+func newTestController(t *testing.T, p *mock_provider.MockInterface, config Config, spc *secretsstorev1.SecretProviderClass, secrets ...runtime.Object) *Controller {
+	kubeClient := fake.NewSimpleClientset(secrets...)
+	secretsStoreClient := secretsstorefake.NewSimpleClientset(spc)
+
+	c := New(kubeClient, secretsStoreClient, p, config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	hasSynced := []cache.InformerSynced{}
+	for _, informer := range c.informers {
+		go informer.Run(ctx.Done())
+		hasSynced = append(hasSynced, informer.HasSynced)
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), hasSynced...) {
+		t.Fatalf("failed to wait for caches to sync")
+	}
+	return c
+}
+
+func TestSyncCreatesSecret(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := mock_provider.NewMockInterface(ctrl)
+	p.EXPECT().GetSecretsStoreObjectContent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, attrib, _ map[string]string, _ os.FileMode) ([]types.SecretFile, error) {
+			if attrib[types.CSIAttributePodNamespace] != "default" || attrib[types.CSIAttributeSecretProviderClass] != "spc1" ||
+				attrib[types.UseVMManagedIdentityParameter] != "true" {
+				t.Errorf("unexpected attributes: %v", attrib)
+			}
+			return []types.SecretFile{{Path: "object1", Content: []byte("value1")}}, nil
+		},
+	)
+
+	spc := newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque")
+	c := newTestController(t, p, testConfig, spc)
+
+	if err := c.sync(context.TODO(), "default/spc1"); err != nil {
+		t.Fatalf("sync() = %v, want nil", err)
+	}
+
+	secret, err := c.kubeClient.CoreV1().Secrets("default").Get(context.TODO(), "secret1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get secret: %v", err)
+	}
+	if !reflect.DeepEqual(secret.Data, map[string][]byte{"key1": []byte("value1")}) {
+		t.Fatalf("unexpected secret data: %v", secret.Data)
+	}
+	if secret.Labels[ManagedByLabel] != ManagedByValue || secret.Labels["app"] != "test" {
+		t.Fatalf("unexpected secret labels: %v", secret.Labels)
+	}
+	if !metav1.IsControlledBy(secret, spc) {
+		t.Fatalf("expected secret to be controlled by the secret provider class, got: %v", secret.OwnerReferences)
+	}
+}
+
+func TestSyncCorrectsDrift(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := mock_provider.NewMockInterface(ctrl)
+	p.EXPECT().GetSecretsStoreObjectContent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
+		[]types.SecretFile{{Path: "object1", Content: []byte("value1")}}, nil,
+	)
+
+	spc := newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque")
+	drifted, err := buildSecret(spc, *spc.Spec.SecretObjects[0], map[string][]byte{"object1": []byte("changed")})
+	if err != nil {
+		t.Fatalf("buildSecret() = %v, want nil", err)
+	}
+	c := newTestController(t, p, testConfig, spc, drifted)
+
+	if err = c.sync(context.TODO(), "default/spc1"); err != nil {
+		t.Fatalf("sync() = %v, want nil", err)
+	}
+
+	secret, err := c.kubeClient.CoreV1().Secrets("default").Get(context.TODO(), "secret1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get secret: %v", err)
+	}
+	if string(secret.Data["key1"]) != "value1" {
+		t.Fatalf("expected drift to be corrected, got: %s", secret.Data["key1"])
+	}
+}
+
+func TestSyncError(t *testing.T) {
+	unmanaged := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret1",
+			Namespace: "default",
+		},
+	}
+
+	cases := []struct {
+		desc    string
+		spc     *secretsstorev1.SecretProviderClass
+		secrets []runtime.Object
+		files   []types.SecretFile
+	}{
+		{
+			desc:    "secret exists and is not managed by the controller",
+			spc:     newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque"),
+			secrets: []runtime.Object{unmanaged},
+			files:   []types.SecretFile{{Path: "object1", Content: []byte("value1")}},
+		},
+		{
+			desc:  "object not found in the fetched objects",
+			spc:   newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque"),
+			files: []types.SecretFile{{Path: "object2", Content: []byte("value2")}},
+		},
+		{
+			desc:  "invalid tls content",
+			spc:   newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "kubernetes.io/tls"),
+			files: []types.SecretFile{{Path: "object1", Content: []byte("value1")}},
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := mock_provider.NewMockInterface(ctrl)
+			p.EXPECT().GetSecretsStoreObjectContent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(tc.files, nil)
+
+			c := newTestController(t, p, testConfig, tc.spc, tc.secrets...)
+			if err := c.sync(context.TODO(), "default/spc1"); err == nil {
+				t.Fatalf("sync() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestSyncSkipsSecretProviderClass(t *testing.T) {
+	cases := []struct {
+		desc string
+		spc  *secretsstorev1.SecretProviderClass
+	}{
+		{
+			desc: "sync annotation not set",
+			spc:  newTestSecretProviderClass(nil, "Opaque"),
+		},
+		{
+			desc: "sync annotation set to false",
+			spc:  newTestSecretProviderClass(map[string]string{SyncAnnotation: "false"}, "Opaque"),
+		},
+		{
+			desc: "namespace not synced",
+			spc: func() *secretsstorev1.SecretProviderClass {
+				spc := newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque")
+				spc.Namespace = "other"
+				return spc
+			}(),
+		},
+		{
+			desc: "secret provider class for another provider",
+			spc: func() *secretsstorev1.SecretProviderClass {
+				spc := newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque")
+				spc.Spec.Provider = "vault"
+				return spc
+			}(),
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			// no calls are expected on the provider
+			p := mock_provider.NewMockInterface(ctrl)
+			c := newTestController(t, p, testConfig, tc.spc)
+			if err := c.sync(context.TODO(), tc.spc.Namespace+"/spc1"); err != nil {
+				t.Fatalf("sync() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestSyncDeletesStaleSecrets(t *testing.T) {
+	cases := []struct {
+		desc            string
+		update          func(spc *secretsstorev1.SecretProviderClass)
+		expectedSecrets []string
+	}{
+		{
+			desc: "secret object removed",
+			update: func(spc *secretsstorev1.SecretProviderClass) {
+				spc.Spec.SecretObjects = spc.Spec.SecretObjects[:1]
+			},
+			expectedSecrets: []string{"secret1", "unmanaged"},
+		},
+		{
+			desc: "sync annotation removed",
+			update: func(spc *secretsstorev1.SecretProviderClass) {
+				delete(spc.Annotations, SyncAnnotation)
+			},
+			expectedSecrets: []string{"unmanaged"},
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			spc := newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque")
+			spc.Spec.SecretObjects = append(spc.Spec.SecretObjects, &secretsstorev1.SecretObject{
+				SecretName: "secret2",
+				Type:       "Opaque",
+				Data:       []*secretsstorev1.SecretObjectData{{ObjectName: "object1", Key: "key1"}},
+			})
+			contents := map[string][]byte{"object1": []byte("value1")}
+			var secrets []runtime.Object
+			for _, secretObject := range spc.Spec.SecretObjects {
+				secret, err := buildSecret(spc, *secretObject, contents)
+				if err != nil {
+					t.Fatalf("buildSecret() = %v, want nil", err)
+				}
+				secrets = append(secrets, secret)
+			}
+			// the secret with the managed by label that isn't controlled by the secret provider class is kept
+			secrets = append(secrets, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name:      "unmanaged",
+				Namespace: "default",
+				Labels:    map[string]string{ManagedByLabel: ManagedByValue},
+			}})
+			tc.update(spc)
+
+			p := mock_provider.NewMockInterface(ctrl)
+			p.EXPECT().GetSecretsStoreObjectContent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
+				[]types.SecretFile{{Path: "object1", Content: []byte("value1")}}, nil,
+			).MaxTimes(1)
+			c := newTestController(t, p, testConfig, spc, secrets...)
+			if err := c.sync(context.TODO(), "default/spc1"); err != nil {
+				t.Fatalf("sync() = %v, want nil", err)
+			}
+
+			list, err := c.kubeClient.CoreV1().Secrets("default").List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("failed to list secrets: %v", err)
+			}
+			var names []string
+			for _, secret := range list.Items {
+				names = append(names, secret.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tc.expectedSecrets) {
+				t.Fatalf("expected secrets %v, got %v", tc.expectedSecrets, names)
+			}
+		})
+	}
+}
+
+func TestGetAttributesWorkloadIdentity(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("test-token\n"), 0600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+	spc := newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque")
+
+	c := &Controller{config: Config{ClientID: "client-id", TokenFile: tokenFile}}
+	attrib, err := c.getAttributes(spc)
+	if err != nil {
+		t.Fatalf("getAttributes() = %v, want nil", err)
+	}
+	if clientID := types.GetClientID(attrib); clientID != "client-id" {
+		t.Fatalf("expected client ID client-id, got %s", clientID)
+	}
+	token, err := auth.ParseServiceAccountToken(types.GetServiceAccountTokens(attrib))
+	if err != nil {
+		t.Fatalf("ParseServiceAccountToken() = %v, want nil", err)
+	}
+	if token != "test-token" {
+		t.Fatalf("expected token test-token, got %s", token)
+	}
+
+	c.config.TokenFile = ""
+	if _, err = c.getAttributes(spc); err == nil {
+		t.Fatalf("getAttributes() = nil, want error when the token file is not configured")
+	}
+}
+
+func TestGetAttributesRejectedParameters(t *testing.T) {
+	for _, param := range []string{
+		"usePodIdentity",
+		"useVMManagedIdentity",
+		"userAssignedIdentityID",
+		"clientID",
+		"keyvaultProxyURL",
+		"keyvaultCABundle",
+		"keyvaultDisableChallengeResourceVerification",
+		"cloudEnvironment",
+		"cloudEnvFileName",
+	} {
+		t.Run(param, func(t *testing.T) {
+			spc := newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque")
+			spc.Spec.Parameters[param] = "value"
+
+			c := &Controller{config: testConfig}
+			if _, err := c.getAttributes(spc); err == nil {
+				t.Fatalf("getAttributes() = nil, want error for the parameter %s", param)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cases := []struct {
+		desc        string
+		config      Config
+		expectedErr bool
+	}{
+		{
+			desc:   "workload identity",
+			config: Config{Namespaces: []string{"default"}, ClientID: "clientid", TokenFile: "/var/run/secrets/token"},
+		},
+		{
+			desc:   "user-assigned managed identity",
+			config: Config{Namespaces: []string{AllNamespaces}, UseVMManagedIdentity: true, UserAssignedIdentityID: "clientid"},
+		},
+		{
+			desc:        "namespaces not configured",
+			config:      Config{UseVMManagedIdentity: true},
+			expectedErr: true,
+		},
+		{
+			desc:        "identity not configured",
+			config:      Config{Namespaces: []string{"default"}},
+			expectedErr: true,
+		},
+		{
+			desc:        "workload identity without token file",
+			config:      Config{Namespaces: []string{"default"}, ClientID: "clientid"},
+			expectedErr: true,
+		},
+		{
+			desc:        "workload identity and managed identity",
+			config:      Config{Namespaces: []string{"default"}, ClientID: "clientid", TokenFile: "/var/run/secrets/token", UseVMManagedIdentity: true},
+			expectedErr: true,
+		},
+		{
+			desc:        "user-assigned identity without managed identity",
+			config:      Config{Namespaces: []string{"default"}, ClientID: "clientid", TokenFile: "/var/run/secrets/token", UserAssignedIdentityID: "clientid"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if err := tc.config.Validate(); tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	p := mock_provider.NewMockInterface(ctrl)
+	p.EXPECT().GetSecretsStoreObjectContent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
+		[]types.SecretFile{{Path: "object1", Content: []byte("value1")}}, nil,
+	).AnyTimes()
+
+	spc := newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque")
+	kubeClient := fake.NewSimpleClientset()
+	config := testConfig
+	config.Workers = 1
+	c := New(kubeClient, secretsstorefake.NewSimpleClientset(spc), p, config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = c.Run(ctx)
+	}()
+
+	err := wait.PollImmediate(100*time.Millisecond, 10*time.Second, func() (bool, error) {
+		_, err := kubeClient.CoreV1().Secrets("default").Get(context.TODO(), "secret1", metav1.GetOptions{})
+		return err == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("expected secret to be created by the controller: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package secretsync
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_provider"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+
+	"github.com/golang/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	secretsstorev1 "sigs.k8s.io/secrets-store-csi-driver/apis/v1"
+	secretsstoreclientset "sigs.k8s.io/secrets-store-csi-driver/pkg/client/clientset/versioned"
+)
+
+// secretProviderClassCRD returns the SecretProviderClass CRD with a schema that preserves all the fields
+func secretProviderClassCRD() *apiextensionsv1.CustomResourceDefinition {
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "secretproviderclasses.secrets-store.csi.x-k8s.io"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: secretsstorev1.SchemeGroupVersion.Group,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Kind:     "SecretProviderClass",
+				ListKind: "SecretProviderClassList",
+				Plural:   "secretproviderclasses",
+				Singular: "secretproviderclass",
+			},
+			Scope: apiextensionsv1.NamespaceScoped,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{
+					Name:    secretsstorev1.SchemeGroupVersion.Version,
+					Served:  true,
+					Storage: true,
+					Schema: &apiextensionsv1.CustomResourceValidation{
+						OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+							Type:                   "object",
+							XPreserveUnknownFields: pointer.Bool(true),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// TestControllerEnvtest runs the controller against a real API server. The test is skipped if
+// the envtest binaries aren't installed, see https://book.kubebuilder.io/reference/envtest.html
+func TestControllerEnvtest(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+
+	env := &envtest.Environment{
+		CRDInstallOptions: envtest.CRDInstallOptions{
+			CRDs: []*apiextensionsv1.CustomResourceDefinition{secretProviderClassCRD()},
+		},
+	}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatalf("failed to start envtest: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("failed to stop envtest: %v", err)
+		}
+	})
+	kubeClient := kubernetes.NewForConfigOrDie(cfg)
+	secretsStoreClient := secretsstoreclientset.NewForConfigOrDie(cfg)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	p := mock_provider.NewMockInterface(ctrl)
+	p.EXPECT().GetSecretsStoreObjectContent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
+		[]types.SecretFile{{Path: "object1", Content: []byte("value1")}}, nil,
+	).AnyTimes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := testConfig
+	config.Workers = 1
+	config.ResyncInterval = time.Minute
+	c := New(kubeClient, secretsStoreClient, p, config)
+	go func() {
+		_ = c.Run(ctx)
+	}()
+
+	// the namespace that isn't synced by the controller
+	if _, err = kubeClient.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create namespace: %v", err)
+	}
+	other := newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque")
+	other.Namespace = "other"
+	if _, err = secretsStoreClient.SecretsstoreV1().SecretProviderClasses("other").Create(ctx, other, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create secret provider class: %v", err)
+	}
+
+	spc := newTestSecretProviderClass(map[string]string{SyncAnnotation: "true"}, "Opaque")
+	spc.Spec.SecretObjects = append(spc.Spec.SecretObjects, &secretsstorev1.SecretObject{
+		SecretName: "secret2",
+		Type:       "Opaque",
+		Data:       []*secretsstorev1.SecretObjectData{{ObjectName: "object1", Key: "key1"}},
+	})
+	if spc, err = secretsStoreClient.SecretsstoreV1().SecretProviderClasses("default").Create(ctx, spc, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create secret provider class: %v", err)
+	}
+
+	// waitSecret waits for the secret to exist or to be deleted
+	waitSecret := func(name string, exists bool) {
+		t.Helper()
+		err := wait.PollImmediate(100*time.Millisecond, 30*time.Second, func() (bool, error) {
+			_, err := kubeClient.CoreV1().Secrets("default").Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return !exists, nil
+			}
+			return exists && err == nil, nil
+		})
+		if err != nil {
+			t.Fatalf("timed out waiting for secret %s to exist: %v", name, exists)
+		}
+	}
+	waitSecret("secret1", true)
+	waitSecret("secret2", true)
+
+	// the secret of the removed secret object is deleted
+	spc.Spec.SecretObjects = spc.Spec.SecretObjects[:1]
+	if spc, err = secretsStoreClient.SecretsstoreV1().SecretProviderClasses("default").Update(ctx, spc, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update secret provider class: %v", err)
+	}
+	waitSecret("secret2", false)
+	waitSecret("secret1", true)
+
+	// the secrets are deleted when the sync annotation is removed
+	delete(spc.Annotations, SyncAnnotation)
+	if _, err = secretsStoreClient.SecretsstoreV1().SecretProviderClasses("default").Update(ctx, spc, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update secret provider class: %v", err)
+	}
+	waitSecret("secret1", false)
+
+	secrets, err := kubeClient.CoreV1().Secrets("other").List(ctx, metav1.ListOptions{LabelSelector: ManagedByLabel + "=" + ManagedByValue})
+	if err != nil {
+		t.Fatalf("failed to list secrets: %v", err)
+	}
+	if len(secrets.Items) != 0 {
+		t.Fatalf("expected no secrets in the namespace that isn't synced, got %d", len(secrets.Items))
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// reasonShutdown is the reason the provider is not serving while the server is gracefully stopped
+	reasonShutdown = "shutdown"
+)
+
+// HealthManager holds the serving status reported by the gRPC health service and the readiness
+// endpoint. The provider is NOT_SERVING while the readiness checks fail or a not serving reason
+// is set, e.g. during the graceful shutdown.
+type HealthManager struct {
+	// readiness is the readiness checker combined with the reasons. Not checked if nil.
+	readiness *Readiness
+
+	mu sync.Mutex
+	// notServing is the message of each reason the provider is not serving
+	notServing map[string]string
+	watchers   map[chan struct{}]struct{}
+	// shutdown is closed when the server is shutting down to end the watch streams
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+// NewHealthManager returns the health status manager. The watchers are notified when the
+// readiness may have changed.
+func NewHealthManager(readiness *Readiness) *HealthManager {
+	m := &HealthManager{
+		readiness:  readiness,
+		notServing: make(map[string]string),
+		watchers:   make(map[chan struct{}]struct{}),
+		shutdown:   make(chan struct{}),
+	}
+	if readiness != nil {
+		readiness.onChange = m.notify
+	}
+	return m
+}
+
+// SetNotServing sets the reason the provider is not serving
+func (m *HealthManager) SetNotServing(reason, message string) {
+	m.mu.Lock()
+	previous, ok := m.notServing[reason]
+	m.notServing[reason] = message
+	m.mu.Unlock()
+	if ok && previous == message {
+		return
+	}
+	klog.InfoS("provider not serving", "reason", reason, "message", message)
+	m.notify()
+}
+
+// SetServing clears the reason the provider is not serving
+func (m *HealthManager) SetServing(reason string) {
+	m.mu.Lock()
+	_, ok := m.notServing[reason]
+	delete(m.notServing, reason)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	klog.InfoS("provider not serving reason cleared", "reason", reason)
+	m.notify()
+}
+
+// Shutdown sets the provider not serving for the graceful shutdown and ends the watch streams
+// after the NOT_SERVING status is sent, so the graceful stop doesn't wait for the watchers.
+func (m *HealthManager) Shutdown() {
+	m.SetNotServing(reasonShutdown, "the server is shutting down")
+	m.shutdownOnce.Do(func() { close(m.shutdown) })
+}
+
+// Done returns the channel closed when the server is shutting down
+func (m *HealthManager) Done() <-chan struct{} {
+	return m.shutdown
+}
+
+// RecordMount records the result of the mount in the readiness checker
+func (m *HealthManager) RecordMount(err error) {
+	if m.readiness != nil {
+		m.readiness.RecordMount(err)
+	}
+}
+
+// Ready returns nil if the provider is serving or the reasons it isn't serving
+func (m *HealthManager) Ready() error {
+	var errs []error
+	m.mu.Lock()
+	reasons := make([]string, 0, len(m.notServing))
+	for reason := range m.notServing {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		errs = append(errs, fmt.Errorf("%s: %s", reason, m.notServing[reason]))
+	}
+	m.mu.Unlock()
+
+	if m.readiness != nil {
+		if err := m.readiness.Ready(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status returns NOT_SERVING if the provider isn't ready and SERVING otherwise
+func (m *HealthManager) Status() grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if m.Ready() != nil {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	return grpc_health_v1.HealthCheckResponse_SERVING
+}
+
+// Subscribe returns the channel notified when the status may have changed and the function
+// to stop the notifications
+func (m *HealthManager) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	m.mu.Lock()
+	m.watchers[ch] = struct{}{}
+	m.mu.Unlock()
+	return ch, func() {
+		m.mu.Lock()
+		delete(m.watchers, ch)
+		m.mu.Unlock()
+	}
+}
+
+// notify notifies the watchers without blocking, a pending notification is enough for the
+// watcher to read the latest status
+func (m *HealthManager) notify() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ch := range m.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Details returns the informational details of the readiness checker that don't change the
+// serving status, e.g. the open circuits of the circuit breaker
+func (m *HealthManager) Details() []string {
+	if m.readiness == nil {
+		return nil
+	}
+	return m.readiness.Details()
+}
+
+// ServeHTTP serves the readiness endpoint. The details are written after the status.
+func (m *HealthManager) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	lines := []string{"ok"}
+	code := http.StatusOK
+	if err := m.Ready(); err != nil {
+		lines = []string{err.Error()}
+		code = http.StatusServiceUnavailable
+	}
+	lines = append(lines, m.Details()...)
+	if code != http.StatusOK {
+		http.Error(w, strings.Join(lines, "\n"), code)
+		return
+	}
+	w.WriteHeader(code)
+	w.Write([]byte(strings.Join(lines, "\n")))
+}
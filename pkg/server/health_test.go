@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/breaker"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newHealthClient serves the health service of the provider server on an in-memory listener
+// and returns the client connected to it
+func newHealthClient(t *testing.T, testServer *CSIDriverProviderServer) (grpc_health_v1.HealthClient, *grpc.Server) {
+	t.Helper()
+	listener := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, testServer)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to create client, error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn), s
+}
+
+func TestCheckAndWatch(t *testing.T) {
+	readiness := NewReadiness(ReadinessConfig{})
+	health := NewHealthManager(readiness)
+	testServer := &CSIDriverProviderServer{}
+	testServer.SetHealthManager(health)
+	client, s := newHealthClient(t, testServer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	expectStatus := func(expected grpc_health_v1.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv() error = %v", err)
+		}
+		if resp.Status != expected {
+			t.Fatalf("expected status %v, got %v", expected, resp.Status)
+		}
+		checkResp, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		if err != nil || checkResp.Status != expected {
+			t.Fatalf("Check() = %v, %v, want %v", checkResp, err, expected)
+		}
+	}
+
+	expectStatus(grpc_health_v1.HealthCheckResponse_SERVING)
+
+	// readiness check failure
+	readiness.mu.Lock()
+	readiness.dependencyErr = errors.New("authority not reachable")
+	readiness.mu.Unlock()
+	readiness.notify()
+	expectStatus(grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+	readiness.mu.Lock()
+	readiness.dependencyErr = nil
+	readiness.mu.Unlock()
+	readiness.notify()
+	expectStatus(grpc_health_v1.HealthCheckResponse_SERVING)
+
+	// not serving reason, e.g. the graceful shutdown
+	health.SetNotServing("test", "not serving")
+	expectStatus(grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	health.SetServing("test")
+	expectStatus(grpc_health_v1.HealthCheckResponse_SERVING)
+
+	// the watch stream ends after the shutdown status is sent so the graceful stop doesn't block
+	health.Shutdown()
+	expectStatus(grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Recv() error = %v, want %v", err, io.EOF)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the graceful stop")
+	}
+}
+
+func TestWatchMultipleWatchers(t *testing.T) {
+	health := NewHealthManager(nil)
+	testServer := &CSIDriverProviderServer{}
+	testServer.SetHealthManager(health)
+	client, _ := newHealthClient(t, testServer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var streams []grpc_health_v1.Health_WatchClient
+	for i := 0; i < 3; i++ {
+		stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+		if err != nil {
+			t.Fatalf("Watch() error = %v", err)
+		}
+		if resp, err := stream.Recv(); err != nil || resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+			t.Fatalf("Recv() = %v, %v, want %v", resp, err, grpc_health_v1.HealthCheckResponse_SERVING)
+		}
+		streams = append(streams, stream)
+	}
+
+	health.SetNotServing("test", "not serving")
+	for _, stream := range streams {
+		if resp, err := stream.Recv(); err != nil || resp.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+			t.Fatalf("Recv() = %v, %v, want %v", resp, err, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+		}
+	}
+}
+
+func TestHealthManagerServeHTTP(t *testing.T) {
+	readiness := NewReadiness(ReadinessConfig{})
+	health := NewHealthManager(readiness)
+	circuitBreaker, err := breaker.New(breaker.Config{FailureThreshold: 1, OpenDuration: time.Minute})
+	if err != nil {
+		t.Fatalf("breaker.New() = %v", err)
+	}
+	readiness.SetCircuitBreaker(circuitBreaker)
+	key := breaker.Key{Vault: "kv1.vault.azure.net", Identity: "client1"}
+
+	cases := []struct {
+		desc           string
+		setup          func()
+		expectedCode   int
+		expectedBody   string
+		expectedStatus grpc_health_v1.HealthCheckResponse_ServingStatus
+	}{
+		{
+			desc:           "serving",
+			setup:          func() {},
+			expectedCode:   http.StatusOK,
+			expectedBody:   "ok",
+			expectedStatus: grpc_health_v1.HealthCheckResponse_SERVING,
+		},
+		{
+			desc:           "open circuit is reported without failing the readiness",
+			setup:          func() { circuitBreaker.Record(key, errors.New("vault unavailable"), true) },
+			expectedCode:   http.StatusOK,
+			expectedBody:   "ok\ncircuit breaker open for kv1.vault.azure.net (client1)",
+			expectedStatus: grpc_health_v1.HealthCheckResponse_SERVING,
+		},
+		{
+			desc:           "readiness check failure",
+			setup:          func() { readiness.dependencyErr = errors.New("authority not reachable") },
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedBody:   "authority not reachable\ncircuit breaker open for kv1.vault.azure.net (client1)\n",
+			expectedStatus: grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			desc: "not serving reason",
+			setup: func() {
+				readiness.dependencyErr = nil
+				circuitBreaker.Record(key, nil, false)
+				health.SetNotServing("test", "not serving")
+			},
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedBody:   "test: not serving\n",
+			expectedStatus: grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			desc:           "not serving reason cleared",
+			setup:          func() { health.SetServing("test") },
+			expectedCode:   http.StatusOK,
+			expectedBody:   "ok",
+			expectedStatus: grpc_health_v1.HealthCheckResponse_SERVING,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.setup()
+			rec := httptest.NewRecorder()
+			health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tc.expectedCode {
+				t.Fatalf("expected status code %d, got %d", tc.expectedCode, rec.Code)
+			}
+			if body := rec.Body.String(); body != tc.expectedBody {
+				t.Fatalf("expected body %q, got %q", tc.expectedBody, body)
+			}
+			if status := health.Status(); status != tc.expectedStatus {
+				t.Fatalf("expected serving status %v, got %v", tc.expectedStatus, status)
+			}
+		})
+	}
+}
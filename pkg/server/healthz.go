@@ -24,12 +24,19 @@ type HealthZ struct {
 	HealthCheckURL *url.URL
 	UnixSocketPath string
 	RPCTimeout     time.Duration
+	// ReadinessPath is the path of the readiness endpoint served with the health check.
+	// The readiness endpoint isn't served if not set.
+	ReadinessPath string
+	Readiness     http.Handler
 }
 
 // Serve creates the http handler for serving health requests
 func (h *HealthZ) Serve() {
 	serveMux := http.NewServeMux()
 	serveMux.HandleFunc(h.HealthCheckURL.EscapedPath(), h.ServeHTTP)
+	if h.ReadinessPath != "" && h.Readiness != nil {
+		serveMux.Handle(h.ReadinessPath, h.Readiness)
+	}
 	server := &http.Server{
 		Addr:              h.HealthCheckURL.Host,
 		ReadHeaderTimeout: readHeaderTimeout,
@@ -68,13 +75,15 @@ func (h *HealthZ) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 
 // checkRPC initiates a grpc request to validate the socket is responding
 // sends a gRPC HealthCheckRequest and checks if the HealthCheckResponse is valid.
+// NOT_SERVING is a valid response as the server is alive even if it isn't ready,
+// so the container isn't restarted when the dependencies are degraded.
 func (h *HealthZ) checkRPC(ctx context.Context, client grpc_health_v1.HealthClient) error {
 	v, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
 	if err != nil {
 		return err
 	}
-	if v == nil || v.Status != grpc_health_v1.HealthCheckResponse_SERVING {
-		return fmt.Errorf("expected health check response serving")
+	if v == nil || (v.Status != grpc_health_v1.HealthCheckResponse_SERVING && v.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING) {
+		return fmt.Errorf("expected health check response serving or not serving")
 	}
 	return nil
 }
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/auth"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/breaker"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cloud"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"k8s.io/klog/v2"
+)
+
+// ReadinessConfig is the configuration of the readiness checks
+type ReadinessConfig struct {
+	// CloudEnvironment is the cloud environment of the Key Vault and Azure AD endpoints that are checked
+	CloudEnvironment cloud.Environment
+	// CheckManagedIdentity checks that a Key Vault token can be requested with the node managed identity
+	CheckManagedIdentity bool
+	// ManagedIdentityClientID is the client ID of the user-assigned managed identity used for the
+	// token check. The system-assigned managed identity is used if not set.
+	ManagedIdentityClientID string
+	// CheckEndpoints checks that the Key Vault DNS and the Azure AD authority are reachable
+	CheckEndpoints bool
+	// MountErrorThreshold is the ratio of the mounts in the window that failed because Key Vault or
+	// Azure AD were unavailable above which the provider isn't ready. The ratio isn't checked if 0.
+	MountErrorThreshold float64
+	// MountErrorWindow is the duration of the window of the mounts used for the error ratio
+	MountErrorWindow time.Duration
+	// MountErrorMinMounts is the number of mounts in the window required to check the error ratio
+	MountErrorMinMounts int
+	// Interval is the interval of the managed identity and endpoint checks and of the
+	// notifications of the health status watchers
+	Interval time.Duration
+	// Timeout is the timeout of the managed identity and endpoint checks
+	Timeout time.Duration
+}
+
+// mountResult is the result of a mount in the mount error window
+type mountResult struct {
+	time   time.Time
+	failed bool
+}
+
+// Readiness reports whether the provider can serve the mounts based on the reachability of
+// the dependencies and the recent mount errors
+type Readiness struct {
+	config ReadinessConfig
+
+	mu sync.Mutex
+	// dependencyErr is the error of the last managed identity and endpoint checks
+	dependencyErr error
+	mounts        []mountResult
+	// onChange is called when the readiness may have changed
+	onChange func()
+	// circuitBreaker is the circuit breaker of the provider whose open circuits are reported
+	// in the details. The open circuits don't make the provider not ready. Nil if not enabled.
+	circuitBreaker *breaker.Breaker
+
+	// the checks are replaced in the tests
+	getToken   func(ctx context.Context) error
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// NewReadiness returns the readiness checker for the config
+func NewReadiness(config ReadinessConfig) *Readiness {
+	r := &Readiness{
+		config:     config,
+		lookupHost: net.DefaultResolver.LookupHost,
+		httpClient: &http.Client{},
+		now:        time.Now,
+	}
+	r.getToken = r.getManagedIdentityToken
+	return r
+}
+
+// Run runs the managed identity and endpoint checks at the interval until the context is done.
+// The change is also notified at the interval as the mounts leave the mount error window.
+func (r *Readiness) Run(ctx context.Context) {
+	checkDependencies := r.config.CheckManagedIdentity || r.config.CheckEndpoints
+	if !checkDependencies && r.config.MountErrorThreshold <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+	for {
+		if checkDependencies {
+			r.checkDependencies(ctx)
+		} else {
+			r.notify()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkDependencies runs the managed identity and endpoint checks and notifies the change
+func (r *Readiness) checkDependencies(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	var errs []error
+	if r.config.CheckEndpoints {
+		if err := r.checkKeyVaultDNS(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		if err := r.checkAuthority(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if r.config.CheckManagedIdentity {
+		if err := r.getToken(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to get token with the managed identity, error: %w", err))
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		klog.ErrorS(err, "readiness check failed")
+	}
+
+	r.mu.Lock()
+	r.dependencyErr = err
+	r.mu.Unlock()
+	r.notify()
+}
+
+// checkKeyVaultDNS checks that the DNS server answers for the Key Vault domain. The domain doesn't
+// need to have an address, so only the failures to get an answer are returned.
+func (r *Readiness) checkKeyVaultDNS(ctx context.Context) error {
+	host := r.config.CloudEnvironment.KeyVaultDNSSuffix
+	if _, err := r.lookupHost(ctx, host); err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to resolve key vault domain %s, error: %w", host, err)
+	}
+	return nil
+}
+
+// checkAuthority checks that the Azure AD authority answers HTTP requests
+func (r *Readiness) checkAuthority(ctx context.Context) error {
+	authority := r.config.CloudEnvironment.ActiveDirectoryEndpoint
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authority, nil)
+	if err != nil {
+		return fmt.Errorf("invalid Azure AD authority %s, error: %w", authority, err)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Azure AD authority %s, error: %w", authority, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// getManagedIdentityToken requests a Key Vault token with the node managed identity
+func (r *Readiness) getManagedIdentityToken(ctx context.Context) error {
+	authConfig := auth.Config{UseVMManagedIdentity: true, UserAssignedIdentityID: r.config.ManagedIdentityClientID}
+	resource := strings.TrimSuffix(r.config.CloudEnvironment.KeyVaultEndpoint, "/")
+	cred, err := authConfig.GetCredential(ctx, "", "", resource, r.config.CloudEnvironment.Configuration(), "", "")
+	if err != nil {
+		return err
+	}
+	_, err = cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{resource + "/.default"}})
+	return err
+}
+
+// RecordMount records the result of the mount for the mount error ratio. Only the errors caused by
+// Key Vault or Azure AD being unavailable are counted as failures, the errors of a single
+// misconfigured SecretProviderClass don't make the provider not ready.
+func (r *Readiness) RecordMount(err error) {
+	if r.config.MountErrorThreshold <= 0 {
+		return
+	}
+	if err != nil && provider.ErrorCodeOf(err) == provider.ErrorCodeInvalidConfig {
+		return
+	}
+	now := r.now()
+	r.mu.Lock()
+	r.mounts = append(r.pruneMounts(now), mountResult{time: now, failed: provider.IsTransient(err)})
+	r.mu.Unlock()
+	r.notify()
+}
+
+// pruneMounts returns the mounts in the window. The caller must hold the lock.
+func (r *Readiness) pruneMounts(now time.Time) []mountResult {
+	i := 0
+	for i < len(r.mounts) && now.Sub(r.mounts[i].time) > r.config.MountErrorWindow {
+		i++
+	}
+	return r.mounts[i:]
+}
+
+// Ready returns nil if the provider is ready or the reason it isn't ready
+func (r *Readiness) Ready() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.dependencyErr != nil {
+		return r.dependencyErr
+	}
+	if r.config.MountErrorThreshold <= 0 {
+		return nil
+	}
+	r.mounts = r.pruneMounts(r.now())
+	if len(r.mounts) == 0 || len(r.mounts) < r.config.MountErrorMinMounts {
+		return nil
+	}
+	failed := 0
+	for _, m := range r.mounts {
+		if m.failed {
+			failed++
+		}
+	}
+	if ratio := float64(failed) / float64(len(r.mounts)); ratio > r.config.MountErrorThreshold {
+		return fmt.Errorf("%d of the last %d mounts failed because key vault or azure AD were unavailable", failed, len(r.mounts))
+	}
+	return nil
+}
+
+// SetCircuitBreaker sets the circuit breaker whose open circuits are reported in the details
+func (r *Readiness) SetCircuitBreaker(b *breaker.Breaker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.circuitBreaker = b
+}
+
+// Details returns the informational details of the readiness that don't make the provider
+// not ready, e.g. the open circuits of the circuit breaker. A circuit is opened by the
+// failures of a single vault or identity, so the other mounts can still be served.
+func (r *Readiness) Details() []string {
+	r.mu.Lock()
+	b := r.circuitBreaker
+	r.mu.Unlock()
+	if b == nil {
+		return nil
+	}
+	open := b.OpenCircuits()
+	if len(open) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(open))
+	for _, key := range open {
+		keys = append(keys, key.String())
+	}
+	return []string{"circuit breaker open for " + strings.Join(keys, ", ")}
+}
+
+// notify calls the change callback, the readiness is read again by the callers of Ready
+func (r *Readiness) notify() {
+	if r.onChange != nil {
+		r.onChange()
+	}
+}
+
+// Validate checks if the readiness config is valid
+func (c ReadinessConfig) Validate() error {
+	if c.MountErrorThreshold < 0 || c.MountErrorThreshold > 1 {
+		return fmt.Errorf("invalid mount error threshold %v, should be between 0 and 1", c.MountErrorThreshold)
+	}
+	if c.MountErrorThreshold > 0 && c.MountErrorWindow <= 0 {
+		return fmt.Errorf("mount error window should be greater than 0")
+	}
+	if (c.CheckManagedIdentity || c.CheckEndpoints || c.MountErrorThreshold > 0) && (c.Interval <= 0 || c.Timeout <= 0) {
+		return fmt.Errorf("readiness check interval and timeout should be greater than 0")
+	}
+	return nil
+}
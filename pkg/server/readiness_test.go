@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/breaker"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cloud"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+func TestReadinessMountErrors(t *testing.T) {
+	now := time.Now()
+	r := NewReadiness(ReadinessConfig{
+		MountErrorThreshold: 0.5,
+		MountErrorWindow:    time.Minute,
+		MountErrorMinMounts: 2,
+	})
+	r.now = func() time.Time { return now }
+
+	unavailable := &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable}
+	notFound := &azcore.ResponseError{StatusCode: http.StatusNotFound}
+
+	// not enough mounts in the window
+	r.RecordMount(unavailable)
+	if err := r.Ready(); err != nil {
+		t.Fatalf("Ready() = %v, want nil", err)
+	}
+	// non transient errors are not counted as failures
+	r.RecordMount(notFound)
+	if err := r.Ready(); err != nil {
+		t.Fatalf("Ready() = %v, want nil", err)
+	}
+	r.RecordMount(unavailable)
+	if err := r.Ready(); err == nil {
+		t.Fatalf("Ready() = nil, want error for 2 of 3 failed mounts")
+	}
+	// the failed mounts leave the window
+	now = now.Add(2 * time.Minute)
+	r.RecordMount(nil)
+	if err := r.Ready(); err != nil {
+		t.Fatalf("Ready() = %v, want nil", err)
+	}
+}
+
+func TestReadinessCheckDependencies(t *testing.T) {
+	authority := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer authority.Close()
+
+	cases := []struct {
+		desc        string
+		lookupErr   error
+		authority   string
+		tokenErr    error
+		expectedErr bool
+	}{
+		{
+			desc:      "dependencies reachable",
+			authority: authority.URL,
+		},
+		{
+			desc:      "key vault domain without address",
+			lookupErr: &net.DNSError{Err: "no such host", IsNotFound: true},
+			authority: authority.URL,
+		},
+		{
+			desc:        "DNS server not reachable",
+			lookupErr:   &net.DNSError{Err: "i/o timeout", IsTimeout: true},
+			authority:   authority.URL,
+			expectedErr: true,
+		},
+		{
+			desc:        "authority not reachable",
+			authority:   "http://127.0.0.1:0",
+			expectedErr: true,
+		},
+		{
+			desc:        "managed identity token failure",
+			authority:   authority.URL,
+			tokenErr:    errors.New("identity not found"),
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := NewReadiness(ReadinessConfig{
+				CloudEnvironment: cloud.Environment{
+					ActiveDirectoryEndpoint: tc.authority,
+					KeyVaultDNSSuffix:       "vault.azure.net",
+				},
+				CheckManagedIdentity: true,
+				CheckEndpoints:       true,
+				Timeout:              5 * time.Second,
+			})
+			r.lookupHost = func(_ context.Context, _ string) ([]string, error) { return nil, tc.lookupErr }
+			r.getToken = func(_ context.Context) error { return tc.tokenErr }
+
+			notified := false
+			r.onChange = func() { notified = true }
+			r.checkDependencies(context.Background())
+
+			if !notified {
+				t.Fatalf("expected the change to be notified")
+			}
+			if err := r.Ready(); tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestReadinessDetails(t *testing.T) {
+	r := NewReadiness(ReadinessConfig{})
+	if details := r.Details(); len(details) != 0 {
+		t.Fatalf("Details() = %v, want none without circuit breaker", details)
+	}
+
+	b, err := breaker.New(breaker.Config{FailureThreshold: 1, OpenDuration: time.Minute})
+	if err != nil {
+		t.Fatalf("breaker.New() = %v", err)
+	}
+	r.SetCircuitBreaker(b)
+	key := breaker.Key{Vault: "kv1.vault.azure.net", Identity: "client1"}
+	b.Record(key, errors.New("vault unavailable"), true)
+
+	// the open circuit is reported but doesn't make the provider not ready
+	expected := "circuit breaker open for kv1.vault.azure.net (client1)"
+	if details := r.Details(); len(details) != 1 || details[0] != expected {
+		t.Fatalf("Details() = %v, want [%s]", details, expected)
+	}
+	if err := r.Ready(); err != nil {
+		t.Fatalf("Ready() = %v, want nil", err)
+	}
+
+	b.Record(key, nil, false)
+	if details := r.Details(); len(details) != 0 {
+		t.Fatalf("Details() = %v, want none after the circuit is closed", details)
+	}
+}
+
+func TestReadinessConfigValidate(t *testing.T) {
+	cases := []struct {
+		desc        string
+		config      ReadinessConfig
+		expectedErr bool
+	}{
+		{
+			desc: "checks disabled",
+		},
+		{
+			desc:        "invalid mount error threshold",
+			config:      ReadinessConfig{MountErrorThreshold: 1.5, MountErrorWindow: time.Minute, Interval: time.Minute, Timeout: time.Second},
+			expectedErr: true,
+		},
+		{
+			desc:        "mount error window not set",
+			config:      ReadinessConfig{MountErrorThreshold: 0.5, Interval: time.Minute, Timeout: time.Second},
+			expectedErr: true,
+		},
+		{
+			desc:        "interval not set",
+			config:      ReadinessConfig{CheckEndpoints: true, Timeout: time.Second},
+			expectedErr: true,
+		},
+		{
+			desc:   "valid config",
+			config: ReadinessConfig{CheckEndpoints: true, MountErrorThreshold: 0.5, MountErrorWindow: time.Minute, Interval: time.Minute, Timeout: time.Second},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if err := tc.config.Validate(); tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -2,12 +2,15 @@ package server
 
 import (
 	"encoding/json"
-	"fmt"
 	"os"
+	"strings"
+	"sync"
 
-	"github.com/Azure/go-autorest/autorest/azure"
-
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/cloud"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/tracing"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/version"
 
 	"golang.org/x/net/context"
@@ -23,45 +26,71 @@ import (
 type CSIDriverProviderServer struct {
 	*grpc.Server
 	provider provider.Interface
+	reporter metrics.StatsReporter
+	// health is the serving status reported by the health service. The server is always serving if not set.
+	health *HealthManager
+
+	// unsupportedFileAttributesOnce logs the warning for the unsupported file ownership and
+	// SELinux labels once, the objects with the attributes are logged for every mount at V(2)
+	unsupportedFileAttributesOnce sync.Once
 }
 
 // New returns an instance of CSIDriverProviderServer
-func New(constructPEMChain, writeCertAndKeyInSeparateFiles bool, defaultCloudEnvironment azure.Environment) *CSIDriverProviderServer {
+func New(constructPEMChain, writeCertAndKeyInSeparateFiles bool, defaultCloudEnvironment cloud.Environment, opts ...provider.Option) *CSIDriverProviderServer {
 	return &CSIDriverProviderServer{
-		provider: provider.NewProvider(constructPEMChain, writeCertAndKeyInSeparateFiles, defaultCloudEnvironment),
+		provider: provider.NewProvider(constructPEMChain, writeCertAndKeyInSeparateFiles, defaultCloudEnvironment, opts...),
+		reporter: metrics.NewStatsReporter(),
 	}
 }
 
+// SetHealthManager sets the serving status reported by the health service and
+// records the results of the mounts for the mount error ratio
+func (s *CSIDriverProviderServer) SetHealthManager(m *HealthManager) {
+	s.health = m
+}
+
 // Mount executes the mount operation in the provider. The provider fetches the objects from Key Vault
 // writes the contents to the pod mount and returns the object versions as part of MountResponse
-func (s *CSIDriverProviderServer) Mount(ctx context.Context, req *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
+func (s *CSIDriverProviderServer) Mount(ctx context.Context, req *v1alpha1.MountRequest) (_ *v1alpha1.MountResponse, err error) {
 	var attrib, secret map[string]string
 	var defaultFilePermission os.FileMode
-	var err error
+
+	ctx, span := tracing.StartSpan(ctx, "Mount")
+	defer func() { tracing.EndSpan(span, err) }()
 
 	err = json.Unmarshal([]byte(req.GetAttributes()), &attrib)
 	if err != nil {
 		klog.ErrorS(err, "failed to unmarshal attributes")
-		return &v1alpha1.MountResponse{}, fmt.Errorf("failed to unmarshal attributes, error: %w", err)
+		return &v1alpha1.MountResponse{}, status.Errorf(codes.InvalidArgument, "failed to unmarshal attributes, error: %v", err)
 	}
+	span.SetAttributes(
+		tracing.PodNameKey.String(attrib[types.CSIAttributePodName]),
+		tracing.PodNamespaceKey.String(attrib[types.CSIAttributePodNamespace]),
+		tracing.SecretProviderClassKey.String(attrib[types.CSIAttributeSecretProviderClass]),
+	)
 	err = json.Unmarshal([]byte(req.GetSecrets()), &secret)
 	if err != nil {
 		klog.ErrorS(err, "failed to unmarshal node publish secrets ref")
-		return &v1alpha1.MountResponse{}, fmt.Errorf("failed to unmarshal secrets, error: %w", err)
+		return &v1alpha1.MountResponse{}, status.Errorf(codes.InvalidArgument, "failed to unmarshal secrets, error: %v", err)
 	}
 	err = json.Unmarshal([]byte(req.GetPermission()), &defaultFilePermission)
 	if err != nil {
 		klog.ErrorS(err, "failed to unmarshal file permission")
-		return &v1alpha1.MountResponse{}, fmt.Errorf("failed to unmarshal file permission, error: %w", err)
+		return &v1alpha1.MountResponse{}, status.Errorf(codes.InvalidArgument, "failed to unmarshal file permission, error: %v", err)
 	}
 
 	files, err := s.provider.GetSecretsStoreObjectContent(ctx, attrib, secret, defaultFilePermission)
+	if s.health != nil {
+		s.health.RecordMount(err)
+	}
 	if err != nil {
 		klog.ErrorS(err, "failed to process mount request")
-		return &v1alpha1.MountResponse{}, fmt.Errorf("failed to mount objects, error: %w", err)
+		span.SetAttributes(tracing.ErrorCodeKey.String(string(provider.ErrorCodeOf(err))))
+		return &v1alpha1.MountResponse{}, status.Errorf(grpcCode(err), "failed to mount objects, error: %v", err)
 	}
 	ov := []*v1alpha1.ObjectVersion{}
 	f := []*v1alpha1.File{}
+	unsupported := []string{}
 	// CSI driver v0.0.21+ will write to the filesystem if the files are in the response.
 	// No files in the response translates to "not implemented" in the CSI driver.
 	for _, file := range files {
@@ -70,6 +99,11 @@ func (s *CSIDriverProviderServer) Mount(ctx context.Context, req *v1alpha1.Mount
 			Contents: file.Content,
 			Mode:     file.FileMode,
 		})
+		// the driver API only carries the file mode, so the file is written without
+		// the owner, group and SELinux label instead of failing the mount
+		if file.FileOwner != nil || file.FileGroup != nil || file.SELinuxContext != "" {
+			unsupported = append(unsupported, file.Path)
+		}
 
 		ov = append(ov, &v1alpha1.ObjectVersion{
 			Id:      file.UID,
@@ -77,12 +111,66 @@ func (s *CSIDriverProviderServer) Mount(ctx context.Context, req *v1alpha1.Mount
 		})
 	}
 
+	s.reportObjectVersionChanges(ctx, req.GetCurrentObjectVersion(), ov)
+
+	if len(unsupported) > 0 {
+		s.unsupportedFileAttributesOnce.Do(func() {
+			klog.InfoS("fileOwner, fileGroup and fileSELinuxContext are not supported by the Secrets Store CSI Driver, the files are written with the file mode only")
+		})
+		klog.V(2).InfoS("file attributes not applied", "files", unsupported, "pod", klog.ObjectRef{Namespace: attrib[types.CSIAttributePodNamespace], Name: attrib[types.CSIAttributePodName]})
+	}
+
 	return &v1alpha1.MountResponse{
 		ObjectVersion: ov,
 		Files:         f,
 	}, nil
 }
 
+// reportObjectVersionChanges reports the objects with a version different from the version
+// currently in the mount. The objects not in the mount yet are not reported as changed.
+func (s *CSIDriverProviderServer) reportObjectVersionChanges(ctx context.Context, current, updated []*v1alpha1.ObjectVersion) {
+	currentVersions := make(map[string]string, len(current))
+	for _, ov := range current {
+		currentVersions[ov.GetId()] = ov.GetVersion()
+	}
+	// the files of the same object are reported with the same id and version
+	reported := make(map[string]bool)
+	for _, ov := range updated {
+		version, ok := currentVersions[ov.GetId()]
+		if !ok || version == ov.GetVersion() || reported[ov.GetId()] {
+			continue
+		}
+		reported[ov.GetId()] = true
+		// the id is in the format <object type>/<object name>[/<version file name>]
+		parts := strings.SplitN(ov.GetId(), "/", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		klog.V(2).InfoS("object version changed", "id", ov.GetId(), "previousVersion", version, "version", ov.GetVersion())
+		s.reporter.ReportObjectVersionChange(ctx, parts[0], parts[1])
+	}
+}
+
+// grpcCode maps the typed provider error to the gRPC status code returned to the driver
+func grpcCode(err error) codes.Code {
+	switch provider.ErrorCodeOf(err) {
+	case provider.ErrorCodeInvalidConfig:
+		return codes.InvalidArgument
+	case provider.ErrorCodeAuthFailed:
+		return codes.Unauthenticated
+	case provider.ErrorCodePermissionDenied:
+		return codes.PermissionDenied
+	case provider.ErrorCodeNotFound:
+		return codes.NotFound
+	case provider.ErrorCodeThrottled:
+		return codes.ResourceExhausted
+	case provider.ErrorCodeUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
+
 func (s *CSIDriverProviderServer) Version(_ context.Context, _ *v1alpha1.VersionRequest) (*v1alpha1.VersionResponse, error) {
 	return &v1alpha1.VersionResponse{
 		Version:        "v1alpha1",
@@ -91,13 +179,53 @@ func (s *CSIDriverProviderServer) Version(_ context.Context, _ *v1alpha1.Version
 	}, nil
 }
 
+// Check returns the serving status of the provider. The status is NOT_SERVING while the
+// readiness checks fail or during the graceful shutdown. Open circuits of the circuit
+// breaker don't change the status.
 func (s *CSIDriverProviderServer) Check(_ context.Context, _ *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
 	return &grpc_health_v1.HealthCheckResponse{
-		Status: grpc_health_v1.HealthCheckResponse_SERVING,
+		Status: s.servingStatus(),
 	}, nil
 }
 
-// Watch for the serving status of the requested service.
-func (s *CSIDriverProviderServer) Watch(_ *grpc_health_v1.HealthCheckRequest, _ grpc_health_v1.Health_WatchServer) error {
-	return status.Error(codes.Unimplemented, "Watch is not supported")
+// Watch streams the serving status of the provider. The current status is sent first and
+// then every time the status changes until the client cancels the stream or the server
+// shuts down.
+func (s *CSIDriverProviderServer) Watch(_ *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	var updates, shutdown <-chan struct{}
+	if s.health != nil {
+		var stop func()
+		updates, stop = s.health.Subscribe()
+		defer stop()
+		shutdown = s.health.Done()
+	}
+
+	last := grpc_health_v1.HealthCheckResponse_UNKNOWN
+	for {
+		if current := s.servingStatus(); current != last {
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: current}); err != nil {
+				return err
+			}
+			last = current
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-shutdown:
+			// the NOT_SERVING status is sent before the stream ends
+			if current := s.servingStatus(); current != last {
+				return stream.Send(&grpc_health_v1.HealthCheckResponse{Status: current})
+			}
+			return nil
+		case <-updates:
+		}
+	}
+}
+
+// servingStatus returns the status of the health manager, SERVING if not set
+func (s *CSIDriverProviderServer) servingStatus() grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if s.health == nil {
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	return s.health.Status()
 }
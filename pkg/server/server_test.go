@@ -2,14 +2,22 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"reflect"
 	"testing"
 
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/metrics"
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/mock_provider"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider/types"
 	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/version"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
 
@@ -45,9 +53,78 @@ func TestMountError(t *testing.T) {
 			testServer := &CSIDriverProviderServer{
 				provider: mock_provider.NewMockInterface(ctrl),
 			}
-			if _, err := testServer.Mount(context.TODO(), tc.mountRequest); err == nil {
+			_, err := testServer.Mount(context.TODO(), tc.mountRequest)
+			if err == nil {
 				t.Fatalf("Mount() expected error, got nil")
 			}
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("Mount() expected code: %v, got: %v", codes.InvalidArgument, status.Code(err))
+			}
+		})
+	}
+}
+
+func TestMountErrorCode(t *testing.T) {
+	cases := []struct {
+		desc         string
+		err          error
+		expectedCode codes.Code
+	}{
+		{
+			desc:         "invalid config",
+			err:          &provider.Error{Code: provider.ErrorCodeInvalidConfig, Err: errors.New("objects is not set")},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "auth failed",
+			err:          &provider.Error{Code: provider.ErrorCodeAuthFailed, Err: errors.New("failed to get token")},
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			desc:         "permission denied",
+			err:          fmt.Errorf("wrapped: %w", &azcore.ResponseError{StatusCode: http.StatusForbidden}),
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			desc:         "not found",
+			err:          &azcore.ResponseError{StatusCode: http.StatusNotFound},
+			expectedCode: codes.NotFound,
+		},
+		{
+			desc:         "throttled",
+			err:          &azcore.ResponseError{StatusCode: http.StatusTooManyRequests},
+			expectedCode: codes.ResourceExhausted,
+		},
+		{
+			desc:         "unavailable",
+			err:          &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable},
+			expectedCode: codes.Unavailable,
+		},
+		{
+			desc:         "unknown",
+			err:          errors.New("unknown error"),
+			expectedCode: codes.Unknown,
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			mockProvider := mock_provider.NewMockInterface(ctrl)
+			mockProvider.EXPECT().GetSecretsStoreObjectContent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, tc.err)
+			testServer := &CSIDriverProviderServer{
+				provider: mockProvider,
+			}
+			_, err := testServer.Mount(context.TODO(), &v1alpha1.MountRequest{
+				Attributes: `{"keyvaultName":"kv"}`,
+				Secrets:    `{"clientid":"foo","clientsecret":"bar"}`,
+				Permission: "420",
+			})
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("Mount() expected code: %v, got: %v", tc.expectedCode, status.Code(err))
+			}
 		})
 	}
 }
@@ -89,6 +166,76 @@ func TestMount(t *testing.T) {
 	}
 }
 
+func TestMountUnsupportedFileAttributes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	owner := int64(1000)
+	testServer := &CSIDriverProviderServer{}
+	mockProvider := mock_provider.NewMockInterface(ctrl)
+	mockProvider.EXPECT().GetSecretsStoreObjectContent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
+		[]types.SecretFile{
+			{
+				Content:        []byte("foo"),
+				Path:           "foo.txt",
+				Version:        "1",
+				FileMode:       0440,
+				FileOwner:      &owner,
+				SELinuxContext: "system_u:object_r:container_file_t:s0",
+			},
+		}, nil,
+	)
+	testServer.provider = mockProvider
+	// the owner and SELinux label can't be sent to the driver, the file is still mounted with the mode
+	response, err := testServer.Mount(context.TODO(), &v1alpha1.MountRequest{
+		Attributes: `{"keyvaultName":"kv"}`,
+		Secrets:    `{"clientid":"foo","clientsecret":"bar"}`,
+		Permission: "420",
+	})
+	if err != nil {
+		t.Fatalf("Mount() expected no error, got %v", err)
+	}
+	if len(response.Files) != 1 || response.Files[0].Mode != 0440 {
+		t.Fatalf("Mount() unexpected files: %v", response.Files)
+	}
+}
+
+// versionChangeReporter records the objects reported with a version change
+type versionChangeReporter struct {
+	metrics.StatsReporter
+	changed []string
+}
+
+func (r *versionChangeReporter) ReportObjectVersionChange(_ context.Context, objectType, objectName string) {
+	r.changed = append(r.changed, objectType+"/"+objectName)
+}
+
+func TestReportObjectVersionChanges(t *testing.T) {
+	current := []*v1alpha1.ObjectVersion{
+		{Id: "secret/secret1", Version: "v1"},
+		{Id: "secret/secret2", Version: "v1"},
+		{Id: "cert/cert1/0", Version: "v1"},
+	}
+	updated := []*v1alpha1.ObjectVersion{
+		// the files of the same object have the same id and version
+		{Id: "secret/secret1", Version: "v2"},
+		{Id: "secret/secret1", Version: "v2"},
+		{Id: "secret/secret2", Version: "v1"},
+		{Id: "cert/cert1/0", Version: "v2"},
+		// new objects are not reported as changed
+		{Id: "key/key1", Version: "v1"},
+	}
+
+	reporter := &versionChangeReporter{}
+	testServer := &CSIDriverProviderServer{reporter: reporter}
+	testServer.reportObjectVersionChanges(context.TODO(), current, updated)
+
+	expected := []string{"secret/secret1", "cert/cert1"}
+	if !reflect.DeepEqual(reporter.changed, expected) {
+		t.Fatalf("expected changed objects %v, got %v", expected, reporter.changed)
+	}
+}
+
 func TestVersion(t *testing.T) {
 	testServer := &CSIDriverProviderServer{}
 	version.BuildVersion = "test"
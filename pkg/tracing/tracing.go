@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/version"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// tracerName is the name of the tracer and the service name of the spans
+	tracerName = "csi-secrets-store-provider-azure"
+
+	// ObjectTypeKey is the attribute key for the type of the key vault object
+	ObjectTypeKey = attribute.Key("keyvault.object_type")
+	// ObjectNameKey is the attribute key for the name of the key vault object
+	ObjectNameKey = attribute.Key("keyvault.object_name")
+	// ObjectVersionKey is the attribute key for the version of the key vault object
+	ObjectVersionKey = attribute.Key("keyvault.object_version")
+	// VaultURLKey is the attribute key for the URL of the key vault
+	VaultURLKey = attribute.Key("keyvault.url")
+	// AuthModeKey is the attribute key for the identity mode used to get the credential
+	AuthModeKey = attribute.Key("auth.mode")
+	// PodNameKey is the attribute key for the name of the pod the objects are mounted in
+	PodNameKey = attribute.Key("k8s.pod.name")
+	// PodNamespaceKey is the attribute key for the namespace of the pod
+	PodNamespaceKey = attribute.Key("k8s.namespace.name")
+	// SecretProviderClassKey is the attribute key for the name of the SecretProviderClass
+	SecretProviderClassKey = attribute.Key("secretproviderclass")
+	// ErrorCodeKey is the attribute key for the classification of the error
+	ErrorCodeKey = attribute.Key("error_code")
+)
+
+// Config is the configuration of the span exporter
+type Config struct {
+	// OTLPEndpoint is the host:port of the OTLP gRPC collector. Tracing is disabled if not set.
+	OTLPEndpoint string
+	// Insecure disables TLS for the connection to the collector
+	Insecure bool
+	// SampleRatio is the ratio of the traces sampled when the parent span isn't sampled by the caller
+	SampleRatio float64
+}
+
+// InitTracing installs the global tracer provider that exports the spans to the OTLP collector.
+// The returned function flushes the pending spans and stops the exporter. When the endpoint isn't
+// set, the spans are not recorded and the returned function is a no-op.
+func InitTracing(ctx context.Context, config Config) (func(context.Context) error, error) {
+	if config.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid trace sample ratio %v, should be between 0 and 1", config.SampleRatio)
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(config.OTLPEndpoint)}
+	if config.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter, error: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", tracerName),
+			attribute.String("service.version", version.BuildVersion),
+			attribute.String("os_type", runtime.GOOS),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	klog.InfoS("tracing enabled", "endpoint", config.OTLPEndpoint, "sampleRatio", config.SampleRatio)
+
+	// shutting down the tracer provider also stops the exporter
+	return tp.Shutdown, nil
+}
+
+// StartSpan starts a span with the attributes as a child of the span in the context.
+// The content of the objects must never be added to the attributes.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// SetAttributes adds the attributes to the span in the context
+func SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
+
+// EndSpan records the error in the span if it's not nil and ends the span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
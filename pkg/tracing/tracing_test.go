@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInitTracing(t *testing.T) {
+	cases := []struct {
+		desc        string
+		config      Config
+		expectedErr bool
+	}{
+		{
+			desc:   "tracing disabled",
+			config: Config{SampleRatio: 2},
+		},
+		{
+			desc:        "sample ratio less than 0",
+			config:      Config{OTLPEndpoint: "localhost:4317", SampleRatio: -0.1},
+			expectedErr: true,
+		},
+		{
+			desc:        "sample ratio greater than 1",
+			config:      Config{OTLPEndpoint: "localhost:4317", SampleRatio: 1.1},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			shutdown, err := InitTracing(context.Background(), tc.config)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedErr, err)
+			}
+			if err == nil {
+				if err = shutdown(context.Background()); err != nil {
+					t.Fatalf("shutdown() = %v, want nil", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(previous)
+
+	ctx, parent := StartSpan(context.Background(), "Mount", PodNameKey.String("pod1"))
+	_, child := StartSpan(ctx, "GetObjectContent", ObjectTypeKey.String("secret"), ObjectNameKey.String("secret1"))
+	EndSpan(child, errors.New("not found"))
+	SetAttributes(ctx, VaultURLKey.String("https://test.vault.azure.net/"))
+	EndSpan(parent, nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	child1, parent1 := spans[0], spans[1]
+	if child1.Name != "GetObjectContent" || child1.Parent.SpanID() != parent1.SpanContext.SpanID() {
+		t.Fatalf("unexpected child span %s with parent %s", child1.Name, child1.Parent.SpanID())
+	}
+	if child1.Status.Code != codes.Error || len(child1.Events) != 1 {
+		t.Fatalf("expected the error to be recorded in the span, got status %v and %d events", child1.Status.Code, len(child1.Events))
+	}
+	if parent1.Status.Code == codes.Error || len(parent1.Attributes) != 2 {
+		t.Fatalf("unexpected parent span with status %v and attributes %v", parent1.Status.Code, parent1.Attributes)
+	}
+}
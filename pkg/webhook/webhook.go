@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+	secretsstorev1 "sigs.k8s.io/secrets-store-csi-driver/apis/v1"
+)
+
+const (
+	// ValidatePath is the path the SecretProviderClass validation webhook is served on
+	ValidatePath = "/validate-secretproviderclass"
+
+	// providerName is the name of the provider in the SecretProviderClass
+	providerName = "azure"
+	// maxRequestBodySize is the maximum size of the admission review request
+	maxRequestBodySize = 3 * 1024 * 1024
+)
+
+// Handler validates the parameters of the SecretProviderClass objects for the azure provider
+// on create and update, so the errors are returned before the SecretProviderClass is mounted
+type Handler struct {
+	// Options are the provider settings of the nodes the SecretProviderClasses are mounted on
+	Options provider.ValidationOptions
+}
+
+// ServeHTTP handles the admission review requests
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+		http.Error(w, fmt.Sprintf("invalid content type %q, expected application/json", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request body, error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	if err = json.Unmarshal(body, review); err != nil {
+		http.Error(w, fmt.Sprintf("failed to unmarshal admission review, error: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "admission review request is empty", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = h.review(review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	resp, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to marshal admission review, error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(resp); err != nil {
+		klog.ErrorS(err, "failed to write admission review response")
+	}
+}
+
+// review validates the SecretProviderClass in the admission request
+func (h *Handler) review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	// only the created and updated objects are validated, the object of a delete request is empty
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	spc := &secretsstorev1.SecretProviderClass{}
+	if err := json.Unmarshal(req.Object.Raw, spc); err != nil {
+		return denied(http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("failed to unmarshal SecretProviderClass, error: %v", err), nil)
+	}
+	if spc.Spec.Provider != providerName {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	err := provider.ValidateParameters(spc.Spec.Parameters, h.Options)
+	if err == nil {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	klog.V(2).InfoS("denied invalid SecretProviderClass", "secretProviderClass", klog.KRef(req.Namespace, req.Name), "operation", req.Operation, "error", err.Error())
+
+	errs := []error{err}
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		errs = agg.Errors()
+	}
+	causes := make([]metav1.StatusCause, 0, len(errs))
+	for _, e := range errs {
+		causes = append(causes, metav1.StatusCause{
+			Type:    metav1.CauseTypeFieldValueInvalid,
+			Message: e.Error(),
+			Field:   "spec.parameters",
+		})
+	}
+	message := fmt.Sprintf("SecretProviderClass %s failed validation with %d errors: %v", req.Name, len(errs), err)
+	return denied(http.StatusUnprocessableEntity, metav1.StatusReasonInvalid, message, causes)
+}
+
+// denied returns the admission response that rejects the request
+func denied(code int32, reason metav1.StatusReason, message string, causes []metav1.StatusCause) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Reason:  reason,
+			Message: message,
+			Details: &metav1.StatusDetails{Causes: causes},
+		},
+	}
+}
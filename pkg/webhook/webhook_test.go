@@ -0,0 +1,220 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/secrets-store-csi-driver-provider-azure/pkg/provider"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	secretsstorev1 "sigs.k8s.io/secrets-store-csi-driver/apis/v1"
+)
+
+// newAdmissionReview returns the admission review for the operation. The object is only set for
+// create and update, like in the requests of the API server.
+func newAdmissionReview(t *testing.T, operation admissionv1.Operation, spc *secretsstorev1.SecretProviderClass) []byte {
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "uid",
+			Name:      spc.Name,
+			Namespace: spc.Namespace,
+			Operation: operation,
+		},
+	}
+	if operation == admissionv1.Create || operation == admissionv1.Update {
+		raw, err := json.Marshal(spc)
+		if err != nil {
+			t.Fatalf("failed to marshal SecretProviderClass: %v", err)
+		}
+		review.Request.Object = runtime.RawExtension{Raw: raw}
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatalf("failed to marshal admission review: %v", err)
+	}
+	return body
+}
+
+func newSecretProviderClass(provider secretsstorev1.Provider, parameters map[string]string) *secretsstorev1.SecretProviderClass {
+	return &secretsstorev1.SecretProviderClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "spc1", Namespace: "default"},
+		Spec: secretsstorev1.SecretProviderClassSpec{
+			Provider:   provider,
+			Parameters: parameters,
+		},
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	invalid := newSecretProviderClass("azure", map[string]string{"objects": "invalid"})
+	cases := []struct {
+		desc            string
+		operation       admissionv1.Operation
+		options         provider.ValidationOptions
+		spc             *secretsstorev1.SecretProviderClass
+		expectedAllowed bool
+		expectedCauses  int
+	}{
+		{
+			desc: "valid SecretProviderClass",
+			spc: newSecretProviderClass("azure", map[string]string{
+				"keyvaultName": "kv1",
+				"tenantId":     "tid",
+				"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret`,
+			}),
+			expectedAllowed: true,
+		},
+		{
+			desc:            "SecretProviderClass for another provider",
+			spc:             newSecretProviderClass("vault", map[string]string{"objects": "invalid"}),
+			expectedAllowed: true,
+		},
+		{
+			desc: "all errors are returned",
+			spc: newSecretProviderClass("azure", map[string]string{
+				"keyvaultName": "kv1",
+				"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret
+    objectEncoding: base32
+  - |
+    objectName: secret2
+    objectType: secret
+  - |
+    objectName: secret3
+    objectType: secret
+    objectAlias: secret2`,
+			}),
+			expectedAllowed: false,
+			// tenantId, objectEncoding and the file path conflict
+			expectedCauses: 3,
+		},
+		{
+			desc:      "file paths that differ in case on a case-insensitive file system",
+			operation: admissionv1.Update,
+			options:   provider.ValidationOptions{CaseInsensitivePaths: true},
+			spc: newSecretProviderClass("azure", map[string]string{
+				"keyvaultName": "kv1",
+				"tenantId":     "tid",
+				"objects": `
+array:
+  - |
+    objectName: secret1
+    objectType: secret
+  - |
+    objectName: Secret1
+    objectType: secret`,
+			}),
+			expectedAllowed: false,
+			expectedCauses:  1,
+		},
+		{
+			desc:            "delete is allowed",
+			operation:       admissionv1.Delete,
+			spc:             invalid,
+			expectedAllowed: true,
+		},
+		{
+			desc:            "connect is allowed",
+			operation:       admissionv1.Connect,
+			spc:             invalid,
+			expectedAllowed: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			operation := tc.operation
+			if operation == "" {
+				operation = admissionv1.Create
+			}
+			req := httptest.NewRequest(http.MethodPost, ValidatePath, bytes.NewReader(newAdmissionReview(t, operation, tc.spc)))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			(&Handler{Options: tc.options}).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			review := &admissionv1.AdmissionReview{}
+			if err := json.Unmarshal(rec.Body.Bytes(), review); err != nil {
+				t.Fatalf("failed to unmarshal admission review: %v", err)
+			}
+			if review.Response == nil || review.Response.UID != "uid" {
+				t.Fatalf("unexpected admission response: %+v", review.Response)
+			}
+			if review.Response.Allowed != tc.expectedAllowed {
+				t.Fatalf("expected allowed: %v, got: %v", tc.expectedAllowed, review.Response.Allowed)
+			}
+			if tc.expectedAllowed {
+				return
+			}
+			if review.Response.Result == nil || review.Response.Result.Details == nil || len(review.Response.Result.Details.Causes) != tc.expectedCauses {
+				t.Fatalf("expected %d causes, got: %+v", tc.expectedCauses, review.Response.Result)
+			}
+		})
+	}
+}
+
+func TestServeHTTPError(t *testing.T) {
+	cases := []struct {
+		desc         string
+		method       string
+		contentType  string
+		body         string
+		expectedCode int
+	}{
+		{
+			desc:         "invalid method",
+			method:       http.MethodGet,
+			contentType:  "application/json",
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+		{
+			desc:         "invalid content type",
+			method:       http.MethodPost,
+			contentType:  "text/plain",
+			body:         "{}",
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			desc:         "invalid body",
+			method:       http.MethodPost,
+			contentType:  "application/json",
+			body:         "{",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			desc:         "empty request",
+			method:       http.MethodPost,
+			contentType:  "application/json",
+			body:         "{}",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, ValidatePath, bytes.NewReader([]byte(tc.body)))
+			req.Header.Set("Content-Type", tc.contentType)
+			rec := httptest.NewRecorder()
+			(&Handler{}).ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedCode {
+				t.Fatalf("expected status code %d, got %d", tc.expectedCode, rec.Code)
+			}
+		})
+	}
+}